@@ -3,8 +3,18 @@ module github.com/somethingsoftware/violet-web
 go 1.23rc2
 
 require (
+	github.com/andybalholm/brotli v1.1.1
 	github.com/glebarez/go-sqlite v1.22.0
+	github.com/microcosm-cc/bluemonday v1.0.27
+	github.com/yuin/goldmark v1.7.8
 	golang.org/x/crypto v0.28.0
+	golang.org/x/image v0.21.0
+)
+
+require (
+	github.com/aymerick/douceur v0.2.0 // indirect
+	github.com/gorilla/css v1.0.1 // indirect
+	golang.org/x/net v0.26.0 // indirect
 )
 
 require (
@@ -0,0 +1,142 @@
+package account
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/somethingsoftware/violet-web/http/audit"
+	"github.com/somethingsoftware/violet-web/http/avatar"
+	"github.com/somethingsoftware/violet-web/http/session"
+	"github.com/somethingsoftware/violet-web/http/storage"
+)
+
+// IsAdmin reports whether the user has the admin role
+func IsAdmin(db *sql.DB, userID uint64) (bool, error) {
+	var role string
+	if err := db.QueryRow("SELECT role FROM user WHERE id = ?;", userID).Scan(&role); err != nil {
+		return false, fmt.Errorf("failed to get user role: %w", err)
+	}
+	return role == "admin", nil
+}
+
+// Delete removes the user and their avatar, the foreign keys take every row
+// that belongs to them along
+func Delete(ctx context.Context, db *sql.DB, store storage.Store, userID uint64) error {
+	var avatarURL string
+	if err := db.QueryRow("SELECT avatar FROM user WHERE id = ?;", userID).Scan(&avatarURL); err != nil {
+		return fmt.Errorf("failed to get avatar of user %d: %w", userID, err)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	queries := []string{
+		// comments on other people's posts stay so the replies to them make
+		// sense, the foreign key only lets go of the user
+		"UPDATE comment SET deleted = TRUE, body = '' WHERE user_id = ?;",
+		"DELETE FROM user WHERE id = ?;",
+	}
+	for _, query := range queries {
+		if _, err := tx.Exec(query, userID); err != nil {
+			return fmt.Errorf("failed to delete user %d: %w", userID, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit user delete: %w", err)
+	}
+	return avatar.Remove(ctx, store, avatarURL)
+}
+
+// RequestDeletion marks the account for deletion once the grace period is
+// over and logs it out everywhere. With no grace period it is deleted now.
+func RequestDeletion(ctx context.Context, db *sql.DB, sc *session.Cache, store storage.Store,
+	userID uint64, grace time.Duration) error {
+	sc.EndUserSessions(userID)
+	if grace <= 0 {
+		return Delete(ctx, db, store, userID)
+	}
+	query := "UPDATE user SET deletion_requested_at = CURRENT_TIMESTAMP WHERE id = ?;"
+	if _, err := db.Exec(query, userID); err != nil {
+		return fmt.Errorf("failed to request deletion: %w", err)
+	}
+	return nil
+}
+
+// CancelDeletion clears a pending deletion, it reports whether there was one
+func CancelDeletion(db *sql.DB, userID uint64) (bool, error) {
+	query := "UPDATE user SET deletion_requested_at = NULL WHERE id = ? AND deletion_requested_at IS NOT NULL;"
+	res, err := db.Exec(query, userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to cancel deletion: %w", err)
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// Purge hard deletes every account whose deletion grace period has passed
+func Purge(ctx context.Context, db *sql.DB, sc *session.Cache, store storage.Store,
+	auditLog *audit.Log, grace time.Duration) (int, error) {
+	cutoff := time.Now().UTC().Add(-grace).Format(time.DateTime)
+	query := "SELECT id FROM user WHERE deletion_requested_at IS NOT NULL AND deletion_requested_at < ?;"
+	rows, err := db.Query(query, cutoff)
+	if err != nil {
+		return 0, fmt.Errorf("failed to find accounts to purge: %w", err)
+	}
+	var userIDs []uint64
+	for rows.Next() {
+		var userID uint64
+		if err := rows.Scan(&userID); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan account to purge: %w", err)
+		}
+		userIDs = append(userIDs, userID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to iterate accounts to purge: %w", err)
+	}
+
+	for i, userID := range userIDs {
+		if err := Delete(ctx, db, store, userID); err != nil {
+			return i, err
+		}
+		sc.EndUserSessions(userID)
+		e := audit.Event{UserID: userID, Event: audit.AccountDeleted, Detail: "grace period over"}
+		if err := auditLog.Record(ctx, nil, e); err != nil {
+			return i + 1, err
+		}
+	}
+	return len(userIDs), nil
+}
+
+// StartPurger runs Purge every interval until the context is done, wg is done
+// once the purger has stopped
+func StartPurger(ctx context.Context, wg *sync.WaitGroup, db *sql.DB, sc *session.Cache, store storage.Store,
+	auditLog *audit.Log, logger *slog.Logger, every, grace time.Duration) {
+	ticker := time.NewTicker(every)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer ticker.Stop()
+		for {
+			purged, err := Purge(ctx, db, sc, store, auditLog, grace)
+			if err != nil {
+				logger.Error("Failed to purge deleted accounts", "error", err)
+			} else if purged > 0 {
+				logger.Info("Purged deleted accounts", "deleted", purged)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
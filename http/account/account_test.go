@@ -0,0 +1,194 @@
+package account
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"image"
+	"image/png"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/glebarez/go-sqlite"
+	"github.com/somethingsoftware/violet-web/http/audit"
+	"github.com/somethingsoftware/violet-web/http/avatar"
+	"github.com/somethingsoftware/violet-web/http/session"
+	"github.com/somethingsoftware/violet-web/http/storage"
+	"github.com/somethingsoftware/violet-web/migrate"
+)
+
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "test.db")+"?_pragma=foreign_keys(1)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := migrate.AutoUP(db, slog.New(slog.NewTextHandler(io.Discard, nil))); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func createUser(t *testing.T, db *sql.DB, username string) uint64 {
+	t.Helper()
+	query := "INSERT INTO user (username, email, salt, password_hash) VALUES (?, ?, '', '');"
+	res, err := db.Exec(query, username, username+"@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return uint64(id)
+}
+
+func avatarPNG(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 8, 8))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func count(t *testing.T, db *sql.DB, query string, args ...any) int {
+	t.Helper()
+	var n int
+	if err := db.QueryRow(query, args...).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestDelete(t *testing.T) {
+	db := openTestDB(t)
+	alice := createUser(t, db, "alice")
+	bob := createUser(t, db, "bob")
+	for _, userID := range []uint64{alice, bob} {
+		if _, err := db.Exec("INSERT INTO post (user_id, title, body) VALUES (?, 'title', 'body');", userID); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec("INSERT INTO forgot_password (user_id, token) VALUES (?, 'token');", userID); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec("INSERT INTO data_export (user_id, token) VALUES (?, ?);", userID, userID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// bob's comment on alice's post goes with the post, alice's comment on
+	// bob's post stays for the replies but loses its body and author
+	comments := []string{
+		"INSERT INTO comment (post_id, user_id, body) VALUES ((SELECT id FROM post WHERE user_id = 1), 2, 'on alice''s post');",
+		"INSERT INTO comment (post_id, user_id, body) VALUES ((SELECT id FROM post WHERE user_id = 2), 1, 'by alice');",
+	}
+	for _, query := range comments {
+		if _, err := db.Exec(query); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	store := storage.NewDB(db)
+	avatarURL, err := avatar.Save(context.Background(), store, alice, avatarPNG(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("UPDATE user SET avatar = ? WHERE id = ?;", avatarURL, alice); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Delete(context.Background(), db, store, alice); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(t, db, "SELECT COUNT(*) FROM blob;"); n != 0 {
+		t.Errorf("%d avatar files of the deleted user are left", n)
+	}
+	for _, table := range []string{"post", "forgot_password", "data_export"} {
+		if n := count(t, db, "SELECT COUNT(*) FROM "+table+" WHERE user_id = ?;", alice); n != 0 {
+			t.Errorf("%d %s rows of the deleted user are left", n, table)
+		}
+		if n := count(t, db, "SELECT COUNT(*) FROM "+table+" WHERE user_id = ?;", bob); n != 1 {
+			t.Errorf("%d %s rows of the other user are left, want 1", n, table)
+		}
+	}
+	if n := count(t, db, "SELECT COUNT(*) FROM user;"); n != 1 {
+		t.Errorf("%d users left, want 1", n)
+	}
+	if n := count(t, db, "SELECT COUNT(*) FROM comment;"); n != 1 {
+		t.Errorf("%d comments left, want 1", n)
+	}
+	if n := count(t, db, "SELECT COUNT(*) FROM comment WHERE deleted = TRUE AND body = '' AND user_id IS NULL;"); n != 1 {
+		t.Error("the deleted user's comment wasn't blanked")
+	}
+}
+
+func TestRequestDeletion(t *testing.T) {
+	db := openTestDB(t)
+	sc := session.NewCache(db)
+	alice := createUser(t, db, "alice")
+	ctx := context.Background()
+
+	if err := RequestDeletion(ctx, db, sc, storage.NewDB(db), alice, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(t, db, "SELECT COUNT(*) FROM user WHERE deletion_requested_at IS NOT NULL;"); n != 1 {
+		t.Fatal("deletion with a grace period didn't mark the user")
+	}
+	if cancelled, err := CancelDeletion(db, alice); err != nil || !cancelled {
+		t.Errorf("CancelDeletion() = %t, %v, want true", cancelled, err)
+	}
+	if cancelled, err := CancelDeletion(db, alice); err != nil || cancelled {
+		t.Errorf("CancelDeletion() without a pending deletion = %t, %v, want false", cancelled, err)
+	}
+
+	if err := RequestDeletion(ctx, db, sc, storage.NewDB(db), alice, 0); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(t, db, "SELECT COUNT(*) FROM user;"); n != 0 {
+		t.Error("deletion without a grace period left the user")
+	}
+}
+
+func TestPurge(t *testing.T) {
+	db := openTestDB(t)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	sc := session.NewCache(db)
+	auditLog := audit.NewLog(db, logger)
+	expired := createUser(t, db, "expired")
+	pending := createUser(t, db, "pending")
+	createUser(t, db, "kept")
+
+	query := "UPDATE user SET deletion_requested_at = ? WHERE id = ?;"
+	if _, err := db.Exec(query, time.Now().UTC().Add(-48*time.Hour).Format(time.DateTime), expired); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(query, time.Now().UTC().Format(time.DateTime), pending); err != nil {
+		t.Fatal(err)
+	}
+
+	purged, err := Purge(context.Background(), db, sc, storage.NewDB(db), auditLog, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purged != 1 {
+		t.Errorf("Purge() = %d, want 1", purged)
+	}
+	if n := count(t, db, "SELECT COUNT(*) FROM user WHERE id = ?;", expired); n != 0 {
+		t.Error("the account past its grace period is still there")
+	}
+	if n := count(t, db, "SELECT COUNT(*) FROM user;"); n != 2 {
+		t.Errorf("%d users left, want 2", n)
+	}
+	entries, err := auditLog.UserEvents(expired, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Event.Event != audit.AccountDeleted {
+		t.Errorf("audit events of the purged user = %+v", entries)
+	}
+}
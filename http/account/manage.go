@@ -0,0 +1,99 @@
+package account
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrNotFound = errors.New("user not found")
+
+// Roles are the roles a user can have, the default is the first
+var Roles = []string{"user", "admin"}
+
+// ValidRole reports whether role is one of Roles
+func ValidRole(role string) bool {
+	for _, r := range Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Lookup finds the id of the user with the username
+func Lookup(db *sql.DB, username string) (uint64, error) {
+	var id uint64
+	err := db.QueryRow("SELECT id FROM user WHERE username = ?;", username).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrNotFound
+	} else if err != nil {
+		return 0, fmt.Errorf("failed to look up user %s: %w", username, err)
+	}
+	return id, nil
+}
+
+// Create adds a user with an already hashed password
+func Create(db *sql.DB, username, email, salt, hash string) (uint64, error) {
+	query := "INSERT INTO user (username, email, salt, password_hash) VALUES (?, ?, ?, ?);"
+	res, err := db.Exec(query, username, email, salt, hash)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create user %s: %w", username, err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get id of user %s: %w", username, err)
+	}
+	return uint64(id), nil
+}
+
+func SetDisabled(db *sql.DB, userID uint64, disabled bool) error {
+	if _, err := db.Exec("UPDATE user SET disabled = ? WHERE id = ?;", disabled, userID); err != nil {
+		return fmt.Errorf("failed to set disabled for user %d: %w", userID, err)
+	}
+	return nil
+}
+
+// RevokeSessions ends the sessions of the user that started before now, the
+// running server checks on every request
+func RevokeSessions(db *sql.DB, userID uint64) error {
+	query := "UPDATE user SET sessions_revoked_at = ? WHERE id = ?;"
+	if _, err := db.Exec(query, time.Now().UnixMilli(), userID); err != nil {
+		return fmt.Errorf("failed to revoke sessions of user %d: %w", userID, err)
+	}
+	return nil
+}
+
+// RevokeAllSessions is RevokeSessions for every user, it returns how many
+// users there are
+func RevokeAllSessions(db *sql.DB) (int64, error) {
+	res, err := db.Exec("UPDATE user SET sessions_revoked_at = ?;", time.Now().UnixMilli())
+	if err != nil {
+		return 0, fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	return n, nil
+}
+
+// SetPassword replaces the password with an already hashed one
+func SetPassword(db *sql.DB, userID uint64, salt, hash string) error {
+	query := "UPDATE user SET password_hash = ?, salt = ? WHERE id = ?;"
+	if _, err := db.Exec(query, hash, salt, userID); err != nil {
+		return fmt.Errorf("failed to set password for user %d: %w", userID, err)
+	}
+	return nil
+}
+
+func SetRole(db *sql.DB, userID uint64, role string) error {
+	if !ValidRole(role) {
+		return fmt.Errorf("unknown role %q", role)
+	}
+	if _, err := db.Exec("UPDATE user SET role = ? WHERE id = ?;", role, userID); err != nil {
+		return fmt.Errorf("failed to set role for user %d: %w", userID, err)
+	}
+	return nil
+}
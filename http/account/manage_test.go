@@ -0,0 +1,77 @@
+package account
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestManage(t *testing.T) {
+	db := openTestDB(t)
+	id, err := Create(db, "alice", "alice@example.com", "salt", "hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Create(db, "alice", "other@example.com", "salt", "hash"); err == nil {
+		t.Error("Create() of a taken username succeeded")
+	}
+	if got, err := Lookup(db, "alice"); err != nil || got != id {
+		t.Errorf("Lookup(alice) = %d, %v, want %d", got, err, id)
+	}
+	if _, err := Lookup(db, "bob"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Lookup(bob) error = %v, want ErrNotFound", err)
+	}
+
+	if err := SetDisabled(db, id, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetPassword(db, id, "new-salt", "new-hash"); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetRole(db, id, "admin"); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetRole(db, id, "root"); err == nil {
+		t.Error("SetRole(root) succeeded")
+	}
+	var disabled bool
+	var salt, hash, role string
+	query := "SELECT disabled, salt, password_hash, role FROM user WHERE id = ?;"
+	if err := db.QueryRow(query, id).Scan(&disabled, &salt, &hash, &role); err != nil {
+		t.Fatal(err)
+	}
+	if !disabled || salt != "new-salt" || hash != "new-hash" || role != "admin" {
+		t.Errorf("user = disabled %v, salt %s, hash %s, role %s", disabled, salt, hash, role)
+	}
+}
+
+func TestRevokeSessions(t *testing.T) {
+	db := openTestDB(t)
+	alice := createUser(t, db, "alice")
+	bob := createUser(t, db, "bob")
+	before := time.Now().UnixMilli()
+
+	revokedAt := func(id uint64) int64 {
+		t.Helper()
+		var at int64
+		if err := db.QueryRow("SELECT sessions_revoked_at FROM user WHERE id = ?;", id).Scan(&at); err != nil {
+			t.Fatal(err)
+		}
+		return at
+	}
+	if err := RevokeSessions(db, alice); err != nil {
+		t.Fatal(err)
+	}
+	if at := revokedAt(alice); at < before {
+		t.Errorf("alice's sessions revoked at %d, want after %d", at, before)
+	}
+	if at := revokedAt(bob); at != 0 {
+		t.Errorf("bob's sessions revoked at %d, want never", at)
+	}
+	if n, err := RevokeAllSessions(db); err != nil || n != 2 {
+		t.Errorf("RevokeAllSessions() = %d, %v, want 2", n, err)
+	}
+	if at := revokedAt(bob); at < before {
+		t.Errorf("bob's sessions revoked at %d, want after %d", at, before)
+	}
+}
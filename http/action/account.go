@@ -0,0 +1,64 @@
+package action
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/somethingsoftware/violet-web/http/account"
+	"github.com/somethingsoftware/violet-web/http/apperr"
+	"github.com/somethingsoftware/violet-web/http/audit"
+	"github.com/somethingsoftware/violet-web/http/config"
+	"github.com/somethingsoftware/violet-web/http/render"
+	"github.com/somethingsoftware/violet-web/http/session"
+	"github.com/somethingsoftware/violet-web/http/storage"
+)
+
+// DeleteAccount deletes the logged in user's account after checking their
+// password. With a grace period the account is only marked for deletion and
+// logging back in before it is over cancels the deletion.
+func DeleteAccount(db *sql.DB, sc *session.Cache, store storage.Store, auditLog *audit.Log,
+	rn *render.Renderer, logger *slog.Logger, cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		request_id := uuid.New().String()
+		ctx = context.WithValue(ctx, "request_id", request_id)
+		logger.DebugContext(ctx, "Delete account action called")
+
+		session, err := sc.GetSession(r)
+		if err != nil {
+			rn.Error(ctx, w, r, apperr.Unauthorized().With(err))
+			return
+		}
+
+		password := r.FormValue("password")
+		success, userID := constantTimeCompare(ctx, logger, db, session.Username, password)
+		if !success || userID != session.UserID {
+			logger.WarnContext(ctx, "Failed password check for account deletion", "username", session.Username)
+			rn.Error(ctx, w, r, apperr.New(http.StatusUnauthorized, "Invalid password"))
+			return
+		}
+
+		if err := account.RequestDeletion(ctx, db, sc, store, userID, cfg.DeletionGrace); err != nil {
+			rn.Error(ctx, w, r, apperr.Internal(fmt.Errorf("failed to delete account: %w", err)))
+			return
+		}
+
+		event := audit.AccountDeleteRequested
+		if cfg.DeletionGrace <= 0 {
+			event = audit.AccountDeleted
+		}
+		e := audit.Event{UserID: userID, ActorID: userID, Event: event}
+		if err := auditLog.Record(ctx, r, e); err != nil {
+			logger.ErrorContext(ctx, "Failed to record audit event", "error", err)
+		}
+
+		// the session is already gone, this just clears the cookie
+		cookie := &http.Cookie{Name: "session", MaxAge: -1, HttpOnly: true}
+		http.SetCookie(w, cookie)
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+	}
+}
@@ -0,0 +1,211 @@
+package action
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/somethingsoftware/violet-web/http/account"
+	"github.com/somethingsoftware/violet-web/http/apperr"
+	"github.com/somethingsoftware/violet-web/http/audit"
+	"github.com/somethingsoftware/violet-web/http/auth"
+	"github.com/somethingsoftware/violet-web/http/render"
+	"github.com/somethingsoftware/violet-web/http/session"
+	"github.com/somethingsoftware/violet-web/http/siteurl"
+	"github.com/somethingsoftware/violet-web/http/storage"
+)
+
+var errAdminSelf = errors.New("admins can not do this to their own account")
+
+// adminUserAction wraps the boilerplate shared by every admin action on a
+// user: finding the acting admin and the target user, running the action,
+// recording it in the audit log and sending the admin back to the user page
+func adminUserAction(db *sql.DB, sc *session.Cache, auditLog *audit.Log, rn *render.Renderer, logger *slog.Logger,
+	event string, allowSelf bool, act func(ctx context.Context, r *http.Request, userID uint64) (string, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		request_id := uuid.New().String()
+		ctx = context.WithValue(ctx, "request_id", request_id)
+		logger.DebugContext(ctx, "Admin action called", "event", event)
+
+		admin, userID, ok := adminTarget(ctx, w, r, db, sc, rn, allowSelf)
+		if !ok {
+			return
+		}
+
+		detail, err := act(ctx, r, userID)
+		if err != nil {
+			rn.Error(ctx, w, r, apperr.Internal(fmt.Errorf("admin action %s failed: %w", event, err)))
+			return
+		}
+
+		e := audit.Event{UserID: userID, ActorID: admin.UserID, Event: event, Detail: detail}
+		if err := auditLog.Record(ctx, r, e); err != nil {
+			logger.ErrorContext(ctx, "Failed to record audit event", "error", err)
+		}
+
+		if event == audit.AdminDelete {
+			http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+			return
+		}
+		http.Redirect(w, r, fmt.Sprintf("/admin/users/%d", userID), http.StatusSeeOther)
+	}
+}
+
+// adminTarget finds the acting admin and the user named by the {id} path
+// value, it writes the error response itself and returns false if the action
+// can't go ahead
+func adminTarget(ctx context.Context, w http.ResponseWriter, r *http.Request,
+	db *sql.DB, sc *session.Cache, rn *render.Renderer, allowSelf bool) (session.Session, uint64, bool) {
+	admin, err := sc.GetSession(r)
+	if err != nil {
+		rn.Error(ctx, w, r, apperr.Unauthorized().With(err))
+		return session.Session{}, 0, false
+	}
+
+	userID, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		rn.Error(ctx, w, r, apperr.NotFound())
+		return session.Session{}, 0, false
+	}
+	var exists bool
+	query := "SELECT EXISTS(SELECT 1 FROM user WHERE id = ?);"
+	if err := db.QueryRow(query, userID).Scan(&exists); err != nil {
+		rn.Error(ctx, w, r, apperr.Internal(fmt.Errorf("failed to find user: %w", err)))
+		return session.Session{}, 0, false
+	}
+	if !exists {
+		rn.Error(ctx, w, r, apperr.NotFound())
+		return session.Session{}, 0, false
+	}
+	if !allowSelf && userID == admin.UserID {
+		rn.Error(ctx, w, r, apperr.BadRequest(errAdminSelf.Error()))
+		return session.Session{}, 0, false
+	}
+	return admin, userID, true
+}
+
+// AdminDisable stops the user from logging in and ends their sessions
+func AdminDisable(db *sql.DB, sc *session.Cache, auditLog *audit.Log, rn *render.Renderer, logger *slog.Logger) http.HandlerFunc {
+	return adminUserAction(db, sc, auditLog, rn, logger, audit.AdminDisable, false,
+		func(ctx context.Context, r *http.Request, userID uint64) (string, error) {
+			if _, err := db.Exec("UPDATE user SET disabled = TRUE WHERE id = ?;", userID); err != nil {
+				return "", fmt.Errorf("failed to disable user: %w", err)
+			}
+			ended := sc.EndUserSessions(userID)
+			return fmt.Sprintf("ended %d sessions", ended), nil
+		})
+}
+
+func AdminEnable(db *sql.DB, sc *session.Cache, auditLog *audit.Log, rn *render.Renderer, logger *slog.Logger) http.HandlerFunc {
+	return adminUserAction(db, sc, auditLog, rn, logger, audit.AdminEnable, false,
+		func(ctx context.Context, r *http.Request, userID uint64) (string, error) {
+			if _, err := db.Exec("UPDATE user SET disabled = FALSE WHERE id = ?;", userID); err != nil {
+				return "", fmt.Errorf("failed to enable user: %w", err)
+			}
+			return "", nil
+		})
+}
+
+// AdminForceReset throws away the user's password and shows the admin a reset
+// link to hand to the user, the only way they can log in again. Admins can't
+// do it to their own account and lock themselves out of the console.
+func AdminForceReset(db *sql.DB, sc *session.Cache, site *siteurl.Site, auditLog *audit.Log, rn *render.Renderer, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		request_id := uuid.New().String()
+		ctx = context.WithValue(ctx, "request_id", request_id)
+		logger.DebugContext(ctx, "Admin action called", "event", audit.AdminForceReset)
+
+		admin, userID, ok := adminTarget(ctx, w, r, db, sc, rn, false)
+		if !ok {
+			return
+		}
+
+		bytes, err := auth.GenerateRandomBytes(32)
+		if err != nil {
+			rn.Error(ctx, w, r, apperr.Internal(fmt.Errorf("failed to generate random bytes: %w", err)))
+			return
+		}
+		token := base64.StdEncoding.EncodeToString(bytes)
+
+		username, err := forceReset(ctx, db, userID, token)
+		if err != nil {
+			rn.Error(ctx, w, r, apperr.Internal(err))
+			return
+		}
+		ended := sc.EndUserSessions(userID)
+
+		e := audit.Event{UserID: userID, ActorID: admin.UserID, Event: audit.AdminForceReset,
+			Detail: fmt.Sprintf("ended %d sessions", ended)}
+		if err := auditLog.Record(ctx, r, e); err != nil {
+			logger.ErrorContext(ctx, "Failed to record audit event", "error", err)
+		}
+
+		type AdminResetPage struct {
+			UserID   uint64
+			Username string
+			Link     string
+		}
+		rn.Render(w, r, "admin-reset.gotmpl", AdminResetPage{
+			UserID:   userID,
+			Username: username,
+			Link:     buildResetLink(r, site, token),
+		})
+	}
+}
+
+// forceReset clears the user's password and stores the reset token in one
+// transaction, so the user is never locked out without a way back in. It
+// returns the username for the result page.
+func forceReset(ctx context.Context, db *sql.DB, userID uint64, token string) (string, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// an empty hash can never match so the old password stops working
+	var username string
+	query := "UPDATE user SET password_hash = '' WHERE id = ? RETURNING username;"
+	if err := tx.QueryRowContext(ctx, query, userID).Scan(&username); err != nil {
+		return "", fmt.Errorf("failed to clear password: %w", err)
+	}
+	query = "INSERT INTO forgot_password (user_id, token) VALUES (?, ?);"
+	if _, err := tx.ExecContext(ctx, query, userID, token); err != nil {
+		return "", fmt.Errorf("failed to insert reset token: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return username, nil
+}
+
+func AdminRevokeSessions(db *sql.DB, sc *session.Cache, auditLog *audit.Log, rn *render.Renderer, logger *slog.Logger) http.HandlerFunc {
+	return adminUserAction(db, sc, auditLog, rn, logger, audit.AdminRevokeSessions, true,
+		func(ctx context.Context, r *http.Request, userID uint64) (string, error) {
+			ended := sc.EndUserSessions(userID)
+			return fmt.Sprintf("ended %d sessions", ended), nil
+		})
+}
+
+func AdminDelete(db *sql.DB, sc *session.Cache, store storage.Store, auditLog *audit.Log, rn *render.Renderer, logger *slog.Logger) http.HandlerFunc {
+	return adminUserAction(db, sc, auditLog, rn, logger, audit.AdminDelete, false,
+		func(ctx context.Context, r *http.Request, userID uint64) (string, error) {
+			var username string
+			if err := db.QueryRow("SELECT username FROM user WHERE id = ?;", userID).Scan(&username); err != nil {
+				return "", fmt.Errorf("failed to get username: %w", err)
+			}
+			if err := account.Delete(ctx, db, store, userID); err != nil {
+				return "", err
+			}
+			sc.EndUserSessions(userID)
+			return "deleted " + username, nil
+		})
+}
@@ -0,0 +1,103 @@
+package action
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/somethingsoftware/violet-web/http/audit"
+	"github.com/somethingsoftware/violet-web/http/session"
+	"github.com/somethingsoftware/violet-web/http/siteurl"
+)
+
+func TestAdminForceReset(t *testing.T) {
+	db := openTestDB(t)
+	query := "INSERT INTO user (id, username, email, salt, password_hash) VALUES (2, 'bob', 'bob@example.com', 'salt', 'hash');"
+	if _, err := db.Exec(query); err != nil {
+		t.Fatal(err)
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	sc := session.NewCache(db)
+	site, err := siteurl.New("https://violet.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := AdminForceReset(db, sc, site, audit.NewLog(db, logger), newTestRenderer(t), logger)
+
+	login := func(userID uint64, username string) []*http.Cookie {
+		w := httptest.NewRecorder()
+		if err := sc.StartSession(w, httptest.NewRequest(http.MethodPost, "/login", nil), userID, username); err != nil {
+			t.Fatal(err)
+		}
+		return w.Result().Cookies()
+	}
+	admin := login(1, "alice")
+	login(2, "bob")
+	reset := func(id string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/admin/users/"+id+"/reset", nil)
+		r.SetPathValue("id", id)
+		for _, cookie := range admin {
+			r.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w
+	}
+	state := func(id uint64) (hash string, tokens int) {
+		t.Helper()
+		query := "SELECT password_hash, (SELECT COUNT(*) FROM forgot_password WHERE user_id = user.id) FROM user WHERE id = ?;"
+		if err := db.QueryRow(query, id).Scan(&hash, &tokens); err != nil {
+			t.Fatal(err)
+		}
+		return hash, tokens
+	}
+
+	// an admin locked out of their own account can't get back to the console
+	if w := reset("1"); w.Code != http.StatusBadRequest {
+		t.Errorf("reset of the admin's own account = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if hash, tokens := state(1); hash != "" || tokens != 0 {
+		t.Errorf("admin after resetting themselves = hash %q, %d tokens, want untouched", hash, tokens)
+	}
+
+	w := reset("2")
+	if w.Code != http.StatusOK {
+		t.Fatalf("reset = %d, want %d", w.Code, http.StatusOK)
+	}
+	var token string
+	if err := db.QueryRow("SELECT token FROM forgot_password WHERE user_id = 2;").Scan(&token); err != nil {
+		t.Fatal(err)
+	}
+	// the admin hands the link over themselves
+	if !strings.Contains(w.Body.String(), "https://violet.example/resetpass?token=") || token == "" {
+		t.Errorf("result page doesn't show the reset link: %s", w.Body)
+	}
+	if hash, tokens := state(2); hash != "" || tokens != 1 {
+		t.Errorf("bob after the reset = hash %q, %d tokens", hash, tokens)
+	}
+	if sessions := sc.UserSessions(2); len(sessions) != 0 {
+		t.Errorf("bob still has %d sessions", len(sessions))
+	}
+	var events int
+	if err := db.QueryRow("SELECT COUNT(*) FROM audit_log WHERE user_id = 2 AND actor_id = 1 AND event = ?;", audit.AdminForceReset).Scan(&events); err != nil || events != 1 {
+		t.Errorf("%d reset events, %v, want 1", events, err)
+	}
+
+	// without a token to go with it the password stays as it was
+	if _, err := db.Exec("UPDATE user SET password_hash = 'hash' WHERE id = 2;"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("DROP TABLE forgot_password;"); err != nil {
+		t.Fatal(err)
+	}
+	if w := reset("2"); w.Code != http.StatusInternalServerError {
+		t.Errorf("reset without the token table = %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+	var hash string
+	if err := db.QueryRow("SELECT password_hash FROM user WHERE id = 2;").Scan(&hash); err != nil || hash != "hash" {
+		t.Errorf("password_hash after the failed reset = %q, %v, want it kept", hash, err)
+	}
+}
@@ -0,0 +1,107 @@
+package action
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/somethingsoftware/violet-web/http/apperr"
+	"github.com/somethingsoftware/violet-web/http/avatar"
+	"github.com/somethingsoftware/violet-web/http/render"
+	"github.com/somethingsoftware/violet-web/http/session"
+	"github.com/somethingsoftware/violet-web/http/storage"
+)
+
+func UploadAvatar(db *sql.DB, sc *session.Cache, store storage.Store, rn *render.Renderer, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		request_id := uuid.New().String()
+		ctx = context.WithValue(ctx, "request_id", request_id)
+		logger.DebugContext(ctx, "Upload avatar action called")
+
+		session, err := sc.GetSession(r)
+		if err != nil {
+			rn.Error(ctx, w, r, apperr.Unauthorized().With(err))
+			return
+		}
+
+		file, _, err := r.FormFile("avatar")
+		if err != nil {
+			var maxErr *http.MaxBytesError
+			if errors.As(err, &maxErr) {
+				rn.Error(ctx, w, r, apperr.New(http.StatusRequestEntityTooLarge, "Avatar file is too large"))
+				return
+			}
+			rn.Error(ctx, w, r, apperr.BadRequest("No avatar uploaded"))
+			return
+		}
+		defer file.Close()
+		upload, err := io.ReadAll(io.LimitReader(file, avatar.MaxUploadBytes+1))
+		if err != nil {
+			rn.Error(ctx, w, r, apperr.Internal(fmt.Errorf("failed to read avatar upload: %w", err)))
+			return
+		}
+		if len(upload) > avatar.MaxUploadBytes {
+			rn.Error(ctx, w, r, apperr.New(http.StatusRequestEntityTooLarge, "Avatar file is too large"))
+			return
+		}
+
+		url, err := avatar.Save(ctx, store, session.UserID, upload)
+		if errors.Is(err, avatar.ErrUnsupportedType) || errors.Is(err, avatar.ErrTooLarge) {
+			rn.Error(ctx, w, r, apperr.BadRequest(err.Error()))
+			return
+		} else if err != nil {
+			rn.Error(ctx, w, r, apperr.Internal(fmt.Errorf("failed to save avatar: %w", err)))
+			return
+		}
+
+		if err := setAvatar(ctx, db, store, session.UserID, url); err != nil {
+			rn.Error(ctx, w, r, apperr.Internal(fmt.Errorf("failed to set avatar: %w", err)))
+			return
+		}
+
+		http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+	}
+}
+
+func RemoveAvatar(db *sql.DB, sc *session.Cache, store storage.Store, rn *render.Renderer, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		request_id := uuid.New().String()
+		ctx = context.WithValue(ctx, "request_id", request_id)
+		logger.DebugContext(ctx, "Remove avatar action called")
+
+		session, err := sc.GetSession(r)
+		if err != nil {
+			rn.Error(ctx, w, r, apperr.Unauthorized().With(err))
+			return
+		}
+
+		if err := setAvatar(ctx, db, store, session.UserID, ""); err != nil {
+			rn.Error(ctx, w, r, apperr.Internal(fmt.Errorf("failed to remove avatar: %w", err)))
+			return
+		}
+
+		http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+	}
+}
+
+// setAvatar points the user at a new avatar and deletes the files of the old one
+func setAvatar(ctx context.Context, db *sql.DB, store storage.Store, userID uint64, url string) error {
+	var old string
+	if err := db.QueryRow("SELECT avatar FROM user WHERE id = ?;", userID).Scan(&old); err != nil {
+		return err
+	}
+	if _, err := db.Exec("UPDATE user SET avatar = ? WHERE id = ?;", url, userID); err != nil {
+		return err
+	}
+	if old == url {
+		return nil
+	}
+	return avatar.Remove(ctx, store, old)
+}
@@ -0,0 +1,139 @@
+package action
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/somethingsoftware/violet-web/http/account"
+	"github.com/somethingsoftware/violet-web/http/apperr"
+	"github.com/somethingsoftware/violet-web/http/audit"
+	"github.com/somethingsoftware/violet-web/http/comment"
+	"github.com/somethingsoftware/violet-web/http/post"
+	"github.com/somethingsoftware/violet-web/http/render"
+	"github.com/somethingsoftware/violet-web/http/session"
+)
+
+func CreateComment(db *sql.DB, sc *session.Cache, rn *render.Renderer, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		request_id := uuid.New().String()
+		ctx = context.WithValue(ctx, "request_id", request_id)
+		logger.DebugContext(ctx, "Create comment action called")
+
+		session, err := sc.GetSession(r)
+		if err != nil {
+			rn.Error(ctx, w, r, apperr.Unauthorized().With(err))
+			return
+		}
+
+		postID, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+		if err != nil {
+			rn.Error(ctx, w, r, apperr.NotFound())
+			return
+		}
+		if _, err := post.Get(db, postID, true); errors.Is(err, post.ErrNotFound) {
+			rn.Error(ctx, w, r, apperr.NotFound())
+			return
+		} else if err != nil {
+			rn.Error(ctx, w, r, apperr.Internal(fmt.Errorf("failed to get post: %w", err)))
+			return
+		}
+
+		body := r.FormValue("body")
+		if err := comment.Validate(body); err != nil {
+			rn.Error(ctx, w, r, apperr.BadRequest(err.Error()))
+			return
+		}
+		var parentID uint64
+		if parent := r.FormValue("parent_id"); parent != "" {
+			parentID, err = strconv.ParseUint(parent, 10, 64)
+			if err != nil {
+				rn.Error(ctx, w, r, apperr.BadRequest(comment.ErrBadParent.Error()))
+				return
+			}
+		}
+
+		id, err := comment.Create(db, postID, session.UserID, parentID, body)
+		if errors.Is(err, comment.ErrBadParent) {
+			rn.Error(ctx, w, r, apperr.BadRequest(err.Error()))
+			return
+		} else if err != nil {
+			rn.Error(ctx, w, r, apperr.Internal(fmt.Errorf("failed to create comment: %w", err)))
+			return
+		}
+
+		http.Redirect(w, r, fmt.Sprintf("/posts/%d#comment-%d", postID, id), http.StatusSeeOther)
+	}
+}
+
+// DeleteComment lets the author delete their own comment and admins delete
+// anyone's, the latter is recorded in the audit log
+func DeleteComment(db *sql.DB, sc *session.Cache, auditLog *audit.Log, rn *render.Renderer, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		request_id := uuid.New().String()
+		ctx = context.WithValue(ctx, "request_id", request_id)
+		logger.DebugContext(ctx, "Delete comment action called")
+
+		session, err := sc.GetSession(r)
+		if err != nil {
+			rn.Error(ctx, w, r, apperr.Unauthorized().With(err))
+			return
+		}
+
+		id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+		if err != nil {
+			rn.Error(ctx, w, r, apperr.NotFound())
+			return
+		}
+		c, err := comment.Get(db, id)
+		if errors.Is(err, comment.ErrNotFound) {
+			rn.Error(ctx, w, r, apperr.NotFound())
+			return
+		} else if err != nil {
+			rn.Error(ctx, w, r, apperr.Internal(fmt.Errorf("failed to get comment: %w", err)))
+			return
+		}
+
+		moderated := false
+		if c.UserID != session.UserID {
+			isAdmin, err := account.IsAdmin(db, session.UserID)
+			if err != nil {
+				rn.Error(ctx, w, r, apperr.Internal(fmt.Errorf("failed to check admin: %w", err)))
+				return
+			}
+			if !isAdmin {
+				logger.WarnContext(ctx, "User tried to delete a comment they don't own",
+					"user_id", session.UserID, "comment_id", c.ID)
+				rn.Error(ctx, w, r, apperr.Forbidden())
+				return
+			}
+			moderated = true
+		}
+
+		if err := comment.Delete(db, c.ID); err != nil {
+			rn.Error(ctx, w, r, apperr.Internal(fmt.Errorf("failed to delete comment: %w", err)))
+			return
+		}
+
+		if moderated {
+			e := audit.Event{
+				UserID:  c.UserID,
+				ActorID: session.UserID,
+				Event:   audit.AdminDeleteComment,
+				Detail:  fmt.Sprintf("comment %d on post %d", c.ID, c.PostID),
+			}
+			if err := auditLog.Record(ctx, r, e); err != nil {
+				logger.ErrorContext(ctx, "Failed to record audit event", "error", err)
+			}
+		}
+
+		http.Redirect(w, r, fmt.Sprintf("/posts/%d#comment-%d", c.PostID, c.ID), http.StatusSeeOther)
+	}
+}
@@ -0,0 +1,53 @@
+package action
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/somethingsoftware/violet-web/http/apperr"
+	"github.com/somethingsoftware/violet-web/http/audit"
+	"github.com/somethingsoftware/violet-web/http/export"
+	"github.com/somethingsoftware/violet-web/http/flash"
+	"github.com/somethingsoftware/violet-web/http/i18n"
+	"github.com/somethingsoftware/violet-web/http/render"
+	"github.com/somethingsoftware/violet-web/http/session"
+)
+
+// RequestExport queues a data export for the logged in user, the export page
+// shows the download link once the worker has built it. A user with an
+// export that is pending or ready is sent back to it instead.
+func RequestExport(sc *session.Cache, worker *export.Worker, auditLog *audit.Log, rn *render.Renderer, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		request_id := uuid.New().String()
+		ctx = context.WithValue(ctx, "request_id", request_id)
+		logger.DebugContext(ctx, "Request export action called")
+
+		session, err := sc.GetSession(r)
+		if err != nil {
+			rn.Error(ctx, w, r, apperr.Unauthorized().With(err))
+			return
+		}
+
+		err = worker.Request(session.UserID)
+		if errors.Is(err, export.ErrInProgress) {
+			flash.Set(w, flash.Info, i18n.T(ctx, "You already have an export, download it or wait for it to finish"))
+			http.Redirect(w, r, "/user/export", http.StatusSeeOther)
+			return
+		} else if err != nil {
+			rn.Error(ctx, w, r, apperr.Internal(fmt.Errorf("failed to request data export: %w", err)))
+			return
+		}
+
+		e := audit.Event{UserID: session.UserID, ActorID: session.UserID, Event: audit.DataExportRequested}
+		if err := auditLog.Record(ctx, r, e); err != nil {
+			logger.ErrorContext(ctx, "Failed to record audit event", "error", err)
+		}
+
+		http.Redirect(w, r, "/user/export", http.StatusSeeOther)
+	}
+}
@@ -4,15 +4,19 @@ import (
 	"context"
 	"database/sql"
 	"encoding/base64"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"net/mail"
 	"net/url"
-	"path/filepath"
-	"strings"
 
 	"github.com/google/uuid"
+	"github.com/somethingsoftware/violet-web/http/apperr"
+	"github.com/somethingsoftware/violet-web/http/audit"
 	"github.com/somethingsoftware/violet-web/http/auth"
+	"github.com/somethingsoftware/violet-web/http/config"
+	"github.com/somethingsoftware/violet-web/http/render"
+	"github.com/somethingsoftware/violet-web/http/siteurl"
 )
 
 // CREATE TABLE forgot_password (
@@ -21,10 +25,10 @@ import (
 // 	token TEXT NOT NULL,
 // 	used BOOLEAN NOT NULL DEFAULT FALSE,
 // 	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
-// 	FOREIGN KEY (user_id) REFERENCES users(id)
+// 	FOREIGN KEY (user_id) REFERENCES user(id) ON DELETE CASCADE
 // );
 
-func Forgot(db *sql.DB, logger *slog.Logger, devMode bool) http.HandlerFunc {
+func Forgot(db *sql.DB, site *siteurl.Site, auditLog *audit.Log, rn *render.Renderer, logger *slog.Logger, cfg *config.Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 		request_id := uuid.New().String()
@@ -35,8 +39,7 @@ func Forgot(db *sql.DB, logger *slog.Logger, devMode bool) http.HandlerFunc {
 
 		addr, err := mail.ParseAddress(email)
 		if err != nil {
-			logger.ErrorContext(ctx, "Invalid email address", "error", err)
-			http.Error(w, "Invalid email address", http.StatusBadRequest)
+			rn.Error(ctx, w, r, apperr.BadRequest("Invalid email address").With(err))
 			return
 		}
 
@@ -45,47 +48,33 @@ func Forgot(db *sql.DB, logger *slog.Logger, devMode bool) http.HandlerFunc {
 		res := db.QueryRow(query, addr.Address)
 		var id uint64
 		if err := res.Scan(&id); err != nil {
-			logger.ErrorContext(ctx, "Failed to find email in database", "error", err)
-			http.Error(w, "Invalid email address", http.StatusBadRequest)
+			rn.Error(ctx, w, r, apperr.BadRequest("Invalid email address").With(err))
 			return
 		}
 
 		bytes, err := auth.GenerateRandomBytes(32)
 		if err != nil {
-			logger.ErrorContext(ctx, "Failed to generate random bytes", "error", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			rn.Error(ctx, w, r, apperr.Internal(fmt.Errorf("failed to generate random bytes: %w", err)))
 			return
 		}
 		token := base64.StdEncoding.EncodeToString(bytes)
 
 		query = "INSERT INTO forgot_password (user_id, token) VALUES (?, ?);"
 		if _, err := db.Exec(query, id, token); err != nil {
-			logger.ErrorContext(ctx, "Failed to insert token into database", "error", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			rn.Error(ctx, w, r, apperr.Internal(fmt.Errorf("failed to insert token into database: %w", err)))
 			return
 		}
-
-		if !devMode {
-			logger.ErrorContext(ctx, "Emails are not implemented yet in prod")
-			http.Error(w, "Emails are not implemented yet in prod", http.StatusNotImplemented)
-			return
+		e := audit.Event{UserID: id, Event: audit.ForgotPassword}
+		if err := auditLog.Record(ctx, r, e); err != nil {
+			logger.ErrorContext(ctx, "Failed to record audit event", "error", err)
 		}
 
-		// build a link for this host /resetpass?token=token
-		u, err := url.Parse(filepath.Join(r.Host, "/resetpass"))
-		if err != nil {
-			logger.ErrorContext(ctx, "Failed to parse URL for resetpass redirect", "error", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		if !cfg.DevMode {
+			rn.Error(ctx, w, r, apperr.New(http.StatusNotImplemented, "Emails are not implemented yet in prod"))
 			return
 		}
-		q := u.Query()
-		q.Set("token", token)
-		u.RawQuery = q.Encode()
 
-		resetLink := u.String()
-		if !strings.HasPrefix(resetLink, "http") {
-			resetLink = "http://" + resetLink // TODO: use https if this isn't behind caddy
-		}
+		resetLink := buildResetLink(r, site, token)
 
 		// for debugging just log the link instead of emailing it
 		logger.Debug("Reset password link", "link", resetLink)
@@ -94,8 +83,11 @@ func Forgot(db *sql.DB, logger *slog.Logger, devMode bool) http.HandlerFunc {
 		// http.Redirect(w, r, "/resetpass", http.StatusSeeOther)
 		if _, err := w.Write([]byte("Check logs for a reset link")); err != nil {
 			logger.ErrorContext(ctx, "Failed to write response", "error", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-			return
 		}
 	}
 }
+
+// buildResetLink builds a link to /resetpass?token=token on the site
+func buildResetLink(r *http.Request, site *siteurl.Site, token string) string {
+	return site.URL(r, "/resetpass", url.Values{"token": {token}})
+}
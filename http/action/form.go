@@ -0,0 +1,29 @@
+package action
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/somethingsoftware/violet-web/http/apperr"
+	"github.com/somethingsoftware/violet-web/http/csrf"
+	"github.com/somethingsoftware/violet-web/http/form"
+	"github.com/somethingsoftware/violet-web/http/render"
+)
+
+// renderForm shows a form page again after a failed submission. The csrf
+// token the request came with was used up validating it so the page gets a
+// new one, forms without csrf protection pass a nil provider.
+func renderForm(ctx context.Context, w http.ResponseWriter, r *http.Request, csrfProvider *csrf.Provider,
+	rn *render.Renderer, logger *slog.Logger, status int, name string, f *form.Form) {
+	if csrfProvider != nil {
+		token, err := csrfProvider.MakeRequestToken(r)
+		if err != nil {
+			rn.Error(ctx, w, r, apperr.Internal(fmt.Errorf("failed to make csrf token: %w", err)))
+			return
+		}
+		f.CSRFToken = token
+	}
+	rn.RenderStatus(w, r, status, name, f)
+}
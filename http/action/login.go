@@ -4,18 +4,26 @@ import (
 	"context"
 	"database/sql"
 	"encoding/base64"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/somethingsoftware/violet-web/http/account"
+	"github.com/somethingsoftware/violet-web/http/apperr"
+	"github.com/somethingsoftware/violet-web/http/audit"
 	"github.com/somethingsoftware/violet-web/http/auth"
+	"github.com/somethingsoftware/violet-web/http/csrf"
+	"github.com/somethingsoftware/violet-web/http/form"
+	"github.com/somethingsoftware/violet-web/http/i18n"
+	"github.com/somethingsoftware/violet-web/http/render"
 	"github.com/somethingsoftware/violet-web/http/session"
 )
 
 const loginTimeMS = 500
 
-func Login(db *sql.DB, sc *session.Cache, logger *slog.Logger) http.HandlerFunc {
+func Login(db *sql.DB, sc *session.Cache, csrfProvider *csrf.Provider, rn *render.Renderer, auditLog *audit.Log, logger *slog.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 		request_id := uuid.New().String()
@@ -30,15 +38,45 @@ func Login(db *sql.DB, sc *session.Cache, logger *slog.Logger) http.HandlerFunc
 		logger.DebugContext(ctx, "Constant time compare called", "duration", time.Since(start))
 		if !success {
 			logger.WarnContext(ctx, "Failed login attempt", "username", username)
-			http.Error(w, "Invalid username or password", http.StatusUnauthorized)
+			// attach the attempt to the account if there is one so the owner can see it
+			var targetID uint64
+			_ = db.QueryRow("SELECT id FROM user WHERE username = ?;", username).Scan(&targetID)
+			e := audit.Event{UserID: targetID, Event: audit.LoginFailure, Detail: "username " + username}
+			if err := auditLog.Record(ctx, r, e); err != nil {
+				logger.ErrorContext(ctx, "Failed to record audit event", "error", err)
+			}
+			f := form.New()
+			f.Set("username", username)
+			f.Error(form.General, i18n.T(ctx, "Invalid username or password"))
+			renderForm(ctx, w, r, csrfProvider, rn, logger, http.StatusUnauthorized, "login.gotmpl", f)
 			return
 		}
 
 		if err := sc.StartSession(w, r, userID, username); err != nil {
-			logger.ErrorContext(ctx, "Failed to start session", "error", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			rn.Error(ctx, w, r, apperr.Internal(fmt.Errorf("failed to start session: %w", err)))
 			return
 		}
+		query := "UPDATE user SET last_login_at = CURRENT_TIMESTAMP WHERE id = ?;"
+		if _, err := db.Exec(query, userID); err != nil {
+			logger.ErrorContext(ctx, "Failed to update last login time", "error", err)
+		}
+
+		e := audit.Event{UserID: userID, ActorID: userID, Event: audit.LoginSuccess}
+		if err := auditLog.Record(ctx, r, e); err != nil {
+			logger.ErrorContext(ctx, "Failed to record audit event", "error", err)
+		}
+
+		// logging in during the grace period keeps the account
+		cancelled, err := account.CancelDeletion(db, userID)
+		if err != nil {
+			logger.ErrorContext(ctx, "Failed to cancel account deletion", "error", err)
+		} else if cancelled {
+			e := audit.Event{UserID: userID, ActorID: userID, Event: audit.AccountDeleteCancelled}
+			if err := auditLog.Record(ctx, r, e); err != nil {
+				logger.ErrorContext(ctx, "Failed to record audit event", "error", err)
+			}
+		}
+
 		// redirect to the their page
 		logger.DebugContext(ctx, "Successful login", "username", username)
 		http.Redirect(w, r, "/user", http.StatusSeeOther)
@@ -76,12 +114,17 @@ func validateLogin(ctx context.Context, logger *slog.Logger,
 	db *sql.DB, username, password string) uint64 {
 	var saltB64, hashB64 string
 	var userID uint64
-	query := `SELECT id, salt, password_hash FROM user WHERE username = ?`
+	var disabled bool
+	query := `SELECT id, salt, password_hash, disabled FROM user WHERE username = ?`
 	row := db.QueryRow(query, username)
-	if err := row.Scan(&userID, &saltB64, &hashB64); err != nil {
+	if err := row.Scan(&userID, &saltB64, &hashB64, &disabled); err != nil {
 		logger.ErrorContext(ctx, "Failed getting user creds from db", "error", err)
 		return 0
 	}
+	if disabled {
+		logger.WarnContext(ctx, "Login attempt for disabled user", "user_id", userID)
+		return 0
+	}
 
 	salt, err := base64.StdEncoding.DecodeString(saltB64)
 	if err != nil {
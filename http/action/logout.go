@@ -3,14 +3,20 @@ package action
 import (
 	"context"
 	"database/sql"
+	"fmt"
 	"log/slog"
 	"net/http"
 
 	"github.com/google/uuid"
+	"github.com/somethingsoftware/violet-web/http/apperr"
+	"github.com/somethingsoftware/violet-web/http/audit"
+	"github.com/somethingsoftware/violet-web/http/flash"
+	"github.com/somethingsoftware/violet-web/http/i18n"
+	"github.com/somethingsoftware/violet-web/http/render"
 	"github.com/somethingsoftware/violet-web/http/session"
 )
 
-func Logout(db *sql.DB, sc *session.Cache, logger *slog.Logger) http.HandlerFunc {
+func Logout(db *sql.DB, sc *session.Cache, auditLog *audit.Log, rn *render.Renderer, logger *slog.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 		request_id := uuid.New().String()
@@ -20,18 +26,22 @@ func Logout(db *sql.DB, sc *session.Cache, logger *slog.Logger) http.HandlerFunc
 
 		session, err := sc.GetSession(r)
 		if err != nil {
-			logger.ErrorContext(ctx, "Failed to get session", "error", err)
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			rn.Error(ctx, w, r, apperr.Unauthorized().With(err))
 			return
 		}
 		logger.DebugContext(ctx, "Logout page loaded session", "username", session.Username)
 
 		if err = sc.EndSession(w, r); err != nil {
-			logger.ErrorContext(ctx, "Failed to delete session", "error", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			rn.Error(ctx, w, r, apperr.Internal(fmt.Errorf("failed to delete session: %w", err)))
 			return
 		}
 
+		e := audit.Event{UserID: session.UserID, ActorID: session.UserID, Event: audit.Logout}
+		if err := auditLog.Record(ctx, r, e); err != nil {
+			logger.ErrorContext(ctx, "Failed to record audit event", "error", err)
+		}
+
+		flash.Set(w, flash.Info, i18n.T(ctx, "You have been logged out"))
 		http.Redirect(w, r, "/login", http.StatusSeeOther)
 	}
 }
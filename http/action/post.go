@@ -0,0 +1,154 @@
+package action
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/somethingsoftware/violet-web/http/apperr"
+	"github.com/somethingsoftware/violet-web/http/markdown"
+	"github.com/somethingsoftware/violet-web/http/post"
+	"github.com/somethingsoftware/violet-web/http/render"
+	"github.com/somethingsoftware/violet-web/http/session"
+)
+
+func CreatePost(db *sql.DB, sc *session.Cache, rn *render.Renderer, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		request_id := uuid.New().String()
+		ctx = context.WithValue(ctx, "request_id", request_id)
+		logger.DebugContext(ctx, "Create post action called")
+
+		session, err := sc.GetSession(r)
+		if err != nil {
+			rn.Error(ctx, w, r, apperr.Unauthorized().With(err))
+			return
+		}
+
+		title := r.FormValue("title")
+		body := r.FormValue("body")
+		if err := post.Validate(title, body); err != nil {
+			rn.Error(ctx, w, r, apperr.BadRequest(err.Error()))
+			return
+		}
+
+		id, err := post.Create(db, session.UserID, title, body)
+		if err != nil {
+			rn.Error(ctx, w, r, apperr.Internal(fmt.Errorf("failed to create post: %w", err)))
+			return
+		}
+
+		logger.DebugContext(ctx, "Created post", "post_id", id, "username", session.Username)
+		http.Redirect(w, r, "/posts/"+strconv.FormatUint(id, 10), http.StatusSeeOther)
+	}
+}
+
+func UpdatePost(db *sql.DB, sc *session.Cache, rn *render.Renderer, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		request_id := uuid.New().String()
+		ctx = context.WithValue(ctx, "request_id", request_id)
+		logger.DebugContext(ctx, "Update post action called")
+
+		p, ok := ownPost(ctx, w, r, db, sc, rn, logger)
+		if !ok {
+			return
+		}
+
+		title := r.FormValue("title")
+		body := r.FormValue("body")
+		if err := post.Validate(title, body); err != nil {
+			rn.Error(ctx, w, r, apperr.BadRequest(err.Error()))
+			return
+		}
+
+		if err := post.Update(db, p.ID, title, body); err != nil {
+			rn.Error(ctx, w, r, apperr.Internal(fmt.Errorf("failed to update post: %w", err)))
+			return
+		}
+
+		http.Redirect(w, r, "/posts/"+strconv.FormatUint(p.ID, 10), http.StatusSeeOther)
+	}
+}
+
+func DeletePost(db *sql.DB, sc *session.Cache, rn *render.Renderer, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		request_id := uuid.New().String()
+		ctx = context.WithValue(ctx, "request_id", request_id)
+		logger.DebugContext(ctx, "Delete post action called")
+
+		p, ok := ownPost(ctx, w, r, db, sc, rn, logger)
+		if !ok {
+			return
+		}
+
+		if err := post.Delete(db, p.ID); err != nil {
+			rn.Error(ctx, w, r, apperr.Internal(fmt.Errorf("failed to delete post: %w", err)))
+			return
+		}
+
+		http.Redirect(w, r, "/posts", http.StatusSeeOther)
+	}
+}
+
+// ownPost gets the post named by the {id} path value and checks that it
+// belongs to the logged in user. It writes the error response itself and
+// returns false if it doesn't.
+func ownPost(ctx context.Context, w http.ResponseWriter, r *http.Request,
+	db *sql.DB, sc *session.Cache, rn *render.Renderer, logger *slog.Logger) (post.Post, bool) {
+	session, err := sc.GetSession(r)
+	if err != nil {
+		rn.Error(ctx, w, r, apperr.Unauthorized().With(err))
+		return post.Post{}, false
+	}
+
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		rn.Error(ctx, w, r, apperr.NotFound())
+		return post.Post{}, false
+	}
+	p, err := post.Get(db, id, true)
+	if errors.Is(err, post.ErrNotFound) {
+		rn.Error(ctx, w, r, apperr.NotFound())
+		return post.Post{}, false
+	} else if err != nil {
+		rn.Error(ctx, w, r, apperr.Internal(fmt.Errorf("failed to get post: %w", err)))
+		return post.Post{}, false
+	}
+
+	if p.UserID != session.UserID {
+		logger.WarnContext(ctx, "User tried to change a post they don't own",
+			"user_id", session.UserID, "post_id", p.ID)
+		rn.Error(ctx, w, r, apperr.Forbidden())
+		return post.Post{}, false
+	}
+	return p, true
+}
+
+// PreviewPost renders the submitted markdown the same way a saved post would
+// be, for the live preview in the post form. Nothing is stored so it doesn't
+// spend a csrf token.
+func PreviewPost(rn *render.Renderer, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body := r.FormValue("body")
+		if len(body) > post.BodyLenMax*4 {
+			rn.Error(r.Context(), w, r, apperr.BadRequest(post.ErrBodyLength.Error()))
+			return
+		}
+		rendered, err := markdown.Render(body)
+		if err != nil {
+			rn.Error(r.Context(), w, r, apperr.Internal(fmt.Errorf("failed to render preview: %w", err)))
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if _, err := w.Write([]byte(rendered)); err != nil {
+			logger.Error("Failed to write preview", "error", err)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package action
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/somethingsoftware/violet-web/http/apperr"
+	"github.com/somethingsoftware/violet-web/http/i18n"
+	"github.com/somethingsoftware/violet-web/http/render"
+	"github.com/somethingsoftware/violet-web/http/session"
+)
+
+const bioLenMax = 500
+
+func UpdateProfile(db *sql.DB, sc *session.Cache, rn *render.Renderer, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		request_id := uuid.New().String()
+		ctx = context.WithValue(ctx, "request_id", request_id)
+		logger.DebugContext(ctx, "Update profile action called")
+
+		session, err := sc.GetSession(r)
+		if err != nil {
+			rn.Error(ctx, w, r, apperr.Unauthorized().With(err))
+			return
+		}
+
+		bio := r.FormValue("bio")
+		// unchecked checkboxes aren't sent at all
+		publicBio := r.FormValue("public_bio") == "on"
+		publicPosts := r.FormValue("public_posts") == "on"
+		// an empty locale means follow the browser
+		locale := r.FormValue("locale")
+		if locale != "" && !i18n.IsSupported(locale) {
+			rn.Error(ctx, w, r, apperr.BadRequest("Unsupported language"))
+			return
+		}
+
+		if utf8.RuneCountInString(bio) > bioLenMax {
+			rn.Error(ctx, w, r, apperr.BadRequest("Bio must be at most %d characters", bioLenMax))
+			return
+		}
+		query := "UPDATE user SET bio = ?, public_bio = ?, public_posts = ?, locale = ? WHERE id = ?;"
+		if _, err := db.Exec(query, bio, publicBio, publicPosts, locale, session.UserID); err != nil {
+			rn.Error(ctx, w, r, apperr.Internal(fmt.Errorf("failed to update profile: %w", err)))
+			return
+		}
+		sc.SetLocale(session.UserID, locale)
+
+		http.Redirect(w, r, "/u/"+session.Username, http.StatusSeeOther)
+	}
+}
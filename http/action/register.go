@@ -12,7 +12,15 @@ import (
 	"regexp"
 
 	"github.com/google/uuid"
+	"github.com/somethingsoftware/violet-web/http/account"
+	"github.com/somethingsoftware/violet-web/http/apperr"
+	"github.com/somethingsoftware/violet-web/http/audit"
 	"github.com/somethingsoftware/violet-web/http/auth"
+	"github.com/somethingsoftware/violet-web/http/csrf"
+	"github.com/somethingsoftware/violet-web/http/flash"
+	"github.com/somethingsoftware/violet-web/http/form"
+	"github.com/somethingsoftware/violet-web/http/i18n"
+	"github.com/somethingsoftware/violet-web/http/render"
 )
 
 var usernameRe = regexp.MustCompile(`^[a-zA-Z0-9_]+$`)
@@ -23,7 +31,11 @@ const usernameLenMax = 32
 
 const passwordLenMin = 12
 
-func Register(db *sql.DB, logger *slog.Logger) http.HandlerFunc {
+// registeredMessage is shown whether or not the account was created, see
+// Register
+const registeredMessage = "Thanks for registering, unless that email address already has an account you can log in now"
+
+func Register(db *sql.DB, csrfProvider *csrf.Provider, rn *render.Renderer, auditLog *audit.Log, logger *slog.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 		request_id := uuid.New().String()
@@ -35,44 +47,67 @@ func Register(db *sql.DB, logger *slog.Logger) http.HandlerFunc {
 		password := r.FormValue("password")
 		passwordConfirm := r.FormValue("confirm_password")
 
-		if len(username) < usernameLenMin || len(username) > usernameLenMax {
-			requirement := fmt.Sprintf("Username must be between %d and %d characters",
-				usernameLenMin, usernameLenMax)
-			http.Error(w, requirement, http.StatusBadRequest)
-			return
-		}
-		if !usernameRe.MatchString(username) {
-			http.Error(w, usernameReError, http.StatusBadRequest)
-			return
+		f := form.New()
+		f.Set("username", username)
+		f.Set("email", email)
+
+		if err := CheckUsername(username); err != nil {
+			usernameError(ctx, f, err)
 		}
 		if _, err := mail.ParseAddress(email); err != nil {
-			http.Error(w, "Invalid email address", http.StatusBadRequest)
+			f.Error("email", i18n.T(ctx, "Invalid email address"))
+		}
+		// telling the visitor the email is taken would tell anyone which
+		// addresses have an account, so only the username gets an error and a
+		// taken email ends up at the same page as a new account
+		var existingID uint64
+		if f.Valid() {
+			var usernameTaken bool
+			query := "SELECT EXISTS(SELECT 1 FROM user WHERE username = ?), COALESCE((SELECT id FROM user WHERE email = ?), 0);"
+			if err := db.QueryRow(query, username, email).Scan(&usernameTaken, &existingID); err != nil {
+				rn.Error(ctx, w, r, apperr.Internal(fmt.Errorf("failed to check for existing user: %w", err)))
+				return
+			}
+			if usernameTaken {
+				f.Error("username", i18n.T(ctx, "That username is taken"))
+			}
+		}
+		passwordError(ctx, f, checkPassword(password, passwordConfirm))
+		if !f.Valid() {
+			renderForm(ctx, w, r, csrfProvider, rn, logger, http.StatusBadRequest, "register.gotmpl", f)
 			return
 		}
 
+		// hashed either way so the response takes as long
 		hashString, saltString, err := CheckAndHashPassword(password, passwordConfirm)
 		if err != nil {
-			if errors.Is(err, ErrPasswordMismatch) {
-				http.Error(w, "Passwords do not match", http.StatusBadRequest)
-				return
-			}
-			if errors.Is(err, ErrPasswordTooShort) {
-				http.Error(w, fmt.Sprintf("Password must be at least %d characters", passwordLenMin), http.StatusBadRequest)
-				return
+			rn.Error(ctx, w, r, apperr.Internal(fmt.Errorf("failed to hash password: %w", err)))
+			return
+		}
+
+		if existingID != 0 {
+			e := audit.Event{UserID: existingID, Event: audit.RegisterEmailTaken}
+			if err := auditLog.Record(ctx, r, e); err != nil {
+				logger.ErrorContext(ctx, "Failed to record audit event", "error", err)
 			}
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			logger.ErrorContext(ctx, "Failed to hash password", "error", err)
+			logger.InfoContext(ctx, "Registration with an email already in use", "user_id", existingID)
+			flash.Set(w, flash.Success, i18n.T(ctx, registeredMessage))
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
 			return
 		}
 
-		query := "INSERT INTO user (username, email, salt, password_hash) VALUES (?, ?, ?, ?);"
-		if _, err = db.Exec(query, username, email, saltString, hashString); err != nil {
-			logger.ErrorContext(ctx, "Failed to create user", "error", err)
-			http.Error(w, "Failed to create user", http.StatusInternalServerError)
+		userID, err := account.Create(db, username, email, saltString, hashString)
+		if err != nil {
+			rn.Error(ctx, w, r, apperr.Internal(err))
 			return
 		}
+		e := audit.Event{UserID: userID, ActorID: userID, Event: audit.Register}
+		if err := auditLog.Record(ctx, r, e); err != nil {
+			logger.ErrorContext(ctx, "Failed to record audit event", "error", err)
+		}
 
 		logger.DebugContext(ctx, "Created user", "username", username)
+		flash.Set(w, flash.Success, i18n.T(ctx, registeredMessage))
 		http.Redirect(w, r, "/login", http.StatusSeeOther)
 		return
 	}
@@ -81,12 +116,55 @@ func Register(db *sql.DB, logger *slog.Logger) http.HandlerFunc {
 var ErrPasswordMismatch = fmt.Errorf("passwords do not match")
 var ErrPasswordTooShort = fmt.Errorf("password must be longer than %d characters", passwordLenMin)
 
-func CheckAndHashPassword(password string, passwordConfirm string) (hashStr, saltStr string, err error) {
+var ErrUsernameLength = fmt.Errorf("username must be between %d and %d characters", usernameLenMin, usernameLenMax)
+var ErrUsernameChars = errors.New("username must be alphanumeric and underscores only")
+
+// CheckUsername returns every rule username breaks, joined
+func CheckUsername(username string) error {
+	var errs []error
+	if len(username) < usernameLenMin || len(username) > usernameLenMax {
+		errs = append(errs, ErrUsernameLength)
+	}
+	if !usernameRe.MatchString(username) {
+		errs = append(errs, ErrUsernameChars)
+	}
+	return errors.Join(errs...)
+}
+
+// usernameError puts the rules a CheckUsername error names on the form
+func usernameError(ctx context.Context, f *form.Form, err error) {
+	if errors.Is(err, ErrUsernameLength) {
+		f.Error("username", i18n.T(ctx, "Username must be between %d and %d characters",
+			usernameLenMin, usernameLenMax))
+	}
+	if errors.Is(err, ErrUsernameChars) {
+		f.Error("username", i18n.T(ctx, usernameReError))
+	}
+}
+
+func checkPassword(password string, passwordConfirm string) error {
 	if len(password) < passwordLenMin {
-		return "", "", ErrPasswordMismatch
+		return ErrPasswordTooShort
 	}
 	if password != passwordConfirm {
-		return "", "", ErrPasswordMismatch
+		return ErrPasswordMismatch
+	}
+	return nil
+}
+
+// passwordError puts a checkPassword error on the field the user has to fix
+func passwordError(ctx context.Context, f *form.Form, err error) {
+	switch {
+	case errors.Is(err, ErrPasswordTooShort):
+		f.Error("password", i18n.T(ctx, "Password must be at least %d characters", passwordLenMin))
+	case errors.Is(err, ErrPasswordMismatch):
+		f.Error("confirm_password", i18n.T(ctx, "Passwords do not match"))
+	}
+}
+
+func CheckAndHashPassword(password string, passwordConfirm string) (hashStr, saltStr string, err error) {
+	if err := checkPassword(password, passwordConfirm); err != nil {
+		return "", "", err
 	}
 	// TODO: use passwordcritic here to prevent bad passwords instead of
 	// implementing arcane capitalization or inclusion rules
@@ -0,0 +1,211 @@
+package action
+
+import (
+	"database/sql"
+	"errors"
+	"html"
+	"html/template"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	_ "github.com/glebarez/go-sqlite"
+	"github.com/somethingsoftware/violet-web/http/audit"
+	"github.com/somethingsoftware/violet-web/http/csrf"
+	"github.com/somethingsoftware/violet-web/http/render"
+	"github.com/somethingsoftware/violet-web/migrate"
+)
+
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "test.db")+"?_pragma=foreign_keys(1)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := migrate.AutoUP(db, slog.New(slog.NewTextHandler(io.Discard, nil))); err != nil {
+		t.Fatal(err)
+	}
+	query := "INSERT INTO user (id, username, email, salt, password_hash) VALUES (1, 'alice', 'alice@example.com', '', '');"
+	if _, err := db.Exec(query); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func newTestRenderer(t *testing.T) *render.Renderer {
+	t.Helper()
+	funcs := template.FuncMap{"asset": func(name string) string { return "/static/" + name }}
+	rn, err := render.New(os.DirFS("../gotmpl"), false, funcs, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rn
+}
+
+var csrfTokenRe = regexp.MustCompile(`name="csrf_token" value="([^"]+)"`)
+
+func TestRegisterRerender(t *testing.T) {
+	db := openTestDB(t)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	auditLog := audit.NewLog(db, logger)
+	rn := newTestRenderer(t)
+	handler := Register(db, csrf.NewProvider(db, rn, auditLog, logger), rn, auditLog, logger)
+
+	tests := []struct {
+		name     string
+		username string
+		email    string
+		password string
+		confirm  string
+		want     string
+	}{
+		{
+			name:     "bad username",
+			username: "a!",
+			email:    "new@example.com",
+			password: "correct horse battery",
+			confirm:  "correct horse battery",
+			want:     "Username must be between 3 and 32 characters",
+		},
+		{
+			name:     "bad email",
+			username: "carol",
+			email:    "not an email",
+			password: "correct horse battery",
+			confirm:  "correct horse battery",
+			want:     "Invalid email address",
+		},
+		{
+			name:     "username taken",
+			username: "alice",
+			email:    "new@example.com",
+			password: "correct horse battery",
+			confirm:  "correct horse battery",
+			want:     "That username is taken",
+		},
+		{
+			name:     "passwords differ",
+			username: "carol",
+			email:    "carol@example.com",
+			password: "correct horse battery",
+			confirm:  "correct horse staple!",
+			want:     "Passwords do not match",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{
+				"username":         {tt.username},
+				"email":            {tt.email},
+				"password":         {tt.password},
+				"confirm_password": {tt.confirm},
+			}
+			r := httptest.NewRequest(http.MethodPost, "/register", strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+			handler(w, r)
+
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusBadRequest)
+			}
+			body := w.Body.String()
+			if !strings.Contains(body, template.HTMLEscapeString(tt.want)) {
+				t.Errorf("page doesn't show %q", tt.want)
+			}
+			if !strings.Contains(body, `value="`+template.HTMLEscapeString(tt.email)+`"`) {
+				t.Error("page doesn't keep the email address")
+			}
+			if strings.Contains(body, "correct horse") {
+				t.Error("page puts the password back in the form")
+			}
+			m := csrfTokenRe.FindStringSubmatch(body)
+			if m == nil {
+				t.Fatal("page has no csrf token")
+			}
+			var used bool
+			if err := db.QueryRow("SELECT used FROM csrf WHERE csrf_token = ?;", html.UnescapeString(m[1])).Scan(&used); err != nil || used {
+				t.Errorf("csrf token isn't a fresh one: used %v, %v", used, err)
+			}
+		})
+	}
+
+	var users int
+	if err := db.QueryRow("SELECT COUNT(*) FROM user;").Scan(&users); err != nil || users != 1 {
+		t.Errorf("%d users after failed registrations, %v", users, err)
+	}
+}
+
+func TestRegisterEmailTaken(t *testing.T) {
+	db := openTestDB(t)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	auditLog := audit.NewLog(db, logger)
+	rn := newTestRenderer(t)
+	handler := Register(db, csrf.NewProvider(db, rn, auditLog, logger), rn, auditLog, logger)
+	register := func(username, email string) *httptest.ResponseRecorder {
+		form := url.Values{
+			"username":         {username},
+			"email":            {email},
+			"password":         {"correct horse battery"},
+			"confirm_password": {"correct horse battery"},
+		}
+		r := httptest.NewRequest(http.MethodPost, "/register", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w
+	}
+
+	// a visitor can't tell a taken email address from a new account
+	taken := register("carol", "alice@example.com")
+	created := register("dave", "dave@example.com")
+	for _, w := range []*httptest.ResponseRecorder{taken, created} {
+		if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/login" {
+			t.Errorf("register = %d to %s, want a redirect to /login", w.Code, w.Header().Get("Location"))
+		}
+	}
+	if a, b := taken.Result().Cookies(), created.Result().Cookies(); len(a) != 1 || len(b) != 1 || a[0].Value != b[0].Value {
+		t.Errorf("flash cookies %v and %v differ", a, b)
+	}
+
+	var users int
+	if err := db.QueryRow("SELECT COUNT(*) FROM user WHERE username IN ('carol', 'dave');").Scan(&users); err != nil || users != 1 {
+		t.Errorf("%d of carol and dave registered, %v, want only dave", users, err)
+	}
+	var events int
+	query := "SELECT COUNT(*) FROM audit_log WHERE user_id = 1 AND event = ?;"
+	if err := db.QueryRow(query, audit.RegisterEmailTaken).Scan(&events); err != nil || events != 1 {
+		t.Errorf("%d %s events for alice, %v, want 1", events, audit.RegisterEmailTaken, err)
+	}
+}
+
+func TestCheckUsername(t *testing.T) {
+	tests := []struct {
+		username string
+		want     []error
+	}{
+		{username: "alice_2"},
+		{username: "al", want: []error{ErrUsernameLength}},
+		{username: strings.Repeat("a", usernameLenMax+1), want: []error{ErrUsernameLength}},
+		{username: "alice smith", want: []error{ErrUsernameChars}},
+		{username: "a!", want: []error{ErrUsernameLength, ErrUsernameChars}},
+	}
+	for _, tt := range tests {
+		err := CheckUsername(tt.username)
+		if (err == nil) != (len(tt.want) == 0) {
+			t.Errorf("CheckUsername(%q) = %v, want %v", tt.username, err, tt.want)
+		}
+		for _, want := range tt.want {
+			if !errors.Is(err, want) {
+				t.Errorf("CheckUsername(%q) = %v, want %v", tt.username, err, want)
+			}
+		}
+	}
+}
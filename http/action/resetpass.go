@@ -3,18 +3,21 @@ package action
 import (
 	"context"
 	"database/sql"
-	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
-	"os"
-	"path/filepath"
-	"text/template"
 
 	"github.com/google/uuid"
+	"github.com/somethingsoftware/violet-web/http/account"
+	"github.com/somethingsoftware/violet-web/http/apperr"
+	"github.com/somethingsoftware/violet-web/http/audit"
+	"github.com/somethingsoftware/violet-web/http/flash"
+	"github.com/somethingsoftware/violet-web/http/form"
+	"github.com/somethingsoftware/violet-web/http/i18n"
+	"github.com/somethingsoftware/violet-web/http/render"
 )
 
-func ResetPassForm(db *sql.DB, logger *slog.Logger) http.HandlerFunc {
+func ResetPassForm(db *sql.DB, rn *render.Renderer, logger *slog.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 		request_id := uuid.New().String()
@@ -23,36 +26,16 @@ func ResetPassForm(db *sql.DB, logger *slog.Logger) http.HandlerFunc {
 
 		resetPassToken := r.FormValue("token")
 
-		// TODO: relative path bad
-		templatePath := filepath.Join(".", "gotmpl", "reset-pass.gotmpl")
-		templateContent, err := os.ReadFile(templatePath)
-		if err != nil {
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			logger.Error("Failed to read csrf template", "error", err, "path", templatePath)
-			return
-		}
-		t, err := template.New("resetPassForm").Parse(string(templateContent))
-		if err != nil {
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			logger.Error("Failed to parse template", "error", err)
-			return
-		}
-		type ResetPassForm struct {
-			ResetPassToken string
-		}
-		form := ResetPassForm{ResetPassToken: resetPassToken}
-		if err = t.Execute(w, form); err != nil {
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			logger.Error("Failed to execute template", "error", err)
-			return
-		}
+		f := form.New()
+		f.Set("reset_password_token", resetPassToken)
+		rn.Render(w, r, "reset-pass.gotmpl", f)
 
 		// http.Redirect(w, r, "/login", http.StatusSeeOther)
 		// return
 	}
 }
 
-func ResetPass(db *sql.DB, logger *slog.Logger) http.HandlerFunc {
+func ResetPass(db *sql.DB, rn *render.Renderer, auditLog *audit.Log, logger *slog.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 		request_id := uuid.New().String()
@@ -63,20 +46,29 @@ func ResetPass(db *sql.DB, logger *slog.Logger) http.HandlerFunc {
 		password := r.FormValue("password")
 		passwordConfirm := r.FormValue("confirm_password")
 
+		// the token goes back in the form so a typo in the password doesn't
+		// mean following the link again
+		f := form.New()
+		f.Set("reset_password_token", resetPasswordToken)
+
 		query := "SELECT user_id FROM forgot_password WHERE token = ? AND used = 0;"
 		res := db.QueryRow(query, resetPasswordToken)
 		var userID uint64
 		if err := res.Scan(&userID); err != nil {
 			logger.ErrorContext(ctx, "Failed to find token in database", "error", err)
-			http.Error(w, "Invalid token", http.StatusBadRequest)
+			f.Error("reset_password_token", i18n.T(ctx, "This reset link is invalid or has already been used"))
+		}
+		// check the password before using up the token
+		passwordError(ctx, f, checkPassword(password, passwordConfirm))
+		if !f.Valid() {
+			renderForm(ctx, w, r, nil, rn, logger, http.StatusBadRequest, "reset-pass.gotmpl", f)
 			return
 		}
 
 		// mark the token as used
 		query = "UPDATE forgot_password SET used = 1 WHERE token = ?;"
 		if _, err := db.Exec(query, resetPasswordToken); err != nil {
-			logger.ErrorContext(ctx, "Failed to mark token as used", "error", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			rn.Error(ctx, w, r, apperr.Internal(fmt.Errorf("failed to mark token as used: %w", err)))
 			return
 		}
 
@@ -84,27 +76,22 @@ func ResetPass(db *sql.DB, logger *slog.Logger) http.HandlerFunc {
 
 		hashString, saltString, err := CheckAndHashPassword(password, passwordConfirm)
 		if err != nil {
-			if errors.Is(err, ErrPasswordMismatch) {
-				http.Error(w, "Passwords do not match", http.StatusBadRequest)
-				return
-			}
-			if errors.Is(err, ErrPasswordTooShort) {
-				http.Error(w, fmt.Sprintf("Password must be at least %d characters", passwordLenMin), http.StatusBadRequest)
-				return
-			}
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			logger.ErrorContext(ctx, "Failed to hash password", "error", err)
+			rn.Error(ctx, w, r, apperr.Internal(fmt.Errorf("failed to hash password: %w", err)))
 			return
 		}
 
-		query = "UPDATE user SET password_hash = ?, salt = ? WHERE id = ?;"
-		if _, err = db.Exec(query, hashString, saltString, userID); err != nil {
-			logger.ErrorContext(ctx, "Failed to update password", "error", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		if err := account.SetPassword(db, userID, saltString, hashString); err != nil {
+			rn.Error(ctx, w, r, apperr.Internal(err))
 			return
 		}
 
+		e := audit.Event{UserID: userID, ActorID: userID, Event: audit.ResetPassword}
+		if err := auditLog.Record(ctx, r, e); err != nil {
+			logger.ErrorContext(ctx, "Failed to record audit event", "error", err)
+		}
+
 		// send the user to the login page
+		flash.Set(w, flash.Success, i18n.T(ctx, "Your password has been changed, log in with your new password"))
 		http.Redirect(w, r, "/login", http.StatusSeeOther)
 	}
 }
@@ -0,0 +1,79 @@
+package apperr
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Error is what handlers give to the renderer when a request fails. Message
+// is shown to the user after translation with Args filled in, Cause is only
+// ever logged.
+type Error struct {
+	Status  int
+	Message string
+	Args    []any
+	Cause   error
+}
+
+func New(status int, message string, args ...any) *Error {
+	return &Error{Status: status, Message: message, Args: args}
+}
+
+func (e *Error) Error() string {
+	if e.Cause != nil {
+		return e.Text() + ": " + e.Cause.Error()
+	}
+	return e.Text()
+}
+
+// Text is the untranslated public message with the Args filled in
+func (e *Error) Text() string {
+	if len(e.Args) == 0 {
+		return e.Message
+	}
+	return fmt.Sprintf(e.Message, e.Args...)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Cause
+}
+
+// With returns a copy of the error carrying the cause for the logs
+func (e *Error) With(cause error) *Error {
+	c := *e
+	c.Cause = cause
+	return &c
+}
+
+func BadRequest(message string, args ...any) *Error {
+	return New(http.StatusBadRequest, message, args...)
+}
+
+func Unauthorized() *Error {
+	return New(http.StatusUnauthorized, "Unauthorized")
+}
+
+func Forbidden() *Error {
+	return New(http.StatusForbidden, "Forbidden")
+}
+
+func NotFound() *Error {
+	return New(http.StatusNotFound, "Not found")
+}
+
+// Internal hides the cause behind a generic message, there is nothing the
+// user can do about it
+func Internal(cause error) *Error {
+	return New(http.StatusInternalServerError, "Internal server error").With(cause)
+}
+
+// From turns any error into an *Error, anything that isn't one already is
+// treated as internal
+func From(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	return Internal(err)
+}
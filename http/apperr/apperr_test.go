@@ -0,0 +1,51 @@
+package apperr
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestError(t *testing.T) {
+	cause := errors.New("disk on fire")
+	e := BadRequest("Title must be at most %d characters", 100)
+	if e.Status != http.StatusBadRequest || e.Text() != "Title must be at most 100 characters" {
+		t.Errorf("BadRequest() = %+v, %s", e, e.Text())
+	}
+
+	withCause := e.With(cause)
+	if e.Cause != nil {
+		t.Error("With() changed the original error")
+	}
+	if !errors.Is(withCause, cause) || withCause.Error() != "Title must be at most 100 characters: disk on fire" {
+		t.Errorf("With() = %v", withCause)
+	}
+
+	internal := Internal(cause)
+	if internal.Status != http.StatusInternalServerError || internal.Text() != "Internal server error" {
+		t.Errorf("Internal() = %+v, want the cause kept out of the message", internal)
+	}
+}
+
+func TestFrom(t *testing.T) {
+	notFound := NotFound()
+	tests := []struct {
+		name   string
+		err    error
+		status int
+	}{
+		{name: "app error", err: notFound, status: http.StatusNotFound},
+		{name: "wrapped app error", err: fmt.Errorf("loading post: %w", notFound), status: http.StatusNotFound},
+		{name: "plain error", err: errors.New("boom"), status: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		e := From(tt.err)
+		if e.Status != tt.status {
+			t.Errorf("%s: From() status = %d, want %d", tt.name, e.Status, tt.status)
+		}
+	}
+	if From(notFound) != notFound {
+		t.Error("From() of an *Error isn't the same error")
+	}
+}
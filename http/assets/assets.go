@@ -0,0 +1,249 @@
+package assets
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+)
+
+// Prefix is where hashed assets are served from
+const Prefix = "/static/"
+
+// file is one static asset with its compressed variants, the compressed
+// versions are left nil when they wouldn't be any smaller
+type file struct {
+	name        string
+	hashed      string
+	hash        string
+	etag        string
+	contentType string
+	raw         []byte
+	gzip        []byte
+	brotli      []byte
+}
+
+// Assets serves the static files. Each file is also available under a name
+// containing a hash of its content, those never change so they are cached
+// for a year. Compression is done once when the files are loaded.
+type Assets struct {
+	fsys   fs.FS
+	reload bool
+	logger *slog.Logger
+
+	mu       sync.RWMutex
+	byName   map[string]*file
+	byHashed map[string]*file
+}
+
+// New loads every file in fsys. With reload set the files are read again on
+// every request so edits show up in dev mode, without compressing them.
+func New(fsys fs.FS, reload bool, logger *slog.Logger) (*Assets, error) {
+	a := &Assets{
+		fsys:   fsys,
+		reload: reload,
+		logger: logger,
+	}
+	if err := a.load(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *Assets) load() error {
+	byName := make(map[string]*file)
+	byHashed := make(map[string]*file)
+	err := fs.WalkDir(a.fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		raw, err := fs.ReadFile(a.fsys, name)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		sum := sha256.Sum256(raw)
+		hash := hex.EncodeToString(sum[:])[:12]
+		ext := path.Ext(name)
+
+		f := &file{
+			name:        name,
+			hashed:      strings.TrimSuffix(name, ext) + "." + hash + ext,
+			hash:        hash,
+			etag:        `"` + hash + `"`,
+			contentType: mime.TypeByExtension(ext),
+			raw:         raw,
+		}
+		if f.contentType == "" {
+			f.contentType = http.DetectContentType(raw)
+		}
+		if !a.reload && compressible(f.contentType) {
+			if f.gzip, err = gzipBytes(raw); err != nil {
+				return fmt.Errorf("failed to gzip %s: %w", name, err)
+			}
+			if f.brotli, err = brotliBytes(raw); err != nil {
+				return fmt.Errorf("failed to brotli %s: %w", name, err)
+			}
+		}
+		byName[name] = f
+		byHashed[f.hashed] = f
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to load assets: %w", err)
+	}
+
+	a.mu.Lock()
+	a.byName = byName
+	a.byHashed = byHashed
+	a.mu.Unlock()
+	return nil
+}
+
+func compressible(contentType string) bool {
+	return strings.HasPrefix(contentType, "text/") ||
+		strings.HasPrefix(contentType, "application/javascript") ||
+		strings.HasPrefix(contentType, "application/json") ||
+		strings.HasPrefix(contentType, "image/svg+xml") ||
+		strings.HasPrefix(contentType, "image/vnd.microsoft.icon") ||
+		strings.HasPrefix(contentType, "image/x-icon")
+}
+
+func gzipBytes(raw []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := zw.Write(raw); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	if buf.Len() >= len(raw) {
+		return nil, nil
+	}
+	return buf.Bytes(), nil
+}
+
+func brotliBytes(raw []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	bw := brotli.NewWriterLevel(&buf, brotli.BestCompression)
+	if _, err := bw.Write(raw); err != nil {
+		return nil, err
+	}
+	if err := bw.Close(); err != nil {
+		return nil, err
+	}
+	if buf.Len() >= len(raw) {
+		return nil, nil
+	}
+	return buf.Bytes(), nil
+}
+
+func (a *Assets) files() (map[string]*file, map[string]*file) {
+	if a.reload {
+		if err := a.load(); err != nil {
+			a.logger.Error("Failed to reload assets", "error", err)
+		}
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.byName, a.byHashed
+}
+
+// Path is the url of the hashed version of the named asset, it is the
+// "asset" template function. Unknown names are returned unhashed so a typo
+// shows up as a 404 rather than a template error.
+func (a *Assets) Path(name string) string {
+	byName, _ := a.files()
+	if f, ok := byName[strings.TrimPrefix(name, "/")]; ok {
+		return Prefix + f.hashed
+	}
+	return Prefix + strings.TrimPrefix(name, "/")
+}
+
+// ServeHashed serves "GET /static/{name}". Only hashed names are served from
+// here so anything under /static can be cached forever.
+func (a *Assets) ServeHashed(w http.ResponseWriter, r *http.Request) {
+	_, byHashed := a.files()
+	f, ok := byHashed[r.PathValue("name")]
+	if !ok {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	a.serve(w, r, f)
+}
+
+// Serve serves the named asset under its own name, the browser has to check
+// it is still fresh before using its cached copy
+func (a *Assets) Serve(w http.ResponseWriter, r *http.Request, name string) {
+	byName, _ := a.files()
+	f, ok := byName[name]
+	if !ok {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	a.serve(w, r, f)
+}
+
+func (a *Assets) serve(w http.ResponseWriter, r *http.Request, f *file) {
+	// each encoding is a different representation so gets its own etag
+	body, encoding := f.raw, ""
+	accept := r.Header.Get("Accept-Encoding")
+	switch {
+	case f.brotli != nil && acceptsEncoding(accept, "br"):
+		body, encoding = f.brotli, "br"
+	case f.gzip != nil && acceptsEncoding(accept, "gzip"):
+		body, encoding = f.gzip, "gzip"
+	}
+	etag := f.etag
+	if encoding != "" {
+		etag = `"` + f.hash + "-" + encoding + `"`
+		w.Header().Set("Content-Encoding", encoding)
+	}
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Vary", "Accept-Encoding")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if match := r.Header.Get("If-None-Match"); match != "" &&
+		(strings.Contains(match, etag) || match == "*") {
+		w.Header().Del("Content-Encoding")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", f.contentType)
+	w.Header().Set("Content-Length", fmt.Sprint(len(body)))
+	if r.Method == http.MethodHead {
+		return
+	}
+	if _, err := w.Write(body); err != nil {
+		a.logger.Error("Failed to write asset", "error", err, "name", f.name)
+	}
+}
+
+// acceptsEncoding checks an Accept-Encoding header for the coding, a q value
+// of 0 means the client refuses it
+func acceptsEncoding(header, coding string) bool {
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(name), coding) {
+			continue
+		}
+		q := strings.ReplaceAll(strings.TrimSpace(params), " ", "")
+		return q != "q=0" && q != "q=0.0" && q != "q=0.00" && q != "q=0.000"
+	}
+	return false
+}
@@ -0,0 +1,158 @@
+package assets
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+var testFS = fstest.MapFS{
+	"style.css": {Data: []byte(strings.Repeat("body { color: violet; }\n", 50))},
+	"logo.png":  {Data: []byte("\x89PNG\r\n\x1a\nnot really")},
+}
+
+func newTestAssets(t *testing.T) *Assets {
+	t.Helper()
+	a, err := New(testFS, false, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestPath(t *testing.T) {
+	a := newTestAssets(t)
+	hashed := a.Path("style.css")
+	if !strings.HasPrefix(hashed, "/static/style.") || !strings.HasSuffix(hashed, ".css") || hashed == "/static/style.css" {
+		t.Errorf("Path(style.css) = %s, want a hashed name", hashed)
+	}
+	if got := a.Path("/style.css"); got != hashed {
+		t.Errorf("Path(/style.css) = %s, want %s", got, hashed)
+	}
+	if got := a.Path("missing.js"); got != "/static/missing.js" {
+		t.Errorf("Path(missing.js) = %s", got)
+	}
+}
+
+func serveHashed(a *Assets, name string, header http.Header) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/static/"+name, nil)
+	r.SetPathValue("name", name)
+	for key, values := range header {
+		r.Header[key] = values
+	}
+	w := httptest.NewRecorder()
+	a.ServeHashed(w, r)
+	return w
+}
+
+func TestServeHashed(t *testing.T) {
+	a := newTestAssets(t)
+	name := strings.TrimPrefix(a.Path("style.css"), Prefix)
+	css := testFS["style.css"].Data
+
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		encoding       string
+	}{
+		{name: "identity"},
+		{name: "gzip", acceptEncoding: "gzip, deflate", encoding: "gzip"},
+		{name: "brotli preferred", acceptEncoding: "gzip, br", encoding: "br"},
+		{name: "brotli refused", acceptEncoding: "gzip, br;q=0", encoding: "gzip"},
+	}
+	etags := make(map[string]bool)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveHashed(a, name, http.Header{"Accept-Encoding": {tt.acceptEncoding}})
+			if w.Code != http.StatusOK || w.Header().Get("Content-Encoding") != tt.encoding {
+				t.Fatalf("response = %d with encoding %q, want 200 with %q", w.Code, w.Header().Get("Content-Encoding"), tt.encoding)
+			}
+			if cc := w.Header().Get("Cache-Control"); !strings.Contains(cc, "immutable") {
+				t.Errorf("Cache-Control = %s", cc)
+			}
+			if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/css") {
+				t.Errorf("Content-Type = %s", ct)
+			}
+			if tt.encoding == "" && !bytes.Equal(w.Body.Bytes(), css) {
+				t.Error("body isn't the file")
+			}
+			if tt.encoding == "gzip" {
+				zr, err := gzip.NewReader(w.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if body, err := io.ReadAll(zr); err != nil || !bytes.Equal(body, css) {
+					t.Errorf("gunzipped body differs from the file, %v", err)
+				}
+			}
+			etag := w.Header().Get("ETag")
+			etags[etag] = true
+
+			w = serveHashed(a, name, http.Header{"Accept-Encoding": {tt.acceptEncoding}, "If-None-Match": {etag}})
+			if w.Code != http.StatusNotModified || w.Body.Len() > 0 {
+				t.Errorf("revalidation = %d with %d bytes, want an empty 304", w.Code, w.Body.Len())
+			}
+		})
+	}
+	if len(etags) != 3 {
+		t.Errorf("etags = %v, want one per encoding", etags)
+	}
+
+	// the plain name is only served by Serve, which has to revalidate
+	if w := serveHashed(a, "style.css", nil); w.Code != http.StatusNotFound {
+		t.Errorf("unhashed name under /static = %d, want 404", w.Code)
+	}
+	w := httptest.NewRecorder()
+	a.Serve(w, httptest.NewRequest(http.MethodGet, "/style.css", nil), "style.css")
+	if w.Code != http.StatusOK || w.Header().Get("Cache-Control") != "no-cache" {
+		t.Errorf("Serve(style.css) = %d with Cache-Control %s", w.Code, w.Header().Get("Cache-Control"))
+	}
+}
+
+func TestServeIncompressible(t *testing.T) {
+	a := newTestAssets(t)
+	name := strings.TrimPrefix(a.Path("logo.png"), Prefix)
+	w := serveHashed(a, name, http.Header{"Accept-Encoding": {"br, gzip"}})
+	if w.Code != http.StatusOK || w.Header().Get("Content-Encoding") != "" {
+		t.Errorf("png = %d with encoding %q, want it uncompressed", w.Code, w.Header().Get("Content-Encoding"))
+	}
+}
+
+func TestReload(t *testing.T) {
+	fsys := fstest.MapFS{"app.js": {Data: []byte("one")}}
+	a, err := New(fsys, true, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := a.Path("app.js")
+	fsys["app.js"].Data = []byte("two")
+	if after := a.Path("app.js"); after == before {
+		t.Errorf("Path() = %s after an edit, want a new hash", after)
+	}
+}
+
+func TestAcceptsEncoding(t *testing.T) {
+	tests := []struct {
+		header, coding string
+		want           bool
+	}{
+		{header: "gzip, br", coding: "br", want: true},
+		{header: "GZIP", coding: "gzip", want: true},
+		{header: "br;q=0.5", coding: "br", want: true},
+		{header: "br; q=0", coding: "br", want: false},
+		{header: "br;q=0.000", coding: "br", want: false},
+		{header: "", coding: "gzip", want: false},
+		{header: "deflate", coding: "gzip", want: false},
+	}
+	for _, tt := range tests {
+		if got := acceptsEncoding(tt.header, tt.coding); got != tt.want {
+			t.Errorf("acceptsEncoding(%q, %q) = %v, want %v", tt.header, tt.coding, got, tt.want)
+		}
+	}
+}
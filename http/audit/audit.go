@@ -0,0 +1,180 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/somethingsoftware/violet-web/http/proxy"
+)
+
+// CREATE TABLE audit_log (
+// id INTEGER PRIMARY KEY AUTOINCREMENT,
+// user_id INTEGER,
+// actor_id INTEGER,
+// event TEXT NOT NULL,
+// detail TEXT NOT NULL DEFAULT '',
+// ip TEXT NOT NULL,
+// user_agent TEXT NOT NULL,
+// request_id TEXT NOT NULL,
+// created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP);
+
+const (
+	LoginSuccess   = "login.success"
+	LoginFailure   = "login.failure"
+	Logout         = "logout"
+	Register       = "register"
+	ForgotPassword = "password.forgot"
+	ResetPassword  = "password.reset"
+	CSRFFailure    = "csrf.failure"
+	RateLimited    = "rate_limited"
+
+	// someone tried to register with the email address of this account
+	RegisterEmailTaken = "register.email_taken"
+
+	AccountDeleteRequested = "account.delete_requested"
+	AccountDeleteCancelled = "account.delete_cancelled"
+	AccountDeleted         = "account.deleted"
+
+	DataExportRequested  = "data.export_requested"
+	DataExportDownloaded = "data.export_downloaded"
+
+	AdminDisable        = "admin.disable"
+	AdminEnable         = "admin.enable"
+	AdminForceReset     = "admin.force_reset"
+	AdminRevokeSessions = "admin.revoke_sessions"
+	AdminDelete         = "admin.delete"
+	AdminDeleteComment  = "admin.delete_comment"
+
+	// the operator changing users from the command line
+	CLIUserCreate    = "cli.user_create"
+	CLIUserDisable   = "cli.user_disable"
+	CLIUserEnable    = "cli.user_enable"
+	CLISetPassword   = "cli.set_password"
+	CLIGrantRole     = "cli.grant_role"
+	CLISessionsPurge = "cli.sessions_purge"
+)
+
+// Event is a single audit log entry. UserID is the account the event is
+// about and ActorID is the account that caused it, zero means nobody.
+type Event struct {
+	UserID  uint64
+	ActorID uint64
+	Event   string
+	Detail  string
+}
+
+// Entry is an Event as read back out of the audit_log table
+type Entry struct {
+	Event
+	IP        string
+	UserAgent string
+	RequestID string
+	CreatedAt time.Time
+}
+
+type Log struct {
+	db     *sql.DB
+	logger *slog.Logger
+}
+
+func NewLog(db *sql.DB, logger *slog.Logger) *Log {
+	return &Log{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// Record stores the event along with the ip, user agent and request id of
+// the request that caused it. Background jobs pass a nil request.
+func (l *Log) Record(ctx context.Context, r *http.Request, e Event) error {
+	requestID, ok := ctx.Value("request_id").(string)
+	if !ok {
+		// middleware runs before a handler has assigned a request id
+		requestID = uuid.New().String()
+	}
+	var ip, userAgent string
+	if r != nil {
+		ip = proxy.ClientIP(r)
+		userAgent = r.UserAgent()
+	}
+
+	query := `INSERT INTO audit_log (user_id, actor_id, event, detail, ip, user_agent, request_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?);`
+	_, err := l.db.Exec(query, nullID(e.UserID), nullID(e.ActorID),
+		e.Event, e.Detail, ip, userAgent, requestID)
+	if err != nil {
+		return fmt.Errorf("failed to insert audit event %s: %w", e.Event, err)
+	}
+	l.logger.InfoContext(ctx, "Audit event", "event", e.Event,
+		"user_id", e.UserID, "actor_id", e.ActorID, "ip", ip)
+	return nil
+}
+
+func nullID(id uint64) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(id), Valid: id != 0}
+}
+
+// UserEvents returns the most recent events about the user, newest first
+func (l *Log) UserEvents(userID uint64, limit int) ([]Entry, error) {
+	query := `SELECT event, detail, ip, user_agent, request_id, created_at
+		FROM audit_log WHERE user_id = ? ORDER BY id DESC LIMIT ?;`
+	rows, err := l.db.Query(query, userID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit events: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []Entry
+	for rows.Next() {
+		e := Entry{Event: Event{UserID: userID}}
+		if err := rows.Scan(&e.Event.Event, &e.Detail, &e.IP,
+			&e.UserAgent, &e.RequestID, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan audit event: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate audit events: %w", err)
+	}
+	return entries, nil
+}
+
+// Prune deletes every event older than the retention period
+func (l *Log) Prune(retention time.Duration) (int64, error) {
+	cutoff := time.Now().UTC().Add(-retention).Format(time.DateTime)
+	res, err := l.db.Exec("DELETE FROM audit_log WHERE created_at < ?;", cutoff)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune audit log: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// StartPruner prunes the audit log every interval until the context is done,
+// wg is done once the pruner has stopped
+func (l *Log) StartPruner(ctx context.Context, wg *sync.WaitGroup, every, retention time.Duration) {
+	ticker := time.NewTicker(every)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer ticker.Stop()
+		for {
+			pruned, err := l.Prune(retention)
+			if err != nil {
+				l.logger.Error("Failed to prune audit log", "error", err)
+			} else {
+				l.logger.Debug("Pruned audit log", "deleted", pruned)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
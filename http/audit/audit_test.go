@@ -0,0 +1,152 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/glebarez/go-sqlite"
+	"github.com/somethingsoftware/violet-web/migrate"
+)
+
+func newTestLog(t *testing.T) (*Log, *sql.DB) {
+	t.Helper()
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "test.db")+"?_pragma=foreign_keys(1)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	if err := migrate.AutoUP(db, logger); err != nil {
+		t.Fatal(err)
+	}
+	return NewLog(db, logger), db
+}
+
+func TestRecord(t *testing.T) {
+	l, db := newTestLog(t)
+	r := httptest.NewRequest(http.MethodPost, "/login", nil)
+	r.Header.Set("User-Agent", "test-agent")
+	ctx := context.WithValue(context.Background(), "request_id", "req-1")
+
+	events := []Event{
+		{UserID: 1, Event: LoginFailure},
+		{UserID: 1, Event: LoginSuccess},
+		{UserID: 2, ActorID: 1, Event: AdminDisable, Detail: "spam"},
+	}
+	for _, e := range events {
+		if err := l.Record(ctx, r, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := l.UserEvents(1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("UserEvents() returned %d entries, want 2", len(entries))
+	}
+	if entries[0].Event.Event != LoginSuccess || entries[1].Event.Event != LoginFailure {
+		t.Errorf("UserEvents() = %s, %s, want newest first", entries[0].Event.Event, entries[1].Event.Event)
+	}
+	if e := entries[0]; e.IP != "192.0.2.1" || e.UserAgent != "test-agent" || e.RequestID != "req-1" {
+		t.Errorf("UserEvents()[0] = %+v", e)
+	}
+
+	// nobody caused the login events, so their actor is NULL rather than 0
+	var actors int
+	if err := db.QueryRow("SELECT COUNT(*) FROM audit_log WHERE actor_id IS NULL;").Scan(&actors); err != nil {
+		t.Fatal(err)
+	}
+	if actors != 2 {
+		t.Errorf("%d events without an actor, want 2", actors)
+	}
+
+	if entries, err := l.UserEvents(1, 1); err != nil || len(entries) != 1 {
+		t.Errorf("UserEvents() with limit 1 = %d entries, %v", len(entries), err)
+	}
+}
+
+func TestRecordRequestID(t *testing.T) {
+	l, _ := newTestLog(t)
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if err := l.Record(context.Background(), r, Event{UserID: 1, Event: RateLimited}); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := l.UserEvents(1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].RequestID == "" {
+		t.Errorf("event recorded without a request id: %+v", entries)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	l, db := newTestLog(t)
+	old := time.Now().UTC().Add(-48 * time.Hour).Format(time.DateTime)
+	query := `INSERT INTO audit_log (user_id, event, ip, user_agent, request_id, created_at)
+		VALUES (1, ?, '', '', '', ?);`
+	if _, err := db.Exec(query, LoginSuccess, old); err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if err := l.Record(context.Background(), r, Event{UserID: 1, Event: Logout}); err != nil {
+		t.Fatal(err)
+	}
+
+	pruned, err := l.Prune(24 * time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pruned != 1 {
+		t.Errorf("Prune() = %d, want 1", pruned)
+	}
+	entries, err := l.UserEvents(1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Event.Event != Logout {
+		t.Errorf("UserEvents() after prune = %+v, want the recent logout", entries)
+	}
+}
+
+// TestStartPruner checks the pruner runs straight away and that the wait
+// group is done once its context is cancelled, shutdown waits on it
+func TestStartPruner(t *testing.T) {
+	l, db := newTestLog(t)
+	old := time.Now().UTC().Add(-48 * time.Hour).Format(time.DateTime)
+	query := `INSERT INTO audit_log (user_id, event, ip, user_agent, request_id, created_at)
+		VALUES (1, ?, '', '', '', ?);`
+	if _, err := db.Exec(query, LoginSuccess, old); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var wg sync.WaitGroup
+	l.StartPruner(ctx, &wg, time.Hour, 24*time.Hour)
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("pruner didn't stop after its context was cancelled")
+	}
+
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM audit_log;").Scan(&n); err != nil || n != 0 {
+		t.Errorf("%d events left after the first prune, %v", n, err)
+	}
+}
@@ -0,0 +1,125 @@
+package avatar
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/somethingsoftware/violet-web/http/storage"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// MaxUploadBytes is the largest file accepted, checked before decoding
+const MaxUploadBytes = 5 << 20
+
+// maxDimension stops tiny files that decode into huge images
+const maxDimension = 4096
+
+// Sizes are the square thumbnails made from every upload, the last one is the
+// one stored on the user
+var Sizes = []int{64, 256}
+
+const urlPrefix = "/avatars/"
+
+var ErrUnsupportedType = errors.New("avatar must be a jpeg, png, gif or webp image")
+var ErrTooLarge = fmt.Errorf("avatar must be at most %d by %d pixels", maxDimension, maxDimension)
+
+var nameRe = regexp.MustCompile(`^[0-9]+-[0-9a-f]{16}-[0-9]+\.png$`)
+
+var allowedTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// Key returns the storage key for the file name in an avatar url, or false if
+// the name could not have come from Save
+func Key(name string) (string, bool) {
+	if !nameRe.MatchString(name) {
+		return "", false
+	}
+	return "avatars/" + name, true
+}
+
+// Save decodes the upload, crops it square, and stores a freshly encoded png
+// for each size so no metadata from the original survives. It returns the url
+// of the largest size.
+func Save(ctx context.Context, store storage.Store, userID uint64, upload []byte) (string, error) {
+	if !allowedTypes[http.DetectContentType(upload)] {
+		return "", ErrUnsupportedType
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(upload))
+	if err != nil {
+		return "", ErrUnsupportedType
+	}
+	if cfg.Width > maxDimension || cfg.Height > maxDimension {
+		return "", ErrTooLarge
+	}
+	src, _, err := image.Decode(bytes.NewReader(upload))
+	if err != nil {
+		return "", ErrUnsupportedType
+	}
+	src = cropSquare(src)
+
+	// the hash is part of the url so it can be cached forever
+	sum := sha256.Sum256(upload)
+	stem := strconv.FormatUint(userID, 10) + "-" + hex.EncodeToString(sum[:8])
+
+	var url string
+	for _, size := range Sizes {
+		dst := image.NewRGBA(image.Rect(0, 0, size, size))
+		draw.CatmullRom.Scale(dst, dst.Bounds(), src, src.Bounds(), draw.Src, nil)
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, dst); err != nil {
+			return "", fmt.Errorf("failed to encode avatar: %w", err)
+		}
+		name := fmt.Sprintf("%s-%d.png", stem, size)
+		if err := store.Put(ctx, "avatars/"+name, buf.Bytes()); err != nil {
+			return "", err
+		}
+		url = urlPrefix + name
+	}
+	return url, nil
+}
+
+// Remove deletes every size of an avatar saved by Save, given its url
+func Remove(ctx context.Context, store storage.Store, url string) error {
+	name, ok := strings.CutPrefix(url, urlPrefix)
+	if !ok || !nameRe.MatchString(name) {
+		return nil
+	}
+	stem := name[:strings.LastIndex(name, "-")]
+	for _, size := range Sizes {
+		if err := store.Delete(ctx, fmt.Sprintf("avatars/%s-%d.png", stem, size)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func cropSquare(img image.Image) image.Image {
+	b := img.Bounds()
+	side := min(b.Dx(), b.Dy())
+	x := b.Min.X + (b.Dx()-side)/2
+	y := b.Min.Y + (b.Dy()-side)/2
+	square := image.Rect(x, y, x+side, y+side)
+	if sub, ok := img.(interface {
+		SubImage(image.Rectangle) image.Image
+	}); ok {
+		return sub.SubImage(square)
+	}
+	return img
+}
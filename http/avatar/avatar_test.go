@@ -0,0 +1,137 @@
+package avatar
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/somethingsoftware/violet-web/http/storage"
+)
+
+// memStore is a storage.Store in a map
+type memStore map[string][]byte
+
+func (m memStore) Put(ctx context.Context, key string, data []byte) error {
+	m[key] = data
+	return nil
+}
+
+func (m memStore) Get(ctx context.Context, key string) ([]byte, time.Time, error) {
+	data, ok := m[key]
+	if !ok {
+		return nil, time.Time{}, storage.ErrNotFound
+	}
+	return data, time.Time{}, nil
+}
+
+func (m memStore) Delete(ctx context.Context, key string) error {
+	delete(m, key)
+	return nil
+}
+
+func encodePNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := range width {
+		img.Set(x, 0, color.RGBA{R: 255, A: 255})
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestSave(t *testing.T) {
+	store := memStore{}
+	url, err := Save(context.Background(), store, 7, encodePNG(t, 300, 100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, ok := strings.CutPrefix(url, urlPrefix)
+	if !ok || !strings.HasPrefix(name, "7-") || !strings.HasSuffix(name, "-256.png") {
+		t.Fatalf("Save() = %s", url)
+	}
+	if len(store) != len(Sizes) {
+		t.Errorf("Save() stored %d files, want %d", len(store), len(Sizes))
+	}
+	key, ok := Key(name)
+	if !ok {
+		t.Fatalf("Key(%s) isn't valid", name)
+	}
+	img, err := png.Decode(bytes.NewReader(store[key]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != 256 || b.Dy() != 256 {
+		t.Errorf("stored avatar is %dx%d, want 256x256", b.Dx(), b.Dy())
+	}
+
+	if err := Remove(context.Background(), store, url); err != nil {
+		t.Fatal(err)
+	}
+	if len(store) != 0 {
+		t.Errorf("Remove() left %d files", len(store))
+	}
+}
+
+func TestSaveRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		upload []byte
+		want   error
+	}{
+		{name: "text", upload: []byte("hello, not an image"), want: ErrUnsupportedType},
+		{name: "svg", upload: []byte(`<svg xmlns="http://www.w3.org/2000/svg"><script>alert(1)</script></svg>`), want: ErrUnsupportedType},
+		{name: "truncated png", upload: encodePNG(t, 10, 10)[:40], want: ErrUnsupportedType},
+		{name: "huge dimensions", upload: encodePNG(t, maxDimension+1, 1), want: ErrTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := memStore{}
+			if _, err := Save(context.Background(), store, 1, tt.upload); !errors.Is(err, tt.want) {
+				t.Errorf("Save() error = %v, want %v", err, tt.want)
+			}
+			if len(store) != 0 {
+				t.Errorf("Save() stored %d files", len(store))
+			}
+		})
+	}
+}
+
+func TestKey(t *testing.T) {
+	tests := []struct {
+		name string
+		ok   bool
+	}{
+		{name: "1-0123456789abcdef-64.png", ok: true},
+		{name: "../1-0123456789abcdef-64.png"},
+		{name: "1-0123456789abcdef-64.png/../../x"},
+		{name: "1-0123456789ABCDEF-64.png"},
+		{name: "1-0123456789abcdef-64.svg"},
+		{name: ""},
+	}
+	for _, tt := range tests {
+		if _, ok := Key(tt.name); ok != tt.ok {
+			t.Errorf("Key(%q) = %t, want %t", tt.name, ok, tt.ok)
+		}
+	}
+}
+
+func TestRemoveIgnoresOtherURLs(t *testing.T) {
+	store := memStore{"avatars/other": []byte("x")}
+	for _, url := range []string{"", "https://example.com/a.png", "/avatars/../other"} {
+		if err := Remove(context.Background(), store, url); err != nil {
+			t.Errorf("Remove(%q) error = %v", url, err)
+		}
+	}
+	if len(store) != 1 {
+		t.Error("Remove() deleted a file it didn't save")
+	}
+}
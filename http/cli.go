@@ -0,0 +1,586 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/somethingsoftware/violet-web/http/account"
+	"github.com/somethingsoftware/violet-web/http/action"
+	"github.com/somethingsoftware/violet-web/http/audit"
+	"github.com/somethingsoftware/violet-web/http/config"
+	"github.com/somethingsoftware/violet-web/http/csrf"
+	"github.com/somethingsoftware/violet-web/http/proxy"
+	"github.com/somethingsoftware/violet-web/http/tlscert"
+	"github.com/somethingsoftware/violet-web/migrate"
+)
+
+// command is a subcommand of the binary. Every command takes the config
+// flags on top of its own so it finds the same database the server uses.
+type command struct {
+	name string
+	help string
+	run  func(name string, args []string) error
+}
+
+func commands() []command {
+	return []command{
+		{"serve", "Run the web server, the default without a command", serveCommand},
+		{"migrate up", "Apply every pending migration", migrateUp},
+		{"migrate down", "Revert migrations down to -to", migrateDown},
+		{"migrate status", "List the migrations and whether they are applied", migrateStatus},
+		{"user create", "Create a user, the password is read from stdin", userCreate},
+		{"user disable", "Disable a user, logging them out", userDisable},
+		{"user enable", "Enable a disabled user", userEnable},
+		{"user set-password", "Set a user's password, read from stdin", userSetPassword},
+		{"user grant-role", "Give a user a role", userGrantRole},
+		{"sessions purge", "End every session, or those of -username", sessionsPurge},
+		{"csrf purge", "Delete used and expired CSRF tokens", csrfPurge},
+		{"config check", "Validate the configuration and print the settings", configCheck},
+	}
+}
+
+func main() {
+	bin := filepath.Base(os.Args[0])
+	cmd, args, ok := findCommand(os.Args[1:])
+	if !ok {
+		usage(bin)
+		os.Exit(2)
+	}
+	err := cmd.run(bin+" "+cmd.name, args)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+}
+
+// findCommand picks the command named by the first one or two arguments, no
+// command or a flag first means serve so the old invocation keeps working
+func findCommand(args []string) (command, []string, bool) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return commands()[0], args, true
+	}
+	for _, cmd := range commands() {
+		words := strings.Fields(cmd.name)
+		if len(args) >= len(words) && strings.Join(args[:len(words)], " ") == cmd.name {
+			return cmd, args[len(words):], true
+		}
+	}
+	return command{}, nil, false
+}
+
+func usage(bin string) {
+	fmt.Fprintf(os.Stderr, "Usage: %s [command] [flags]\n\nCommands:\n", bin)
+	for _, cmd := range commands() {
+		fmt.Fprintf(os.Stderr, "  %-20s %s\n", cmd.name, cmd.help)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun %s <command> -h for its flags.\n", bin)
+}
+
+func noArgs(fs *flag.FlagSet) error {
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+	return nil
+}
+
+// openDB opens the configured database, the server falls back to an in
+// memory one when no path is set. SQLite leaves foreign keys unenforced
+// unless every connection turns them on.
+func openDB(cfg *config.Config, logger *slog.Logger) (*sql.DB, error) {
+	if cfg.SQLitePath == "" {
+		cfg.SQLitePath = ":memory:"
+		logger.Warn("SQLite path is empty, using in-memory database")
+	}
+	return sql.Open("sqlite", sqliteDSN(cfg.SQLitePath))
+}
+
+// sqliteDSN adds the foreign keys pragma to the query of the configured
+// path, which can already have one, like file:violet.db?mode=rwc
+func sqliteDSN(path string) string {
+	file, rawQuery, _ := strings.Cut(path, "?")
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		// the driver will complain about it when it opens the database
+		return path
+	}
+	query.Add("_pragma", "foreign_keys(1)")
+	return file + "?" + query.Encode()
+}
+
+// sqliteFile is the file the configured path names, without a file: scheme
+// or query
+func sqliteFile(path string) string {
+	file, _, _ := strings.Cut(path, "?")
+	return strings.TrimPrefix(file, "file:")
+}
+
+// openMigrated is openDB for the commands that work on the data, they refuse
+// to touch a database the server hasn't migrated yet
+func openMigrated(cfg *config.Config, logger *slog.Logger) (*sql.DB, error) {
+	if cfg.SQLitePath == "" {
+		return nil, errors.New("-sqlite is needed")
+	}
+	// opening would create a new empty database for a mistyped path
+	if _, err := os.Stat(sqliteFile(cfg.SQLitePath)); err != nil {
+		return nil, fmt.Errorf("failed to find database: %w", err)
+	}
+	db, err := openDB(cfg, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open SQLite: %w", err)
+	}
+	version, err := migrate.Version(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	latest, err := migrate.Latest()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	if version != latest {
+		db.Close()
+		return nil, fmt.Errorf("database is at version %d of %d, run migrate up first", version, latest)
+	}
+	return db, nil
+}
+
+func serveCommand(name string, args []string) error {
+	cfg, fs, err := config.Load(name, args, nil)
+	if err != nil {
+		return err
+	}
+	if err := noArgs(fs); err != nil {
+		return err
+	}
+	serve(cfg)
+	return nil
+}
+
+func migrateUp(name string, args []string) error {
+	var dryRun bool
+	cfg, fs, err := config.Load(name, args, func(fs *flag.FlagSet) {
+		fs.BoolVar(&dryRun, "dry-run", false, "Apply the migrations in a transaction that is rolled back")
+	})
+	if err != nil {
+		return err
+	}
+	if err := noArgs(fs); err != nil {
+		return err
+	}
+	logger := newLogger(cfg)
+	if cfg.SQLitePath == "" {
+		return errors.New("-sqlite is needed")
+	}
+	db, err := openDB(cfg, logger)
+	if err != nil {
+		return fmt.Errorf("failed to open SQLite: %w", err)
+	}
+	defer db.Close()
+	applied, err := migrate.Up(db, logger, dryRun)
+	if err != nil {
+		return err
+	}
+	printSteps(applied, dryRun, "Applied")
+	return nil
+}
+
+func printSteps(steps []migrate.Step, dryRun bool, verb string) {
+	if len(steps) == 0 {
+		fmt.Println("Nothing to migrate")
+		return
+	}
+	if dryRun {
+		verb = "Would have " + strings.ToLower(verb[:1]) + verb[1:]
+	}
+	for _, step := range steps {
+		fmt.Printf("%s %d %s\n", verb, step.Version, step.Name)
+	}
+}
+
+func migrateDown(name string, args []string) error {
+	var target int
+	var dryRun bool
+	cfg, fs, err := config.Load(name, args, func(fs *flag.FlagSet) {
+		fs.IntVar(&target, "to", -1, "Version to migrate down to, required")
+		fs.BoolVar(&dryRun, "dry-run", false, "Revert the migrations in a transaction that is rolled back")
+	})
+	if err != nil {
+		return err
+	}
+	if err := noArgs(fs); err != nil {
+		return err
+	}
+	if target < 0 {
+		return errors.New("-to is needed")
+	}
+	logger := newLogger(cfg)
+	if cfg.SQLitePath == "" {
+		return errors.New("-sqlite is needed")
+	}
+	db, err := openDB(cfg, logger)
+	if err != nil {
+		return fmt.Errorf("failed to open SQLite: %w", err)
+	}
+	defer db.Close()
+	reverted, err := migrate.Down(db, logger, target, dryRun)
+	if err != nil {
+		return err
+	}
+	printSteps(reverted, dryRun, "Reverted")
+	return nil
+}
+
+func migrateStatus(name string, args []string) error {
+	cfg, fs, err := config.Load(name, args, nil)
+	if err != nil {
+		return err
+	}
+	if err := noArgs(fs); err != nil {
+		return err
+	}
+	if cfg.SQLitePath == "" {
+		return errors.New("-sqlite is needed")
+	}
+	db, err := openDB(cfg, newLogger(cfg))
+	if err != nil {
+		return fmt.Errorf("failed to open SQLite: %w", err)
+	}
+	defer db.Close()
+	steps, err := migrate.Status(db)
+	if err != nil {
+		return err
+	}
+	for _, step := range steps {
+		state, when := "pending", ""
+		if step.Applied {
+			state, when = "applied", step.AppliedAt.Format(time.DateTime)
+		}
+		if step.Changed {
+			state = "changed"
+		}
+		fmt.Printf("%3d  %-8s %-19s  %s\n", step.Version, state, when, step.Name)
+	}
+	return nil
+}
+
+// readPassword reads the password from the first line of stdin so it never
+// ends up in the shell history or the process list
+func readPassword() (string, error) {
+	fmt.Fprint(os.Stderr, "Password: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("failed to read password: %w", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// userCommand loads the config with a -username flag and whatever else the
+// command needs, then opens the database and finds the user
+func userCommand(name string, args []string, extra func(fs *flag.FlagSet)) (*sql.DB, *slog.Logger, uint64, error) {
+	var username string
+	cfg, fs, err := config.Load(name, args, func(fs *flag.FlagSet) {
+		fs.StringVar(&username, "username", "", "Username of the user, required")
+		if extra != nil {
+			extra(fs)
+		}
+	})
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	if err := noArgs(fs); err != nil {
+		return nil, nil, 0, err
+	}
+	if username == "" {
+		return nil, nil, 0, errors.New("-username is needed")
+	}
+	logger := newLogger(cfg)
+	db, err := openMigrated(cfg, logger)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	userID, err := account.Lookup(db, username)
+	if err != nil {
+		db.Close()
+		return nil, nil, 0, err
+	}
+	return db, logger, userID, nil
+}
+
+func recordCLI(db *sql.DB, logger *slog.Logger, userID uint64, event, detail string) {
+	e := audit.Event{UserID: userID, Event: event, Detail: detail}
+	if err := audit.NewLog(db, logger).Record(context.Background(), nil, e); err != nil {
+		logger.Error("Failed to record audit event", "error", err)
+	}
+}
+
+func userCreate(name string, args []string) error {
+	var username, email, role string
+	cfg, fs, err := config.Load(name, args, func(fs *flag.FlagSet) {
+		fs.StringVar(&username, "username", "", "Username of the new user, required")
+		fs.StringVar(&email, "email", "", "Email address of the new user, required")
+		fs.StringVar(&role, "role", account.Roles[0], "Role of the new user, one of "+strings.Join(account.Roles, ", "))
+	})
+	if err != nil {
+		return err
+	}
+	if err := noArgs(fs); err != nil {
+		return err
+	}
+	if username == "" || email == "" {
+		return errors.New("-username and -email are needed")
+	}
+	// the same rules as registering, a name the form wouldn't take can break
+	// the pages that link to it
+	if err := action.CheckUsername(username); err != nil {
+		return err
+	}
+	if _, err := mail.ParseAddress(email); err != nil {
+		return fmt.Errorf("invalid email address: %w", err)
+	}
+	if !account.ValidRole(role) {
+		return fmt.Errorf("unknown role %q", role)
+	}
+	logger := newLogger(cfg)
+	db, err := openMigrated(cfg, logger)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	password, err := readPassword()
+	if err != nil {
+		return err
+	}
+	hash, salt, err := action.CheckAndHashPassword(password, password)
+	if err != nil {
+		return err
+	}
+	userID, err := account.Create(db, username, email, salt, hash)
+	if err != nil {
+		return err
+	}
+	if err := account.SetRole(db, userID, role); err != nil {
+		return err
+	}
+	recordCLI(db, logger, userID, audit.CLIUserCreate, role)
+	fmt.Printf("Created user %s with id %d\n", username, userID)
+	return nil
+}
+
+func userDisable(name string, args []string) error {
+	db, logger, userID, err := userCommand(name, args, nil)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	// sessions check the flag on every request so this logs them out too
+	if err := account.SetDisabled(db, userID, true); err != nil {
+		return err
+	}
+	recordCLI(db, logger, userID, audit.CLIUserDisable, "")
+	fmt.Println("Disabled user", userID)
+	return nil
+}
+
+func userEnable(name string, args []string) error {
+	db, logger, userID, err := userCommand(name, args, nil)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if err := account.SetDisabled(db, userID, false); err != nil {
+		return err
+	}
+	recordCLI(db, logger, userID, audit.CLIUserEnable, "")
+	fmt.Println("Enabled user", userID)
+	return nil
+}
+
+func userSetPassword(name string, args []string) error {
+	db, logger, userID, err := userCommand(name, args, nil)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	password, err := readPassword()
+	if err != nil {
+		return err
+	}
+	hash, salt, err := action.CheckAndHashPassword(password, password)
+	if err != nil {
+		return err
+	}
+	if err := account.SetPassword(db, userID, salt, hash); err != nil {
+		return err
+	}
+	recordCLI(db, logger, userID, audit.CLISetPassword, "")
+	fmt.Println("Set the password of user", userID)
+	return nil
+}
+
+func userGrantRole(name string, args []string) error {
+	var role string
+	db, logger, userID, err := userCommand(name, args, func(fs *flag.FlagSet) {
+		fs.StringVar(&role, "role", "", "Role to give, one of "+strings.Join(account.Roles, ", "))
+	})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if err := account.SetRole(db, userID, role); err != nil {
+		return err
+	}
+	recordCLI(db, logger, userID, audit.CLIGrantRole, role)
+	fmt.Printf("User %d is now %s\n", userID, role)
+	return nil
+}
+
+// sessionsPurge ends sessions in the running server, they only live in its
+// memory so it records the time instead and the server ends every session
+// that started before it
+func sessionsPurge(name string, args []string) error {
+	var username string
+	cfg, fs, err := config.Load(name, args, func(fs *flag.FlagSet) {
+		fs.StringVar(&username, "username", "", "Only end the sessions of this user")
+	})
+	if err != nil {
+		return err
+	}
+	if err := noArgs(fs); err != nil {
+		return err
+	}
+	logger := newLogger(cfg)
+	db, err := openMigrated(cfg, logger)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if username != "" {
+		userID, err := account.Lookup(db, username)
+		if err != nil {
+			return err
+		}
+		if err := account.RevokeSessions(db, userID); err != nil {
+			return err
+		}
+		recordCLI(db, logger, userID, audit.CLISessionsPurge, "")
+		fmt.Println("Ended the sessions of user", userID)
+		return nil
+	}
+	users, err := account.RevokeAllSessions(db)
+	if err != nil {
+		return err
+	}
+	recordCLI(db, logger, 0, audit.CLISessionsPurge, "all")
+	fmt.Printf("Ended the sessions of %d users\n", users)
+	return nil
+}
+
+func csrfPurge(name string, args []string) error {
+	cfg, fs, err := config.Load(name, args, nil)
+	if err != nil {
+		return err
+	}
+	if err := noArgs(fs); err != nil {
+		return err
+	}
+	db, err := openMigrated(cfg, newLogger(cfg))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	purged, err := csrf.Purge(db)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Purged %d CSRF tokens\n", purged)
+	return nil
+}
+
+// configCheck goes further than the validation every command gets, it also
+// loads what the settings point at
+func configCheck(name string, args []string) error {
+	cfg, fs, err := config.Load(name, args, nil)
+	if err != nil {
+		return err
+	}
+	if err := noArgs(fs); err != nil {
+		return err
+	}
+	for _, warning := range cfg.ServeWarnings() {
+		fmt.Println("Warning:", warning)
+	}
+	logger := newLogger(cfg)
+	if _, err := proxy.NewResolver(cfg.TrustedProxies); err != nil {
+		return err
+	}
+	if _, err := migrate.Latest(); err != nil {
+		return err
+	}
+	if cfg.UseTLS() {
+		if _, err := tlscert.NewReloader(cfg.TLSCert, cfg.TLSKey, logger); err != nil {
+			return err
+		}
+	}
+	if cfg.AvatarDir != "" {
+		if info, err := os.Stat(cfg.AvatarDir); err != nil {
+			return fmt.Errorf("avatar-dir: %w", err)
+		} else if !info.IsDir() {
+			return fmt.Errorf("avatar-dir %s is not a directory", cfg.AvatarDir)
+		}
+	}
+	if _, err := os.Stat(sqliteFile(cfg.SQLitePath)); cfg.SQLitePath != "" && errors.Is(err, os.ErrNotExist) {
+		fmt.Println("Database doesn't exist yet, serve will create it")
+	} else if cfg.SQLitePath != "" {
+		db, err := openDB(cfg, logger)
+		if err != nil {
+			return fmt.Errorf("failed to open SQLite: %w", err)
+		}
+		defer db.Close()
+		version, err := migrate.Version(db)
+		if err != nil {
+			return err
+		}
+		latest, err := migrate.Latest()
+		if err != nil {
+			return err
+		}
+		if version != latest {
+			fmt.Printf("Database is at version %d of %d, serve will migrate it\n", version, latest)
+		}
+		problems, err := migrate.Check(db)
+		if err != nil {
+			return err
+		}
+		for _, problem := range problems {
+			fmt.Println("Database problem:", problem)
+		}
+		if len(problems) > 0 {
+			return fmt.Errorf("database has %d problems", len(problems))
+		}
+	}
+
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Name != "config" {
+			fmt.Printf("%-20s %s\n", f.Name, strconv.Quote(f.Value.String()))
+		}
+	})
+	fmt.Println("Config OK")
+	return nil
+}
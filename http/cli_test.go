@@ -0,0 +1,35 @@
+package main
+
+import "testing"
+
+func TestSQLiteDSN(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "violet.db", want: "violet.db?_pragma=foreign_keys%281%29"},
+		{path: ":memory:", want: ":memory:?_pragma=foreign_keys%281%29"},
+		{path: "file:violet.db?mode=rwc", want: "file:violet.db?_pragma=foreign_keys%281%29&mode=rwc"},
+		{path: "violet.db?_pragma=busy_timeout(5000)", want: "violet.db?_pragma=busy_timeout%285000%29&_pragma=foreign_keys%281%29"},
+	}
+	for _, tt := range tests {
+		if got := sqliteDSN(tt.path); got != tt.want {
+			t.Errorf("sqliteDSN(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestSQLiteFile(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "violet.db", want: "violet.db"},
+		{path: "file:/var/lib/violet.db?mode=rwc", want: "/var/lib/violet.db"},
+	}
+	for _, tt := range tests {
+		if got := sqliteFile(tt.path); got != tt.want {
+			t.Errorf("sqliteFile(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
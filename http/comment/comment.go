@@ -0,0 +1,150 @@
+package comment
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+	"unicode/utf8"
+)
+
+// CREATE TABLE comment (
+// id INTEGER PRIMARY KEY AUTOINCREMENT,
+// post_id INTEGER NOT NULL,
+// user_id INTEGER,
+// parent_id INTEGER,
+// body TEXT NOT NULL,
+// deleted BOOLEAN NOT NULL DEFAULT FALSE,
+// created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+// FOREIGN KEY (post_id) REFERENCES post(id) ON DELETE CASCADE,
+// FOREIGN KEY (user_id) REFERENCES user(id) ON DELETE SET NULL,
+// FOREIGN KEY (parent_id) REFERENCES comment(id) ON DELETE CASCADE);
+
+const BodyLenMax = 5000
+
+var ErrNotFound = errors.New("comment not found")
+var ErrBodyLength = fmt.Errorf("comment must be between 1 and %d characters", BodyLenMax)
+var ErrBadParent = errors.New("replies must be to a comment on the same post")
+
+// Comment is a node in a post's comment tree. Deleted comments keep their
+// place so replies to them still make sense, but lose their body and author.
+type Comment struct {
+	ID        uint64
+	PostID    uint64
+	UserID    uint64
+	Username  string
+	ParentID  uint64
+	Body      string
+	Deleted   bool
+	CreatedAt time.Time
+	Replies   []*Comment
+
+	// CanDelete is filled in by the page for the user viewing it
+	CanDelete bool
+}
+
+func Validate(body string) error {
+	if n := utf8.RuneCountInString(body); n < 1 || n > BodyLenMax {
+		return ErrBodyLength
+	}
+	return nil
+}
+
+const selectComment = `SELECT comment.id, comment.post_id, IFNULL(comment.user_id, 0),
+	IFNULL(user.username, ''), IFNULL(comment.parent_id, 0), comment.body, comment.deleted,
+	comment.created_at FROM comment LEFT JOIN user ON user.id = comment.user_id `
+
+func scanComment(row interface{ Scan(...any) error }) (*Comment, error) {
+	c := &Comment{}
+	err := row.Scan(&c.ID, &c.PostID, &c.UserID, &c.Username, &c.ParentID, &c.Body, &c.Deleted, &c.CreatedAt)
+	return c, err
+}
+
+func Get(db *sql.DB, id uint64) (*Comment, error) {
+	c, err := scanComment(db.QueryRow(selectComment+"WHERE comment.id = ?;", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get comment %d: %w", id, err)
+	}
+	return c, nil
+}
+
+// ForPost returns the top level comments of a post, oldest first, with their
+// replies nested under them
+func ForPost(db *sql.DB, postID uint64) ([]*Comment, error) {
+	rows, err := db.Query(selectComment+"WHERE comment.post_id = ? ORDER BY comment.id;", postID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query comments: %w", err)
+	}
+	defer rows.Close()
+
+	var all []*Comment
+	byID := make(map[uint64]*Comment)
+	for rows.Next() {
+		c, err := scanComment(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan comment: %w", err)
+		}
+		all = append(all, c)
+		byID[c.ID] = c
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate comments: %w", err)
+	}
+
+	// parents always have lower ids than their replies so one pass is enough
+	var roots []*Comment
+	for _, c := range all {
+		if parent, ok := byID[c.ParentID]; ok {
+			parent.Replies = append(parent.Replies, c)
+		} else {
+			roots = append(roots, c)
+		}
+	}
+	return roots, nil
+}
+
+// Walk calls fn on every comment in the trees
+func Walk(comments []*Comment, fn func(*Comment)) {
+	for _, c := range comments {
+		fn(c)
+		Walk(c.Replies, fn)
+	}
+}
+
+// Create adds a comment to a post, parentID is 0 for a top level comment
+func Create(db *sql.DB, postID, userID, parentID uint64, body string) (uint64, error) {
+	if parentID != 0 {
+		parent, err := Get(db, parentID)
+		if errors.Is(err, ErrNotFound) {
+			return 0, ErrBadParent
+		} else if err != nil {
+			return 0, err
+		}
+		if parent.PostID != postID {
+			return 0, ErrBadParent
+		}
+	}
+
+	parent := sql.NullInt64{Int64: int64(parentID), Valid: parentID != 0}
+	query := "INSERT INTO comment (post_id, user_id, parent_id, body) VALUES (?, ?, ?, ?);"
+	res, err := db.Exec(query, postID, userID, parent, body)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert comment: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get comment id: %w", err)
+	}
+	return uint64(id), nil
+}
+
+// Delete blanks out a comment, leaving it in place for its replies
+func Delete(db *sql.DB, id uint64) error {
+	query := "UPDATE comment SET deleted = TRUE, body = '', user_id = NULL WHERE id = ?;"
+	if _, err := db.Exec(query, id); err != nil {
+		return fmt.Errorf("failed to delete comment %d: %w", id, err)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package comment
+
+import (
+	"database/sql"
+	"errors"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	_ "github.com/glebarez/go-sqlite"
+	"github.com/somethingsoftware/violet-web/migrate"
+)
+
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "test.db")+"?_pragma=foreign_keys(1)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := migrate.AutoUP(db, slog.New(slog.NewTextHandler(io.Discard, nil))); err != nil {
+		t.Fatal(err)
+	}
+	queries := []string{
+		"INSERT INTO user (id, username, email, salt, password_hash) VALUES (1, 'alice', 'alice@example.com', '', '');",
+		"INSERT INTO user (id, username, email, salt, password_hash) VALUES (2, 'bob', 'bob@example.com', '', '');",
+		"INSERT INTO post (id, user_id, title, body) VALUES (1, 1, 'first', 'body');",
+		"INSERT INTO post (id, user_id, title, body) VALUES (2, 1, 'second', 'body');",
+	}
+	for _, query := range queries {
+		if _, err := db.Exec(query); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+func create(t *testing.T, db *sql.DB, postID, userID, parentID uint64, body string) uint64 {
+	t.Helper()
+	id, err := Create(db, postID, userID, parentID, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestValidate(t *testing.T) {
+	for _, body := range []string{"", strings.Repeat("a", BodyLenMax+1)} {
+		if err := Validate(body); !errors.Is(err, ErrBodyLength) {
+			t.Errorf("Validate(%d characters) = %v, want ErrBodyLength", len(body), err)
+		}
+	}
+	if err := Validate(strings.Repeat("ü", BodyLenMax)); err != nil {
+		t.Errorf("Validate() of the longest body = %v", err)
+	}
+}
+
+func TestForPost(t *testing.T) {
+	db := openTestDB(t)
+	first := create(t, db, 1, 1, 0, "first")
+	reply := create(t, db, 1, 2, first, "reply")
+	create(t, db, 1, 1, reply, "reply to the reply")
+	create(t, db, 1, 2, 0, "second")
+	create(t, db, 2, 2, 0, "on another post")
+
+	roots, err := ForPost(db, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(roots) != 2 || roots[0].Body != "first" || roots[1].Body != "second" {
+		t.Fatalf("ForPost() roots = %+v", roots)
+	}
+	if len(roots[0].Replies) != 1 || roots[0].Replies[0].Username != "bob" ||
+		len(roots[0].Replies[0].Replies) != 1 || roots[0].Replies[0].Replies[0].Body != "reply to the reply" {
+		t.Errorf("ForPost() didn't nest the replies: %+v", roots[0])
+	}
+
+	var walked int
+	Walk(roots, func(*Comment) { walked++ })
+	if walked != 4 {
+		t.Errorf("Walk() visited %d comments, want 4", walked)
+	}
+}
+
+func TestCreateBadParent(t *testing.T) {
+	db := openTestDB(t)
+	other := create(t, db, 2, 1, 0, "on another post")
+	for _, parentID := range []uint64{other, 999} {
+		if _, err := Create(db, 1, 1, parentID, "reply"); !errors.Is(err, ErrBadParent) {
+			t.Errorf("Create() with parent %d error = %v, want ErrBadParent", parentID, err)
+		}
+	}
+}
+
+func TestDelete(t *testing.T) {
+	db := openTestDB(t)
+	parent := create(t, db, 1, 1, 0, "parent")
+	create(t, db, 1, 2, parent, "reply")
+	if err := Delete(db, parent); err != nil {
+		t.Fatal(err)
+	}
+
+	roots, err := ForPost(db, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(roots) != 1 {
+		t.Fatalf("ForPost() after Delete() = %d roots, want the deleted comment kept", len(roots))
+	}
+	c := roots[0]
+	if !c.Deleted || c.Body != "" || c.UserID != 0 || c.Username != "" {
+		t.Errorf("deleted comment = %+v, want it blanked", c)
+	}
+	if len(c.Replies) != 1 || c.Replies[0].Body != "reply" {
+		t.Errorf("the reply to the deleted comment = %+v", c.Replies)
+	}
+}
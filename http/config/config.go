@@ -0,0 +1,271 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// EnvPrefix is put in front of a setting's flag name, upper cased with dashes
+// turned into underscores, to get its environment variable. -audit-retention
+// is VIOLET_AUDIT_RETENTION.
+const EnvPrefix = "VIOLET_"
+
+// Config is every setting the server has. Settings come from, lowest
+// precedence first, the defaults, a JSON config file, environment variables
+// and the command line flags. The file is an object keyed by flag name with
+// durations written as strings, {"port": 8443, "audit-retention": "720h"}.
+type Config struct {
+	SQLitePath     string
+	DevMode        bool
+	LogSource      bool
+	HTTPPort       int
+	PublicURL      string
+	AuditRetention time.Duration
+	DeletionGrace  time.Duration
+	AvatarDir      string
+
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	MaxHeaderBytes    int
+	ShutdownTimeout   time.Duration
+
+	TLSCert        string
+	TLSKey         string
+	TLSReload      time.Duration
+	RedirectPort   int
+	HSTSMaxAge     time.Duration
+	TrustedProxies []string
+}
+
+// UseTLS reports whether the server terminates TLS itself
+func (c *Config) UseTLS() bool {
+	return c.TLSCert != "" && c.TLSKey != ""
+}
+
+func (c *Config) flagSet(name string, configPath *string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(configPath, "config", "", "Path to a JSON config file")
+	fs.StringVar(&c.SQLitePath, "sqlite", "", "Path to the SQLite database")
+	fs.BoolVar(&c.DevMode, "dev", false, "Enable development mode")
+	fs.BoolVar(&c.LogSource, "source", false, "Enable source logging")
+	fs.IntVar(&c.HTTPPort, "port", 8080, "Port to listen on")
+	fs.StringVar(&c.PublicURL, "public-url", "", "Scheme and host the site is reached at, like https://violet.example, for links in feeds and emails")
+	fs.DurationVar(&c.AuditRetention, "audit-retention", 90*24*time.Hour, "How long to keep audit log events")
+	fs.StringVar(&c.AvatarDir, "avatar-dir", "", "Directory to store uploaded avatars in, stored in SQLite if empty")
+	fs.DurationVar(&c.DeletionGrace, "deletion-grace", 7*24*time.Hour, "How long deleted accounts are kept before being purged, 0 deletes immediately")
+	fs.DurationVar(&c.ReadTimeout, "read-timeout", 15*time.Second, "Maximum duration for reading an entire request, body included")
+	fs.DurationVar(&c.ReadHeaderTimeout, "read-header-timeout", 5*time.Second, "Maximum duration for reading request headers")
+	fs.DurationVar(&c.WriteTimeout, "write-timeout", 30*time.Second, "Maximum duration before timing out writes of the response")
+	fs.DurationVar(&c.IdleTimeout, "idle-timeout", 2*time.Minute, "How long keep-alive connections wait for the next request")
+	fs.IntVar(&c.MaxHeaderBytes, "max-header-bytes", http.DefaultMaxHeaderBytes, "Maximum size of request headers in bytes")
+	fs.DurationVar(&c.ShutdownTimeout, "shutdown-timeout", 30*time.Second, "How long to wait for in-flight requests when shutting down")
+	fs.StringVar(&c.TLSCert, "tls-cert", "", "Path to the TLS certificate, serves HTTPS on -port when set with -tls-key")
+	fs.StringVar(&c.TLSKey, "tls-key", "", "Path to the TLS private key")
+	fs.DurationVar(&c.TLSReload, "tls-reload", time.Minute, "How often to check the TLS certificate and key for changes")
+	fs.IntVar(&c.RedirectPort, "redirect-port", 0, "Port for a plain HTTP listener that redirects to HTTPS, 0 disables it")
+	fs.DurationVar(&c.HSTSMaxAge, "hsts-max-age", 365*24*time.Hour, "Strict-Transport-Security max-age sent over HTTPS, 0 disables it")
+	fs.Var((*listValue)(&c.TrustedProxies), "trusted-proxies", "Comma separated CIDRs of reverse proxies whose Forwarded and X-Forwarded-* headers are trusted")
+	return fs
+}
+
+// Load builds the config from the flags in args, the environment and the
+// config file named by -config or VIOLET_CONFIG. extra can add flags of its
+// own to the set, which is returned for them and the positional arguments.
+// flag.ErrHelp is returned when the usage was asked for.
+func Load(name string, args []string, extra func(fs *flag.FlagSet)) (*Config, *flag.FlagSet, error) {
+	c := &Config{}
+	var configPath string
+	fs := c.flagSet(name, &configPath)
+	settings := map[string]bool{}
+	fs.VisitAll(func(f *flag.Flag) {
+		settings[f.Name] = true
+	})
+	if extra != nil {
+		extra(fs)
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+	// flags win so remember which were given before the rest overwrite them
+	explicit := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	if configPath == "" {
+		configPath = os.Getenv(EnvPrefix + "CONFIG")
+	}
+	if configPath != "" {
+		if err := loadFile(fs, configPath, settings, explicit); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// only the settings come from the environment, not the extra flags
+	var envErr error
+	fs.VisitAll(func(f *flag.Flag) {
+		env := EnvName(f.Name)
+		value, ok := os.LookupEnv(env)
+		if !ok || !settings[f.Name] || explicit[f.Name] || f.Name == "config" || envErr != nil {
+			return
+		}
+		if err := fs.Set(f.Name, value); err != nil {
+			envErr = fmt.Errorf("invalid %s: %w", env, err)
+		}
+	})
+	if envErr != nil {
+		return nil, nil, envErr
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, nil, err
+	}
+	return c, fs, nil
+}
+
+// EnvName is the environment variable for the flag
+func EnvName(flagName string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+func loadFile(fs *flag.FlagSet, path string, settings, explicit map[string]bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open config file: %w", err)
+	}
+	defer f.Close()
+	var values map[string]any
+	dec := json.NewDecoder(f)
+	dec.UseNumber()
+	if err := dec.Decode(&values); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	for name, value := range values {
+		if !settings[name] || name == "config" {
+			return fmt.Errorf("unknown setting %q in config file %s", name, path)
+		}
+		if explicit[name] {
+			continue
+		}
+		var s string
+		switch v := value.(type) {
+		case string:
+			s = v
+		case json.Number:
+			s = v.String()
+		case bool:
+			s = fmt.Sprint(v)
+		case []any:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			s = strings.Join(items, ",")
+		default:
+			return fmt.Errorf("setting %q in config file %s has an unsupported type", name, path)
+		}
+		if err := fs.Set(name, s); err != nil {
+			return fmt.Errorf("invalid %q in config file %s: %w", name, path, err)
+		}
+	}
+	return nil
+}
+
+// Validate checks the settings make sense together, it's run by Load so
+// mistakes show up at startup rather than on the first request
+func (c *Config) Validate() error {
+	var errs []error
+	checkPort := func(name string, port int, optional bool) {
+		if optional && port == 0 {
+			return
+		}
+		if port < 1 || port > 65535 {
+			errs = append(errs, fmt.Errorf("%s must be between 1 and 65535, got %d", name, port))
+		}
+	}
+	checkPort("port", c.HTTPPort, false)
+	checkPort("redirect-port", c.RedirectPort, true)
+
+	durations := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"audit-retention", c.AuditRetention},
+		{"deletion-grace", c.DeletionGrace},
+		{"read-timeout", c.ReadTimeout},
+		{"read-header-timeout", c.ReadHeaderTimeout},
+		{"write-timeout", c.WriteTimeout},
+		{"idle-timeout", c.IdleTimeout},
+		{"shutdown-timeout", c.ShutdownTimeout},
+		{"hsts-max-age", c.HSTSMaxAge},
+	}
+	for _, d := range durations {
+		if d.value < 0 {
+			errs = append(errs, fmt.Errorf("%s must not be negative", d.name))
+		}
+	}
+	if c.AuditRetention == 0 {
+		errs = append(errs, errors.New("audit-retention must be more than 0"))
+	}
+	if c.TLSReload <= 0 {
+		errs = append(errs, errors.New("tls-reload must be more than 0"))
+	}
+	if c.MaxHeaderBytes < 1 {
+		errs = append(errs, errors.New("max-header-bytes must be more than 0"))
+	}
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		errs = append(errs, errors.New("tls-cert and tls-key must be set together"))
+	}
+	if c.RedirectPort != 0 && !c.UseTLS() {
+		errs = append(errs, errors.New("redirect-port needs tls-cert and tls-key"))
+	}
+	if c.RedirectPort != 0 && c.RedirectPort == c.HTTPPort {
+		errs = append(errs, errors.New("redirect-port must differ from port"))
+	}
+	if c.PublicURL != "" {
+		u, err := url.Parse(c.PublicURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+			(u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+			errs = append(errs, fmt.Errorf("public-url must be a scheme and host like https://violet.example, got %q", c.PublicURL))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ServeWarnings are the settings the server starts with but shouldn't run
+// in production with
+func (c *Config) ServeWarnings() []string {
+	var warnings []string
+	// without it links in emails and feeds come from the client's Host header
+	if c.PublicURL == "" && !c.DevMode {
+		warnings = append(warnings, "public-url is not set, links in feeds and emails use the Host header of the request")
+	}
+	return warnings
+}
+
+// listValue is a comma separated flag
+type listValue []string
+
+func (l *listValue) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listValue) Set(s string) error {
+	*l = nil
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
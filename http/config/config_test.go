@@ -0,0 +1,174 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// clearEnv unsets the VIOLET_ variables of whoever runs the tests for the
+// rest of the test
+func clearEnv(t *testing.T) {
+	t.Helper()
+	for _, kv := range os.Environ() {
+		if key, _, _ := strings.Cut(kv, "="); strings.HasPrefix(key, EnvPrefix) {
+			t.Setenv(key, "")
+			os.Unsetenv(key)
+		}
+	}
+}
+
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	file := writeFile(t, `{"port": 8001, "sqlite": "file.db", "audit-retention": "48h", "trusted-proxies": ["10.0.0.0/8", "::1"]}`)
+
+	tests := []struct {
+		name string
+		env  map[string]string
+		args []string
+		want func(c *Config) bool
+	}{
+		{
+			name: "defaults",
+			want: func(c *Config) bool {
+				return c.HTTPPort == 8080 && c.SQLitePath == "" && c.AuditRetention == 90*24*time.Hour
+			},
+		},
+		{
+			name: "file over defaults",
+			args: []string{"-config", file},
+			want: func(c *Config) bool {
+				return c.HTTPPort == 8001 && c.SQLitePath == "file.db" && c.AuditRetention == 48*time.Hour &&
+					reflect.DeepEqual(c.TrustedProxies, []string{"10.0.0.0/8", "::1"})
+			},
+		},
+		{
+			name: "file named by the environment",
+			env:  map[string]string{"VIOLET_CONFIG": file},
+			want: func(c *Config) bool { return c.HTTPPort == 8001 },
+		},
+		{
+			name: "env over file",
+			env:  map[string]string{"VIOLET_PORT": "8002", "VIOLET_AUDIT_RETENTION": "24h"},
+			args: []string{"-config", file},
+			want: func(c *Config) bool {
+				return c.HTTPPort == 8002 && c.AuditRetention == 24*time.Hour && c.SQLitePath == "file.db"
+			},
+		},
+		{
+			name: "flags over env and file",
+			env:  map[string]string{"VIOLET_PORT": "8002", "VIOLET_SQLITE": "env.db"},
+			args: []string{"-config", file, "-port", "8003"},
+			want: func(c *Config) bool { return c.HTTPPort == 8003 && c.SQLitePath == "env.db" },
+		},
+		{
+			name: "flag set to its default still wins",
+			env:  map[string]string{"VIOLET_PORT": "8002"},
+			args: []string{"-config", file, "-port", "8080"},
+			want: func(c *Config) bool { return c.HTTPPort == 8080 },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearEnv(t)
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			c, _, err := Load("test", tt.args, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.want(c) {
+				t.Errorf("Load() = %+v", c)
+			}
+		})
+	}
+}
+
+func TestLoadExtraFlags(t *testing.T) {
+	// extra flags only come from the command line
+	clearEnv(t)
+	t.Setenv("VIOLET_DRY_RUN", "true")
+	var dryRun bool
+	_, fs, err := Load("test", []string{"-port", "9000", "up"}, func(fs *flag.FlagSet) {
+		fs.BoolVar(&dryRun, "dry-run", false, "")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dryRun {
+		t.Error("dry-run was set from the environment")
+	}
+	if fs.Arg(0) != "up" {
+		t.Errorf("positional argument = %q, want up", fs.Arg(0))
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		env  map[string]string
+		args []string
+	}{
+		{name: "unknown file setting", file: `{"prot": 8080}`},
+		{name: "config in the file", file: `{"config": "other.json"}`},
+		{name: "bad file value", file: `{"port": "eighty"}`},
+		{name: "bad json", file: `{"port": `},
+		{name: "bad env value", env: map[string]string{"VIOLET_PORT": "eighty"}},
+		{name: "invalid port", args: []string{"-port", "70000"}},
+		{name: "tls cert without key", args: []string{"-tls-cert", "cert.pem"}},
+		{name: "public url with a path", args: []string{"-public-url", "https://violet.example/blog"}},
+		{name: "public url without a scheme", args: []string{"-public-url", "violet.example"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearEnv(t)
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			args := tt.args
+			if tt.file != "" {
+				args = append([]string{"-config", writeFile(t, tt.file)}, args...)
+			}
+			if _, _, err := Load("test", args, nil); err == nil {
+				t.Error("Load() succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestServeWarnings(t *testing.T) {
+	tests := []struct {
+		cfg      Config
+		warnings int
+	}{
+		{cfg: Config{DevMode: true}},
+		{cfg: Config{PublicURL: "https://violet.example"}},
+		// the server still starts, it only warns
+		{cfg: Config{}, warnings: 1},
+	}
+	for _, tt := range tests {
+		if warnings := tt.cfg.ServeWarnings(); len(warnings) != tt.warnings {
+			t.Errorf("ServeWarnings(%+v) = %q, want %d warnings", tt.cfg, warnings, tt.warnings)
+		}
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	if got := EnvName("audit-retention"); got != "VIOLET_AUDIT_RETENTION" {
+		t.Errorf("EnvName() = %q", got)
+	}
+}
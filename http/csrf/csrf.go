@@ -6,10 +6,13 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
-	"strings"
 	"time"
 
+	"github.com/somethingsoftware/violet-web/http/apperr"
+	"github.com/somethingsoftware/violet-web/http/audit"
 	"github.com/somethingsoftware/violet-web/http/auth"
+	"github.com/somethingsoftware/violet-web/http/proxy"
+	"github.com/somethingsoftware/violet-web/http/render"
 )
 
 // CREATE TABLE csrf (
@@ -21,14 +24,18 @@ import (
 // created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP);
 
 type Provider struct {
-	db     *sql.DB
-	logger *slog.Logger
+	db       *sql.DB
+	rn       *render.Renderer
+	auditLog *audit.Log
+	logger   *slog.Logger
 }
 
-func NewProvider(db *sql.DB, logger *slog.Logger) *Provider {
+func NewProvider(db *sql.DB, rn *render.Renderer, auditLog *audit.Log, logger *slog.Logger) *Provider {
 	return &Provider{
-		db:     db,
-		logger: logger,
+		db:       db,
+		rn:       rn,
+		auditLog: auditLog,
+		logger:   logger,
 	}
 }
 
@@ -45,14 +52,12 @@ func (p Provider) BuildValidator() func(http.HandlerFunc) http.HandlerFunc {
 			var ip string
 			var userAgent string
 			if err := res.Scan(&used, &createdAt, &ip, &userAgent); err != nil {
-				http.Error(w, "Forbidden", http.StatusForbidden)
-				p.logger.Error("Failed to validate csrf token", "error", err)
+				p.reject(w, r, "unknown token", "error", err)
 				return
 			}
 			// check if it's been used
 			if used {
-				http.Error(w, "Forbidden", http.StatusForbidden)
-				p.logger.Error("CSRF token already used", "token", r.FormValue("csrf_token"))
+				p.reject(w, r, "already used", "token", r.FormValue("csrf_token"))
 				return
 			}
 			// check if it's expired
@@ -60,43 +65,31 @@ func (p Provider) BuildValidator() func(http.HandlerFunc) http.HandlerFunc {
 			// return 403
 			createAt, err := time.Parse(time.RFC3339, createdAt)
 			if err != nil {
-				http.Error(w, "Forbidden", http.StatusForbidden)
-				p.logger.Error("Failed to parse created_at", "error", err)
+				p.reject(w, r, "bad created_at", "error", err)
 				return
 			}
 			if time.Since(createAt).Minutes() > maxCSRFTokenAgeMinutes {
 				_, err = p.db.Exec("UPDATE csrf SET used = TRUE WHERE csrf_token = ?;", r.FormValue("csrf_token"))
 				if err != nil {
-					http.Error(w, "Forbidden", http.StatusForbidden)
-					p.logger.Error("Failed to mark csrf token as used", "error", err)
+					p.rn.Error(r.Context(), w, r, apperr.Internal(fmt.Errorf("failed to mark csrf token as used: %w", err)))
 					return
 				}
-				http.Error(w, "Forbidden", http.StatusForbidden)
-				p.logger.Error("CSRF token expired", "token", r.FormValue("csrf_token"))
+				p.reject(w, r, "expired", "token", r.FormValue("csrf_token"))
 				return
 			}
 			// check if the user agent and ip match
 			if r.UserAgent() != userAgent {
-				http.Error(w, "Forbidden", http.StatusForbidden)
-				p.logger.Error("User agent mismatch", "expected", userAgent, "actual", r.UserAgent())
+				p.reject(w, r, "user agent mismatch", "expected", userAgent, "actual", r.UserAgent())
 				return
 			}
-			addrParts := strings.Split(r.RemoteAddr, ":")
-			if len(addrParts) != 2 {
-				http.Error(w, "Forbidden", http.StatusForbidden)
-				p.logger.Error("Invalid remote address", "address", r.RemoteAddr)
-				return
-			}
-			if addrParts[0] != ip {
-				http.Error(w, "Forbidden", http.StatusForbidden)
-				p.logger.Error("IP mismatch", "expected", ip, "actual", addrParts[0])
+			if clientIP := proxy.ClientIP(r); clientIP != ip {
+				p.reject(w, r, "ip mismatch", "expected", ip, "actual", clientIP)
 				return
 			}
 			// mark the token as used
 			_, err = p.db.Exec("UPDATE csrf SET used = TRUE WHERE csrf_token = ?;", r.FormValue("csrf_token"))
 			if err != nil {
-				http.Error(w, "Forbidden", http.StatusForbidden)
-				p.logger.Error("Failed to mark csrf token as used", "error", err)
+				p.rn.Error(r.Context(), w, r, apperr.Internal(fmt.Errorf("failed to mark csrf token as used: %w", err)))
 				return
 			}
 			next(w, r)
@@ -104,6 +97,17 @@ func (p Provider) BuildValidator() func(http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+// reject answers a request whose token didn't check out. It's the client's
+// doing so it is audited and logged as a warning rather than an error.
+func (p Provider) reject(w http.ResponseWriter, r *http.Request, reason string, attrs ...any) {
+	p.logger.Warn("CSRF token rejected", append([]any{"reason", reason}, attrs...)...)
+	e := audit.Event{Event: audit.CSRFFailure, Detail: reason}
+	if err := p.auditLog.Record(r.Context(), r, e); err != nil {
+		p.logger.Error("Failed to record csrf failure", "error", err)
+	}
+	p.rn.Error(r.Context(), w, r, apperr.Forbidden())
+}
+
 func (p Provider) MakeRequestToken(r *http.Request) (string, error) {
 	query := "INSERT INTO csrf(csrf_token, user_agent, ip) VALUES (?, ?, ?);"
 	token, err := auth.GenerateRandomBytes(32)
@@ -112,12 +116,19 @@ func (p Provider) MakeRequestToken(r *http.Request) (string, error) {
 	}
 	tokenBase64 := base64.StdEncoding.EncodeToString(token)
 
-	addrParts := strings.Split(r.RemoteAddr, ":")
-	if len(addrParts) != 2 {
-		return "", fmt.Errorf("invalid remote address: %s", r.RemoteAddr)
-	}
-	if _, err = p.db.Exec(query, tokenBase64, r.UserAgent(), addrParts[0]); err != nil {
+	if _, err = p.db.Exec(query, tokenBase64, r.UserAgent(), proxy.ClientIP(r)); err != nil {
 		return "", fmt.Errorf("failed to insert csrf token: %v", err)
 	}
 	return tokenBase64, nil
 }
+
+// Purge deletes the tokens that can no longer be used, they are used up or
+// too old
+func Purge(db *sql.DB) (int64, error) {
+	query := "DELETE FROM csrf WHERE used = TRUE OR created_at < datetime('now', ?);"
+	res, err := db.Exec(query, fmt.Sprintf("-%d minutes", maxCSRFTokenAgeMinutes))
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge csrf tokens: %w", err)
+	}
+	return res.RowsAffected()
+}
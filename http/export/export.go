@@ -0,0 +1,357 @@
+package export
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/somethingsoftware/violet-web/http/audit"
+	"github.com/somethingsoftware/violet-web/http/auth"
+	"github.com/somethingsoftware/violet-web/http/session"
+)
+
+// CREATE TABLE data_export (
+// id INTEGER PRIMARY KEY AUTOINCREMENT,
+// user_id INTEGER NOT NULL,
+// token TEXT UNIQUE NOT NULL,
+// status TEXT NOT NULL DEFAULT 'pending',
+// archive BLOB,
+// created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+// expires_at TIMESTAMP,
+// FOREIGN KEY (user_id) REFERENCES user(id) ON DELETE CASCADE);
+
+const (
+	StatusPending = "pending"
+	StatusReady   = "ready"
+	StatusFailed  = "failed"
+)
+
+// LinkLifetime is how long a finished export can be downloaded for
+const LinkLifetime = 24 * time.Hour
+
+var ErrNotFound = errors.New("export not found or expired")
+
+// ErrInProgress is returned by Request when the user already has an export
+// that is pending or can still be downloaded
+var ErrInProgress = errors.New("data export already requested")
+
+// Export is a row of the data_export table without the archive itself
+type Export struct {
+	Token     string
+	Status    string
+	CreatedAt time.Time
+	ExpiresAt sql.NullTime
+}
+
+// Worker builds requested exports in the background so a large account does
+// not hold up a request
+type Worker struct {
+	db       *sql.DB
+	sc       *session.Cache
+	auditLog *audit.Log
+	logger   *slog.Logger
+	wake     chan struct{}
+}
+
+func NewWorker(db *sql.DB, sc *session.Cache, auditLog *audit.Log, logger *slog.Logger) *Worker {
+	return &Worker{
+		db:       db,
+		sc:       sc,
+		auditLog: auditLog,
+		logger:   logger,
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// Start processes pending exports until the context is done, wg is done once
+// the worker has stopped. Pending rows are read from the database so requests
+// made before a restart are not lost.
+func (wk *Worker) Start(ctx context.Context, wg *sync.WaitGroup, every time.Duration) {
+	ticker := time.NewTicker(every)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer ticker.Stop()
+		for {
+			if err := wk.processPending(ctx); err != nil {
+				wk.logger.Error("Failed to process data exports", "error", err)
+			}
+			if err := wk.prune(); err != nil {
+				wk.logger.Error("Failed to prune data exports", "error", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-wk.wake:
+			}
+		}
+	}()
+}
+
+// Request queues a new export for the user. Every export holds a copy of all
+// their data so there is only one at a time, until it expires or fails.
+func (wk *Worker) Request(userID uint64) error {
+	bytes, err := auth.GenerateRandomBytes(32)
+	if err != nil {
+		return fmt.Errorf("failed to generate export token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(bytes)
+
+	// one statement so two requests at once can't both get past the check
+	query := `INSERT INTO data_export (user_id, token) SELECT ?, ?
+		WHERE NOT EXISTS (SELECT 1 FROM data_export WHERE user_id = ? AND
+			(status = ? OR (status = ? AND expires_at > ?)));`
+	res, err := wk.db.Exec(query, userID, token, userID, StatusPending, StatusReady,
+		time.Now().UTC().Format(time.DateTime))
+	if err != nil {
+		return fmt.Errorf("failed to insert data export: %w", err)
+	}
+	inserted, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to insert data export: %w", err)
+	}
+	if inserted == 0 {
+		return ErrInProgress
+	}
+
+	// don't block if the worker already has a wake up waiting
+	select {
+	case wk.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// prune deletes the exports that expired and the failed ones once they have
+// been shown for as long as a finished export would have been
+func (wk *Worker) prune() error {
+	now := time.Now().UTC()
+	query := "DELETE FROM data_export WHERE expires_at < ? OR (status = ? AND created_at < ?);"
+	_, err := wk.db.Exec(query, now.Format(time.DateTime), StatusFailed,
+		now.Add(-LinkLifetime).Format(time.DateTime))
+	return err
+}
+
+// List returns the user's exports that have not expired, newest first
+func (wk *Worker) List(userID uint64) ([]Export, error) {
+	query := `SELECT token, status, created_at, expires_at FROM data_export
+		WHERE user_id = ? AND (expires_at IS NULL OR expires_at > ?) ORDER BY id DESC;`
+	rows, err := wk.db.Query(query, userID, time.Now().UTC().Format(time.DateTime))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query data exports: %w", err)
+	}
+	defer rows.Close()
+
+	var exports []Export
+	for rows.Next() {
+		var e Export
+		if err := rows.Scan(&e.Token, &e.Status, &e.CreatedAt, &e.ExpiresAt); err != nil {
+			return nil, fmt.Errorf("failed to scan data export: %w", err)
+		}
+		exports = append(exports, e)
+	}
+	return exports, rows.Err()
+}
+
+// Archive returns a finished export, it only belongs to the user who asked
+// for it and only until it expires
+func (wk *Worker) Archive(userID uint64, token string) ([]byte, error) {
+	query := `SELECT archive FROM data_export
+		WHERE user_id = ? AND token = ? AND status = ? AND expires_at > ?;`
+	var archive []byte
+	err := wk.db.QueryRow(query, userID, token, StatusReady,
+		time.Now().UTC().Format(time.DateTime)).Scan(&archive)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get data export: %w", err)
+	}
+	return archive, nil
+}
+
+func (wk *Worker) processPending(ctx context.Context) error {
+	rows, err := wk.db.Query("SELECT id, user_id FROM data_export WHERE status = ?;", StatusPending)
+	if err != nil {
+		return fmt.Errorf("failed to query pending exports: %w", err)
+	}
+	type job struct {
+		id     int64
+		userID uint64
+	}
+	var jobs []job
+	for rows.Next() {
+		var j job
+		if err := rows.Scan(&j.id, &j.userID); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan pending export: %w", err)
+		}
+		jobs = append(jobs, j)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to iterate pending exports: %w", err)
+	}
+
+	for _, j := range jobs {
+		archive, err := wk.build(j.userID)
+		if err != nil {
+			wk.logger.Error("Failed to build data export", "user_id", j.userID, "error", err)
+			if _, err := wk.db.Exec("UPDATE data_export SET status = ? WHERE id = ?;", StatusFailed, j.id); err != nil {
+				return fmt.Errorf("failed to mark export failed: %w", err)
+			}
+			continue
+		}
+		expiresAt := time.Now().UTC().Add(LinkLifetime).Format(time.DateTime)
+		query := "UPDATE data_export SET status = ?, archive = ?, expires_at = ? WHERE id = ?;"
+		if _, err := wk.db.Exec(query, StatusReady, archive, expiresAt, j.id); err != nil {
+			return fmt.Errorf("failed to store data export: %w", err)
+		}
+		wk.logger.DebugContext(ctx, "Built data export", "user_id", j.userID, "bytes", len(archive))
+	}
+	return nil
+}
+
+type archiveUser struct {
+	ID            uint64     `json:"id"`
+	Username      string     `json:"username"`
+	Email         string     `json:"email"`
+	EmailVerified bool       `json:"email_verified"`
+	Role          string     `json:"role"`
+	Disabled      bool       `json:"disabled"`
+	CreatedAt     time.Time  `json:"created_at"`
+	LastLoginAt   *time.Time `json:"last_login_at"`
+	Bio           string     `json:"bio"`
+	Avatar        string     `json:"avatar"`
+	PublicBio     bool       `json:"public_bio"`
+	PublicPosts   bool       `json:"public_posts"`
+	Locale        string     `json:"locale"`
+}
+
+type archivePost struct {
+	ID        uint64    `json:"id"`
+	Title     string    `json:"title"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type archiveComment struct {
+	ID        uint64    `json:"id"`
+	PostID    uint64    `json:"post_id"`
+	ParentID  *uint64   `json:"parent_id"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type archiveSession struct {
+	LoginTime time.Time `json:"login_time"`
+}
+
+type archiveEvent struct {
+	Event     string    `json:"event"`
+	Detail    string    `json:"detail"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type archive struct {
+	GeneratedAt time.Time        `json:"generated_at"`
+	User        archiveUser      `json:"user"`
+	Posts       []archivePost    `json:"posts"`
+	Comments    []archiveComment `json:"comments"`
+	Sessions    []archiveSession `json:"sessions"`
+	AuditEvents []archiveEvent   `json:"audit_events"`
+}
+
+// build collects everything held about the user, leaving out the password
+// hash and salt
+func (wk *Worker) build(userID uint64) ([]byte, error) {
+	a := archive{
+		GeneratedAt: time.Now().UTC(),
+		Posts:       []archivePost{},
+		Comments:    []archiveComment{},
+		Sessions:    []archiveSession{},
+		AuditEvents: []archiveEvent{},
+	}
+
+	query := `SELECT id, username, email, email_verified, role, disabled, created_at, last_login_at,
+		bio, avatar, public_bio, public_posts, locale FROM user WHERE id = ?;`
+	var lastLogin sql.NullTime
+	err := wk.db.QueryRow(query, userID).Scan(&a.User.ID, &a.User.Username, &a.User.Email,
+		&a.User.EmailVerified, &a.User.Role, &a.User.Disabled, &a.User.CreatedAt, &lastLogin,
+		&a.User.Bio, &a.User.Avatar, &a.User.PublicBio, &a.User.PublicPosts, &a.User.Locale)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	if lastLogin.Valid {
+		a.User.LastLoginAt = &lastLogin.Time
+	}
+
+	rows, err := wk.db.Query("SELECT id, title, body, created_at FROM post WHERE user_id = ? ORDER BY id;", userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query posts: %w", err)
+	}
+	for rows.Next() {
+		var p archivePost
+		if err := rows.Scan(&p.ID, &p.Title, &p.Body, &p.CreatedAt); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan post: %w", err)
+		}
+		a.Posts = append(a.Posts, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate posts: %w", err)
+	}
+
+	query = "SELECT id, post_id, parent_id, body, created_at FROM comment WHERE user_id = ? ORDER BY id;"
+	rows, err = wk.db.Query(query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query comments: %w", err)
+	}
+	for rows.Next() {
+		var c archiveComment
+		var parentID sql.NullInt64
+		if err := rows.Scan(&c.ID, &c.PostID, &parentID, &c.Body, &c.CreatedAt); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan comment: %w", err)
+		}
+		if parentID.Valid {
+			id := uint64(parentID.Int64)
+			c.ParentID = &id
+		}
+		a.Comments = append(a.Comments, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate comments: %w", err)
+	}
+
+	for _, s := range wk.sc.UserSessions(userID) {
+		a.Sessions = append(a.Sessions, archiveSession{LoginTime: time.UnixMilli(s.LoginTime).UTC()})
+	}
+
+	// a negative limit is no limit in sqlite
+	events, err := wk.auditLog.UserEvents(userID, -1)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range events {
+		a.AuditEvents = append(a.AuditEvents, archiveEvent{
+			Event:     e.Event.Event,
+			Detail:    e.Detail,
+			IP:        e.IP,
+			UserAgent: e.UserAgent,
+			CreatedAt: e.CreatedAt,
+		})
+	}
+
+	return json.MarshalIndent(a, "", "  ")
+}
@@ -0,0 +1,258 @@
+package export
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/glebarez/go-sqlite"
+	"github.com/somethingsoftware/violet-web/http/audit"
+	"github.com/somethingsoftware/violet-web/http/session"
+	"github.com/somethingsoftware/violet-web/migrate"
+)
+
+func newTestWorker(t *testing.T) (*Worker, *sql.DB) {
+	t.Helper()
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "test.db")+"?_pragma=foreign_keys(1)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	if err := migrate.AutoUP(db, logger); err != nil {
+		t.Fatal(err)
+	}
+	queries := []string{
+		"INSERT INTO user (id, username, email, salt, password_hash) VALUES (1, 'alice', 'alice@example.com', 'secret-salt', 'secret-hash');",
+		"INSERT INTO user (id, username, email, salt, password_hash) VALUES (2, 'bob', 'bob@example.com', '', '');",
+		"INSERT INTO post (user_id, title, body) VALUES (1, 'hello', 'first post');",
+		"INSERT INTO post (user_id, title, body) VALUES (2, 'other', 'not alice''s');",
+	}
+	for _, query := range queries {
+		if _, err := db.Exec(query); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return NewWorker(db, session.NewCache(db), audit.NewLog(db, logger), logger), db
+}
+
+func TestExport(t *testing.T) {
+	wk, _ := newTestWorker(t)
+	if err := wk.Request(1); err != nil {
+		t.Fatal(err)
+	}
+	exports, err := wk.List(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exports) != 1 || exports[0].Status != StatusPending {
+		t.Fatalf("List() before processing = %+v, want one pending export", exports)
+	}
+	if _, err := wk.Archive(1, exports[0].Token); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Archive() of a pending export error = %v, want ErrNotFound", err)
+	}
+
+	if err := wk.processPending(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	exports, err = wk.List(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exports) != 1 || exports[0].Status != StatusReady || !exports[0].ExpiresAt.Valid {
+		t.Fatalf("List() after processing = %+v, want one ready export", exports)
+	}
+
+	data, err := wk.Archive(1, exports[0].Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "secret-") {
+		t.Error("the archive contains the password hash or salt")
+	}
+	var a archive
+	if err := json.Unmarshal(data, &a); err != nil {
+		t.Fatal(err)
+	}
+	if a.User.Username != "alice" || len(a.Posts) != 1 || a.Posts[0].Title != "hello" {
+		t.Errorf("archive = %+v, want alice and her one post", a)
+	}
+
+	if _, err := wk.Archive(2, exports[0].Token); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Archive() by another user error = %v, want ErrNotFound", err)
+	}
+	if exports, err := wk.List(2); err != nil || len(exports) != 0 {
+		t.Errorf("List() of another user = %+v, %v", exports, err)
+	}
+}
+
+func TestExportExpired(t *testing.T) {
+	wk, db := newTestWorker(t)
+	if err := wk.Request(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := wk.processPending(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	exports, err := wk.List(1)
+	if err != nil || len(exports) != 1 {
+		t.Fatalf("List() = %+v, %v, want the export", exports, err)
+	}
+	expired := time.Now().UTC().Add(-time.Hour).Format(time.DateTime)
+	if _, err := db.Exec("UPDATE data_export SET expires_at = ?;", expired); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wk.Archive(1, exports[0].Token); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Archive() of an expired export error = %v, want ErrNotFound", err)
+	}
+	if exports, err := wk.List(1); err != nil || len(exports) != 0 {
+		t.Errorf("List() = %+v, %v, want the expired export hidden", exports, err)
+	}
+}
+
+func TestRequestInProgress(t *testing.T) {
+	wk, db := newTestWorker(t)
+	count := func() int {
+		t.Helper()
+		var n int
+		if err := db.QueryRow("SELECT COUNT(*) FROM data_export;").Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	if err := wk.Request(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := wk.Request(1); !errors.Is(err, ErrInProgress) {
+		t.Errorf("Request() with one pending error = %v, want ErrInProgress", err)
+	}
+	if err := wk.processPending(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := wk.Request(1); !errors.Is(err, ErrInProgress) {
+		t.Errorf("Request() with one ready error = %v, want ErrInProgress", err)
+	}
+	if n := count(); n != 1 {
+		t.Fatalf("%d exports, want 1", n)
+	}
+
+	// once it expires or fails there can be a new one
+	expired := time.Now().UTC().Add(-time.Hour).Format(time.DateTime)
+	if _, err := db.Exec("UPDATE data_export SET expires_at = ?;", expired); err != nil {
+		t.Fatal(err)
+	}
+	if err := wk.Request(1); err != nil {
+		t.Errorf("Request() after the export expired error = %v", err)
+	}
+	if _, err := db.Exec("UPDATE data_export SET status = ?;", StatusFailed); err != nil {
+		t.Fatal(err)
+	}
+	if err := wk.Request(1); err != nil {
+		t.Errorf("Request() after the export failed error = %v", err)
+	}
+	if n := count(); n != 3 {
+		t.Errorf("%d exports, want 3", n)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	wk, db := newTestWorker(t)
+	old := time.Now().UTC().Add(-LinkLifetime - time.Hour).Format(time.DateTime)
+	expired := time.Now().UTC().Add(-time.Hour).Format(time.DateTime)
+	queries := []struct {
+		query string
+		args  []any
+	}{
+		{query: "INSERT INTO data_export (user_id, token, status, expires_at) VALUES (1, 'expired', ?, ?);", args: []any{StatusReady, expired}},
+		{query: "INSERT INTO data_export (user_id, token, status, created_at) VALUES (1, 'old failure', ?, ?);", args: []any{StatusFailed, old}},
+		{query: "INSERT INTO data_export (user_id, token, status) VALUES (1, 'new failure', ?);", args: []any{StatusFailed}},
+		{query: "INSERT INTO data_export (user_id, token, status) VALUES (1, 'pending', ?);", args: []any{StatusPending}},
+	}
+	for _, q := range queries {
+		if _, err := db.Exec(q.query, q.args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := wk.prune(); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := db.Query("SELECT token FROM data_export ORDER BY id;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var kept []string
+	for rows.Next() {
+		var token string
+		if err := rows.Scan(&token); err != nil {
+			t.Fatal(err)
+		}
+		kept = append(kept, token)
+	}
+	if len(kept) != 2 || kept[0] != "new failure" || kept[1] != "pending" {
+		t.Errorf("prune() kept %q, want the new failure and the pending export", kept)
+	}
+}
+
+func TestExportFailed(t *testing.T) {
+	wk, db := newTestWorker(t)
+	if err := wk.Request(1); err != nil {
+		t.Fatal(err)
+	}
+	// the foreign key takes the export along with the account, an export
+	// without its user is left only by a database from before it
+	db.SetMaxOpenConns(1)
+	for _, query := range []string{"PRAGMA foreign_keys = OFF;", "DELETE FROM user WHERE id = 1;", "PRAGMA foreign_keys = ON;"} {
+		if _, err := db.Exec(query); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := wk.processPending(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	var status string
+	if err := db.QueryRow("SELECT status FROM data_export;").Scan(&status); err != nil {
+		t.Fatal(err)
+	}
+	if status != StatusFailed {
+		t.Errorf("status = %s, want %s", status, StatusFailed)
+	}
+}
+
+func TestStart(t *testing.T) {
+	wk, db := newTestWorker(t)
+	if err := wk.Request(1); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var wg sync.WaitGroup
+	wk.Start(ctx, &wg, time.Hour)
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("worker didn't stop after its context was cancelled")
+	}
+
+	// pending exports from before a restart are built as soon as it starts
+	var status string
+	if err := db.QueryRow("SELECT status FROM data_export;").Scan(&status); err != nil {
+		t.Fatal(err)
+	}
+	if status != StatusReady {
+		t.Errorf("status = %s, want %s", status, StatusReady)
+	}
+}
@@ -0,0 +1,107 @@
+package feed
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testFeed = Feed{
+	Title:       "Violet posts",
+	Description: "The newest posts",
+	Link:        "https://violet.example/posts",
+	Self:        "https://violet.example/feed.xml",
+	Updated:     time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC),
+	Items: []Item{{
+		Title:     "Fish & chips",
+		Link:      "https://violet.example/posts/1",
+		Author:    "alice",
+		Content:   "<p>hello <script>x</script></p>",
+		Published: time.Date(2024, 5, 6, 7, 0, 0, 0, time.UTC),
+		Updated:   time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC),
+	}},
+}
+
+func TestRSS(t *testing.T) {
+	out, err := RSS(testFeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(out), "<script>") {
+		t.Error("item content isn't escaped")
+	}
+	var doc struct {
+		Channel struct {
+			Title         string `xml:"title"`
+			LastBuildDate string `xml:"lastBuildDate"`
+			Items         []struct {
+				Title       string `xml:"title"`
+				GUID        string `xml:"guid"`
+				Description string `xml:"description"`
+				PubDate     string `xml:"pubDate"`
+			} `xml:"item"`
+		} `xml:"channel"`
+	}
+	if err := xml.Unmarshal(out, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Channel.Title != "Violet posts" || doc.Channel.LastBuildDate != "Mon, 06 May 2024 07:08:09 +0000" {
+		t.Errorf("channel = %+v", doc.Channel)
+	}
+	if len(doc.Channel.Items) != 1 {
+		t.Fatalf("%d items, want 1", len(doc.Channel.Items))
+	}
+	item := doc.Channel.Items[0]
+	if item.Title != "Fish & chips" || item.GUID != "https://violet.example/posts/1" ||
+		item.Description != testFeed.Items[0].Content || item.PubDate != "Mon, 06 May 2024 07:00:00 +0000" {
+		t.Errorf("item = %+v", item)
+	}
+}
+
+func TestAtom(t *testing.T) {
+	out, err := Atom(testFeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		XMLName xml.Name `xml:"http://www.w3.org/2005/Atom feed"`
+		ID      string   `xml:"id"`
+		Updated string   `xml:"updated"`
+		Entries []struct {
+			Title   string `xml:"title"`
+			Author  string `xml:"author>name"`
+			Content struct {
+				Type  string `xml:"type,attr"`
+				Value string `xml:",chardata"`
+			} `xml:"content"`
+			Updated string `xml:"updated"`
+		} `xml:"entry"`
+	}
+	if err := xml.Unmarshal(out, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.ID != testFeed.Self || doc.Updated != "2024-05-06T07:08:09Z" {
+		t.Errorf("feed = %+v", doc)
+	}
+	if len(doc.Entries) != 1 {
+		t.Fatalf("%d entries, want 1", len(doc.Entries))
+	}
+	entry := doc.Entries[0]
+	if entry.Title != "Fish & chips" || entry.Author != "alice" || entry.Content.Type != "html" ||
+		entry.Content.Value != testFeed.Items[0].Content || entry.Updated != "2024-05-06T07:08:09Z" {
+		t.Errorf("entry = %+v", entry)
+	}
+}
+
+func TestEmptyFeed(t *testing.T) {
+	for name, render := range map[string]func(Feed) ([]byte, error){"rss": RSS, "atom": Atom} {
+		out, err := render(Feed{Title: "empty", Self: "https://violet.example/feed"})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if err := xml.Unmarshal(out, new(struct{})); err != nil {
+			t.Errorf("%s of an empty feed isn't xml: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package flash
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strings"
+)
+
+// the cookie only has to survive the redirect, it is deleted as soon as it
+// is shown
+const cookieName = "flash"
+const cookieMaxAge = 60
+
+const (
+	Success = "success"
+	Info    = "info"
+	Error   = "error"
+)
+
+// Message is shown once at the top of the next page the user sees
+type Message struct {
+	Kind string
+	Text string
+}
+
+// Set stores a message to show on the next page, call it before redirecting
+func Set(w http.ResponseWriter, kind, text string) {
+	value := base64.RawURLEncoding.EncodeToString([]byte(kind + "\n" + text))
+	http.SetCookie(w, &http.Cookie{
+		Name:     cookieName,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   cookieMaxAge,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// Get returns the pending message, if any. The text is user visible only and
+// escaped when rendered so a tampered cookie can't do anything the user
+// couldn't already do.
+func Get(r *http.Request) *Message {
+	cookie, err := r.Cookie(cookieName)
+	if err != nil {
+		return nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+	if err != nil {
+		return nil
+	}
+	kind, text, ok := strings.Cut(string(raw), "\n")
+	if !ok || text == "" {
+		return nil
+	}
+	switch kind {
+	case Success, Info, Error:
+	default:
+		kind = Info
+	}
+	return &Message{Kind: kind, Text: text}
+}
+
+// Clear deletes the pending message, if any, once it has been shown so it is
+// only shown once
+func Clear(w http.ResponseWriter, r *http.Request) {
+	if _, err := r.Cookie(cookieName); err != nil {
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     cookieName,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
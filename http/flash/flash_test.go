@@ -0,0 +1,66 @@
+package flash
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSetGetClear(t *testing.T) {
+	w := httptest.NewRecorder()
+	Set(w, Success, "Saved <b>it</b>")
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].MaxAge != cookieMaxAge || !cookies[0].HttpOnly {
+		t.Fatalf("cookies = %v", cookies)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(cookies[0])
+	msg := Get(r)
+	if msg == nil || msg.Kind != Success || msg.Text != "Saved <b>it</b>" {
+		t.Fatalf("Get() = %+v", msg)
+	}
+	// shown once, the cookie is deleted on the way out
+	w = httptest.NewRecorder()
+	Clear(w, r)
+	cookies = w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != cookieName || cookies[0].MaxAge >= 0 {
+		t.Errorf("cookies after Clear() = %v, want the flash deleted", cookies)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	if msg := Get(r); msg != nil {
+		t.Errorf("Get() without a cookie = %+v", msg)
+	}
+	w = httptest.NewRecorder()
+	Clear(w, r)
+	if cookies := w.Result().Cookies(); len(cookies) != 0 {
+		t.Errorf("Clear() without a cookie set %v", cookies)
+	}
+}
+
+func TestGetTampered(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  *Message
+	}{
+		{name: "not base64", value: "%%%"},
+		{name: "no text", value: base64.RawURLEncoding.EncodeToString([]byte("error\n"))},
+		{name: "no kind", value: base64.RawURLEncoding.EncodeToString([]byte("just text"))},
+		{
+			name:  "unknown kind",
+			value: base64.RawURLEncoding.EncodeToString([]byte("danger\" onclick=\"x\nhi")),
+			want:  &Message{Kind: Info, Text: "hi"},
+		},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.AddCookie(&http.Cookie{Name: cookieName, Value: tt.value})
+		msg := Get(r)
+		if (msg == nil) != (tt.want == nil) || (msg != nil && *msg != *tt.want) {
+			t.Errorf("%s: Get() = %+v, want %+v", tt.name, msg, tt.want)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package form
+
+// Form is the template data for a form page. When a submission fails
+// validation the page is rendered again with what the user typed in Values
+// and a message per field in Errors. Secrets like passwords are never put
+// back in Values.
+type Form struct {
+	CSRFToken string
+	Values    map[string]string
+	Errors    map[string]string
+}
+
+// General is the Errors key for problems with the form as a whole rather
+// than a single field
+const General = "_form"
+
+func New() *Form {
+	return &Form{
+		Values: make(map[string]string),
+		Errors: make(map[string]string),
+	}
+}
+
+func (f *Form) Set(field, value string) {
+	f.Values[field] = value
+}
+
+// Error records a message for the field, only the first one is kept so the
+// user fixes problems in the order they were checked
+func (f *Form) Error(field, message string) {
+	if _, ok := f.Errors[field]; !ok {
+		f.Errors[field] = message
+	}
+}
+
+func (f *Form) Valid() bool {
+	return len(f.Errors) == 0
+}
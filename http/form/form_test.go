@@ -0,0 +1,24 @@
+package form
+
+import "testing"
+
+func TestForm(t *testing.T) {
+	f := New()
+	if !f.Valid() {
+		t.Error("new form isn't valid")
+	}
+	f.Set("username", "alice")
+	f.Error("username", "too short")
+	f.Error("username", "taken")
+	f.Error(General, "try again")
+	if f.Valid() {
+		t.Error("form with errors is valid")
+	}
+	if f.Values["username"] != "alice" {
+		t.Errorf("Values = %v", f.Values)
+	}
+	// the first problem found is the one shown
+	if f.Errors["username"] != "too short" || f.Errors[General] != "try again" {
+		t.Errorf("Errors = %v", f.Errors)
+	}
+}
@@ -0,0 +1,182 @@
+package i18n
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Messages are keyed by their English text so templates and handlers stay
+// readable and anything missing from a catalog falls back to English. The
+// English catalog only needs the plural forms.
+//
+//go:embed locales/*.json
+var localeFS embed.FS
+
+const Default = "en"
+
+// Names are shown in the language picker, each in its own language
+var Names = map[string]string{
+	"en": "English",
+	"de": "Deutsch",
+}
+
+// pluralRules picks the form of a message to use for a count, languages
+// without a rule use the English one
+var pluralRules = map[string]func(n int) string{
+	"en": oneOther,
+	"de": oneOther,
+}
+
+func oneOther(n int) string {
+	if n == 1 {
+		return "one"
+	}
+	return "other"
+}
+
+// entry is either a plain string or an object of plural forms in the json
+type entry struct {
+	text   string
+	plural map[string]string
+}
+
+func (e *entry) UnmarshalJSON(b []byte) error {
+	if err := json.Unmarshal(b, &e.text); err == nil {
+		return nil
+	}
+	return json.Unmarshal(b, &e.plural)
+}
+
+var catalogs = mustLoad()
+
+func mustLoad() map[string]map[string]entry {
+	files, err := fs.Glob(localeFS, "locales/*.json")
+	if err != nil {
+		panic(err)
+	}
+	loaded := make(map[string]map[string]entry, len(files))
+	for _, file := range files {
+		b, err := localeFS.ReadFile(file)
+		if err != nil {
+			panic(err)
+		}
+		catalog := make(map[string]entry)
+		if err := json.Unmarshal(b, &catalog); err != nil {
+			panic(fmt.Sprintf("failed to parse %s: %v", file, err))
+		}
+		loaded[strings.TrimSuffix(path.Base(file), ".json")] = catalog
+	}
+	return loaded
+}
+
+// Supported returns the locales there are catalogs for
+func Supported() []string {
+	locales := make([]string, 0, len(catalogs))
+	for locale := range catalogs {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+func IsSupported(locale string) bool {
+	_, ok := catalogs[locale]
+	return ok
+}
+
+// Negotiate picks the locale for a request. A preference the user saved wins,
+// then the best match from the Accept-Language header, then English.
+func Negotiate(preference, acceptLanguage string) string {
+	if IsSupported(preference) {
+		return preference
+	}
+
+	type tag struct {
+		locale string
+		q      float64
+	}
+	var tags []tag
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		locale, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+		if locale != "" && q > 0 {
+			tags = append(tags, tag{strings.ToLower(locale), q})
+		}
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+
+	for _, t := range tags {
+		// de-AT is close enough to de
+		base, _, _ := strings.Cut(t.locale, "-")
+		if IsSupported(base) {
+			return base
+		}
+	}
+	return Default
+}
+
+type contextKey struct{}
+
+func WithLocale(ctx context.Context, locale string) context.Context {
+	return context.WithValue(ctx, contextKey{}, locale)
+}
+
+// Locale is the locale the middleware chose for the request
+func Locale(ctx context.Context) string {
+	if locale, ok := ctx.Value(contextKey{}).(string); ok {
+		return locale
+	}
+	return Default
+}
+
+func lookup(locale, key string) (entry, bool) {
+	if e, ok := catalogs[locale][key]; ok {
+		return e, true
+	}
+	e, ok := catalogs[Default][key]
+	return e, ok
+}
+
+// T translates the message into the request's locale and formats it with
+// args like fmt.Sprintf
+func T(ctx context.Context, key string, args ...any) string {
+	text := key
+	if e, ok := lookup(Locale(ctx), key); ok && e.text != "" {
+		text = e.text
+	}
+	if len(args) == 0 {
+		return text
+	}
+	return fmt.Sprintf(text, args...)
+}
+
+// N is T for messages that depend on a count, n is the first format
+// argument
+func N(ctx context.Context, key string, n int, args ...any) string {
+	locale := Locale(ctx)
+	text := key
+	if e, ok := lookup(locale, key); ok {
+		rule, ok := pluralRules[locale]
+		if !ok {
+			rule = oneOther
+		}
+		if form, ok := e.plural[rule(n)]; ok {
+			text = form
+		} else if form, ok := e.plural["other"]; ok {
+			text = form
+		}
+	}
+	return fmt.Sprintf(text, append([]any{n}, args...)...)
+}
@@ -0,0 +1,94 @@
+package i18n
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		preference, acceptLanguage, want string
+	}{
+		{acceptLanguage: "", want: "en"},
+		{acceptLanguage: "de-AT,de;q=0.9,en;q=0.8", want: "de"},
+		{acceptLanguage: "fr, en;q=0.5, de;q=0.7", want: "de"},
+		{acceptLanguage: "de;q=0, en", want: "en"},
+		{acceptLanguage: "fr, ja", want: "en"},
+		{acceptLanguage: "DE", want: "de"},
+		{preference: "de", acceptLanguage: "en", want: "de"},
+		{preference: "xx", acceptLanguage: "de", want: "de"},
+	}
+	for _, tt := range tests {
+		if got := Negotiate(tt.preference, tt.acceptLanguage); got != tt.want {
+			t.Errorf("Negotiate(%q, %q) = %s, want %s", tt.preference, tt.acceptLanguage, got, tt.want)
+		}
+	}
+}
+
+func TestT(t *testing.T) {
+	en := WithLocale(context.Background(), "en")
+	de := WithLocale(context.Background(), "de")
+	if got := T(de, "Log in to see posts by %s", "alice"); got != "Melde dich an, um Beiträge von alice zu sehen" {
+		t.Errorf("T(de) = %s", got)
+	}
+	if got := T(en, "Log in to see posts by %s", "alice"); got != "Log in to see posts by alice" {
+		t.Errorf("T(en) = %s", got)
+	}
+	// anything missing from a catalog is shown in English
+	if got := T(de, "no such message"); got != "no such message" {
+		t.Errorf("T(de) of a missing message = %s", got)
+	}
+	if got := T(context.Background(), "Back"); got != "Back" {
+		t.Errorf("T() without a locale = %s", got)
+	}
+}
+
+func TestN(t *testing.T) {
+	en := WithLocale(context.Background(), "en")
+	de := WithLocale(context.Background(), "de")
+	tests := []struct {
+		ctx  context.Context
+		n    int
+		want string
+	}{
+		{ctx: en, n: 0, want: "0 comments"},
+		{ctx: en, n: 1, want: "1 comment"},
+		{ctx: en, n: 2, want: "2 comments"},
+		{ctx: de, n: 1, want: "1 Kommentar"},
+		{ctx: de, n: 5, want: "5 Kommentare"},
+	}
+	for _, tt := range tests {
+		if got := N(tt.ctx, "%d comments", tt.n); got != tt.want {
+			t.Errorf("N(%s, %d) = %s, want %s", Locale(tt.ctx), tt.n, got, tt.want)
+		}
+	}
+}
+
+var verbRe = regexp.MustCompile(`%[a-z]`)
+
+// TestCatalogs checks every translation takes the same format arguments as
+// its English key, a missing %s would print %!(EXTRA ...) on the page
+func TestCatalogs(t *testing.T) {
+	for locale, catalog := range catalogs {
+		for key, e := range catalog {
+			want := strings.Join(verbRe.FindAllString(key, -1), "")
+			texts := []string{e.text}
+			if e.plural != nil {
+				texts = nil
+				if _, ok := e.plural["other"]; !ok {
+					t.Errorf("%s: %q has no other form", locale, key)
+				}
+				for _, text := range e.plural {
+					texts = append(texts, text)
+				}
+			}
+			for _, text := range texts {
+				if got := strings.Join(verbRe.FindAllString(text, -1), ""); got != want {
+					t.Errorf("%s: %q has verbs %s, want %s", locale, text, got, want)
+				}
+			}
+		}
+	}
+}
@@ -2,111 +2,314 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"database/sql"
-	"flag"
+	"embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
 	"log/slog"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"runtime"
 	"strconv"
 	"strings"
 	"sync"
-	"text/template"
+	"syscall"
 	"time"
 
 	_ "github.com/glebarez/go-sqlite"
+	"github.com/somethingsoftware/violet-web/http/account"
 	"github.com/somethingsoftware/violet-web/http/action"
+	"github.com/somethingsoftware/violet-web/http/apperr"
+	"github.com/somethingsoftware/violet-web/http/assets"
+	"github.com/somethingsoftware/violet-web/http/audit"
+	"github.com/somethingsoftware/violet-web/http/avatar"
+	"github.com/somethingsoftware/violet-web/http/config"
 	"github.com/somethingsoftware/violet-web/http/csrf"
+	"github.com/somethingsoftware/violet-web/http/export"
+	"github.com/somethingsoftware/violet-web/http/form"
+	"github.com/somethingsoftware/violet-web/http/i18n"
 	"github.com/somethingsoftware/violet-web/http/page"
+	"github.com/somethingsoftware/violet-web/http/proxy"
+	"github.com/somethingsoftware/violet-web/http/render"
 	"github.com/somethingsoftware/violet-web/http/session"
+	"github.com/somethingsoftware/violet-web/http/siteurl"
+	"github.com/somethingsoftware/violet-web/http/storage"
+	"github.com/somethingsoftware/violet-web/http/tlscert"
 	"github.com/somethingsoftware/violet-web/migrate"
 	"golang.org/x/time/rate"
 )
 
-func main() {
-	var sqlitePath string
-	var devMode bool
-	var logSource bool
-	var httpPort int
-	flag.StringVar(&sqlitePath, "sqlite", "", "Path to the SQLite database")
-	flag.BoolVar(&devMode, "dev", false, "Enable development mode")
-	flag.BoolVar(&logSource, "source", false, "Enable source logging")
-	flag.IntVar(&httpPort, "port", 8080, "Port to listen on")
-	flag.Parse()
+// the templates and static files are built into the binary, dev mode reads
+// them from disk instead so they can be edited without a rebuild
+var (
+	//go:embed gotmpl
+	templateFS embed.FS
+	//go:embed static
+	staticFS embed.FS
+)
 
+func newLogger(cfg *config.Config) *slog.Logger {
 	so := &slog.HandlerOptions{}
-	if devMode {
+	if cfg.DevMode {
 		so.Level = slog.LevelDebug
 	}
-	if logSource {
+	if cfg.LogSource {
 		so.AddSource = true
 	}
-	defaultAttrs := []slog.Attr{slog.String("dev_mode", strconv.FormatBool(devMode))}
+	defaultAttrs := []slog.Attr{slog.String("dev_mode", strconv.FormatBool(cfg.DevMode))}
 	baseHandler := slog.NewTextHandler(os.Stdout, so).WithAttrs(defaultAttrs)
 	customHandler := &ContextHandler{Handler: baseHandler}
-	logger := slog.New(customHandler)
+	return slog.New(customHandler)
+}
 
-	if runtime.GOOS != "linux" && !devMode {
+// serve runs the web server until it fails or is told to stop
+func serve(cfg *config.Config) {
+	logger := newLogger(cfg)
+
+	if runtime.GOOS != "linux" && !cfg.DevMode {
 		logger.Warn("Not running on Linux, consider enabling --dev mode")
 	}
+	for _, warning := range cfg.ServeWarnings() {
+		logger.Warn(warning)
+	}
 
-	if sqlitePath == "" {
-		sqlitePath = ":memory:"
-		logger.Warn("SQLite path is empty, using in-memory database")
+	resolver, err := proxy.NewResolver(cfg.TrustedProxies)
+	if err != nil {
+		logger.Error("Failed to parse trusted proxies", "error", err)
+		return
 	}
-	db, err := sql.Open("sqlite", sqlitePath)
+	site, err := siteurl.New(cfg.PublicURL)
+	if err != nil {
+		logger.Error("Failed to parse public url", "error", err)
+		return
+	}
+
+	db, err := openDB(cfg, logger)
 	if err != nil {
 		logger.Error("Failed to open SQLite", "error", err)
 		return
 	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			logger.Error("Failed to close SQLite", "error", err)
+		}
+	}()
+
+	// the workers are waited for before the db is closed and only after stop
+	// has cancelled their context, defers run in reverse
+	var workers sync.WaitGroup
+	defer workers.Wait()
+	// the background workers and the server stop on SIGINT or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	if err := migrate.AutoUP(db, logger); err != nil {
 		logger.Error("Failed to auto migrate database", "error", err)
 		return
 	}
 	logger.Debug("Successfully migrated database")
+	// a damaged database is reported but still served, it's most likely
+	// only a few rows and the admin needs the site to look into it
+	problems, err := migrate.Check(db)
+	if err != nil {
+		logger.Error("Failed to check database", "error", err)
+		return
+	}
+	for _, problem := range problems {
+		logger.Error("Database check found a problem", "problem", problem)
+	}
+
+	var templates fs.FS = os.DirFS("gotmpl")
+	var static fs.FS = os.DirFS("static")
+	if !cfg.DevMode {
+		templates, err = fs.Sub(templateFS, "gotmpl")
+		if err != nil {
+			logger.Error("Failed to open embedded templates", "error", err)
+			return
+		}
+		static, err = fs.Sub(staticFS, "static")
+		if err != nil {
+			logger.Error("Failed to open embedded static files", "error", err)
+			return
+		}
+	}
+	staticAssets, err := assets.New(static, cfg.DevMode, logger)
+	if err != nil {
+		logger.Error("Failed to load static files", "error", err)
+		return
+	}
+	rn, err := render.New(templates, cfg.DevMode, template.FuncMap{"asset": staticAssets.Path}, logger)
+	if err != nil {
+		logger.Error("Failed to parse templates", "error", err)
+		return
+	}
+
+	var store storage.Store = storage.NewDB(db)
+	if cfg.AvatarDir != "" {
+		store, err = storage.NewDisk(cfg.AvatarDir)
+		if err != nil {
+			logger.Error("Failed to open avatar storage", "error", err)
+			return
+		}
+	}
+
+	auditLog := audit.NewLog(db, logger)
+	auditLog.StartPruner(ctx, &workers, time.Hour, cfg.AuditRetention)
 
 	// build middleware
-	sc := session.NewCache()
-	loginRequired := loginChecker(sc, logger)
+	sc := session.NewCache(db)
+	account.StartPurger(ctx, &workers, db, sc, store, auditLog, logger, time.Hour, cfg.DeletionGrace)
+
+	exportWorker := export.NewWorker(db, sc, auditLog, logger)
+	exportWorker.Start(ctx, &workers, time.Minute)
+	loginRequired := loginChecker(sc, rn)
+	adminRequired := adminChecker(db, sc, rn, logger)
 
-	rateLimitIP := newIPRateLimiterByIP(logger, 1*time.Second, 10)
+	rateLimitIP := newIPRateLimiterByIP(auditLog, rn, logger, 1*time.Second, 10)
+	localize := localizer(sc)
 
-	csrfProvider := csrf.NewProvider(db, logger)
+	csrfProvider := csrf.NewProvider(db, rn, auditLog, logger)
 	csrfValidate := csrfProvider.BuildValidator()
 
-	serveUI := buildServeUI(logger)
-	serveCSRF := buildServeCSRF(csrfProvider, logger)
+	serveUI := buildServeUI(staticAssets, rn)
+	serveCSRF := buildServeCSRF(csrfProvider, rn)
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("GET /", serveUI)
+	mux.HandleFunc("GET /static/{name}", staticAssets.ServeHashed)
 
 	mux.HandleFunc("GET /login", serveCSRF)
-	mux.HandleFunc("POST /login", csrfValidate(action.Login(db, sc, logger)))
+	mux.HandleFunc("POST /login", csrfValidate(action.Login(db, sc, csrfProvider, rn, auditLog, logger)))
 
-	mux.HandleFunc("GET /logout", loginRequired(action.Logout(db, sc, logger)))
+	mux.HandleFunc("GET /logout", loginRequired(action.Logout(db, sc, auditLog, rn, logger)))
 
 	mux.HandleFunc("GET /register", serveCSRF)
-	mux.HandleFunc("POST /register", csrfValidate(action.Register(db, logger)))
+	mux.HandleFunc("POST /register", csrfValidate(action.Register(db, csrfProvider, rn, auditLog, logger)))
 
 	mux.HandleFunc("GET /forgot", serveCSRF)
-	mux.HandleFunc("POST /forgot", csrfValidate(action.Forgot(db, logger, devMode)))
+	mux.HandleFunc("POST /forgot", csrfValidate(action.Forgot(db, site, auditLog, rn, logger, cfg)))
+
+	mux.HandleFunc("GET /resetpass", action.ResetPassForm(db, rn, logger))
+	mux.HandleFunc("POST /resetpass", action.ResetPass(db, rn, auditLog, logger))
 
-	mux.HandleFunc("GET /resetpass", action.ResetPassForm(db, logger))
-	mux.HandleFunc("POST /resetpass", action.ResetPass(db, logger))
+	mux.HandleFunc("GET /user", loginRequired(page.User(db, sc, rn, logger)))
+	mux.HandleFunc("GET /user/profile", loginRequired(page.EditProfile(db, sc, csrfProvider, rn, logger)))
+	mux.HandleFunc("POST /user/profile", loginRequired(csrfValidate(action.UpdateProfile(db, sc, rn, logger))))
+	mux.HandleFunc("POST /user/avatar", loginRequired(limitBody(avatar.MaxUploadBytes+4096, csrfValidate(action.UploadAvatar(db, sc, store, rn, logger)))))
+	mux.HandleFunc("POST /user/avatar/delete", loginRequired(csrfValidate(action.RemoveAvatar(db, sc, store, rn, logger))))
+	mux.HandleFunc("GET /avatars/{name}", page.Avatar(store, rn, logger))
+	mux.HandleFunc("GET /user/security", loginRequired(page.Security(db, sc, auditLog, rn, logger)))
+	mux.HandleFunc("GET /user/delete", loginRequired(page.DeleteAccount(sc, csrfProvider, rn, logger, cfg)))
+	mux.HandleFunc("POST /user/delete", loginRequired(csrfValidate(action.DeleteAccount(db, sc, store, auditLog, rn, logger, cfg))))
+	mux.HandleFunc("GET /user/export", loginRequired(page.Export(sc, csrfProvider, exportWorker, rn, logger)))
+	mux.HandleFunc("POST /user/export", loginRequired(csrfValidate(action.RequestExport(sc, exportWorker, auditLog, rn, logger))))
+	mux.HandleFunc("GET /user/export/{token}", loginRequired(page.DownloadExport(sc, exportWorker, auditLog, rn, logger)))
 
-	mux.HandleFunc("GET /user", loginRequired(page.User(db, sc, logger)))
+	mux.HandleFunc("GET /posts", page.Posts(db, sc, rn, logger))
+	mux.HandleFunc("GET /search", page.Search(db, sc, rn, logger))
+	mux.HandleFunc("GET /u/{username}", page.Profile(db, sc, rn, logger))
+	mux.HandleFunc("GET /u/{username}/feed.xml", page.UserFeed(db, site, rn, logger, page.FeedRSS))
+	mux.HandleFunc("GET /u/{username}/feed.atom", page.UserFeed(db, site, rn, logger, page.FeedAtom))
+	mux.HandleFunc("GET /feed.xml", page.Feed(db, site, rn, logger, page.FeedRSS))
+	mux.HandleFunc("GET /feed.atom", page.Feed(db, site, rn, logger, page.FeedAtom))
+	mux.HandleFunc("GET /posts/new", loginRequired(page.NewPost(csrfProvider, rn, logger)))
+	mux.HandleFunc("POST /posts/preview", loginRequired(action.PreviewPost(rn, logger)))
+	mux.HandleFunc("POST /posts", loginRequired(csrfValidate(action.CreatePost(db, sc, rn, logger))))
+	mux.HandleFunc("GET /posts/{id}", page.Post(db, sc, csrfProvider, rn, logger))
+	mux.HandleFunc("GET /posts/{id}/edit", loginRequired(page.EditPost(db, sc, csrfProvider, rn, logger)))
+	mux.HandleFunc("POST /posts/{id}/edit", loginRequired(csrfValidate(action.UpdatePost(db, sc, rn, logger))))
+	mux.HandleFunc("POST /posts/{id}/delete", loginRequired(csrfValidate(action.DeletePost(db, sc, rn, logger))))
+	mux.HandleFunc("POST /posts/{id}/comments", loginRequired(csrfValidate(action.CreateComment(db, sc, rn, logger))))
+	mux.HandleFunc("GET /comments/{id}/delete", loginRequired(page.DeleteComment(db, csrfProvider, rn, logger)))
+	mux.HandleFunc("POST /comments/{id}/delete", loginRequired(csrfValidate(action.DeleteComment(db, sc, auditLog, rn, logger))))
+
+	mux.HandleFunc("GET /admin/users", adminRequired(page.AdminUsers(db, rn, logger)))
+	mux.HandleFunc("GET /admin/users/{id}", adminRequired(page.AdminUser(db, sc, csrfProvider, rn, logger)))
+	mux.HandleFunc("POST /admin/users/{id}/disable", adminRequired(csrfValidate(action.AdminDisable(db, sc, auditLog, rn, logger))))
+	mux.HandleFunc("POST /admin/users/{id}/enable", adminRequired(csrfValidate(action.AdminEnable(db, sc, auditLog, rn, logger))))
+	mux.HandleFunc("POST /admin/users/{id}/reset", adminRequired(csrfValidate(action.AdminForceReset(db, sc, site, auditLog, rn, logger))))
+	mux.HandleFunc("POST /admin/users/{id}/revoke", adminRequired(csrfValidate(action.AdminRevokeSessions(db, sc, auditLog, rn, logger))))
+	mux.HandleFunc("POST /admin/users/{id}/delete", adminRequired(csrfValidate(action.AdminDelete(db, sc, store, auditLog, rn, logger))))
 
 	// hacky way to allow global middleware
 	var muxServe http.HandlerFunc = func(w http.ResponseWriter, r *http.Request) {
 		mux.ServeHTTP(w, r)
 	}
-	rateLimitedServe := rateLimitIP(muxServe)
+	// the client has to be resolved before anything looks at its ip, and the
+	// locale picked before the rate limiter renders its error page
+	rateLimitedServe := resolver.Middleware(localize(rateLimitIP(muxServe)))
+
+	newServer := func(port int, handler http.Handler) *http.Server {
+		return &http.Server{
+			Addr:              ":" + strconv.Itoa(port),
+			Handler:           handler,
+			ReadTimeout:       cfg.ReadTimeout,
+			ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+			WriteTimeout:      cfg.WriteTimeout,
+			IdleTimeout:       cfg.IdleTimeout,
+			MaxHeaderBytes:    cfg.MaxHeaderBytes,
+			ErrorLog:          slog.NewLogLogger(logger.Handler(), slog.LevelWarn),
+		}
+	}
+	servers := []*http.Server{}
+	serveErr := make(chan error, 2)
+	if cfg.UseTLS() {
+		certs, err := tlscert.NewReloader(cfg.TLSCert, cfg.TLSKey, logger)
+		if err != nil {
+			logger.Error("Failed to load TLS certificate", "error", err)
+			return
+		}
+		certs.Start(ctx, &workers, cfg.TLSReload)
+
+		srv := newServer(cfg.HTTPPort, hsts(cfg.HSTSMaxAge, rateLimitedServe))
+		srv.TLSConfig = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: certs.GetCertificate,
+		}
+		servers = append(servers, srv)
+		go func() {
+			logger.Info("Starting server: https://localhost:" + strconv.Itoa(cfg.HTTPPort))
+			serveErr <- srv.ListenAndServeTLS("", "")
+		}()
+		if cfg.RedirectPort != 0 {
+			redirect := newServer(cfg.RedirectPort, redirectHTTPS(cfg.HTTPPort, cfg.PublicURL))
+			servers = append(servers, redirect)
+			go func() {
+				logger.Info("Redirecting to HTTPS from port " + strconv.Itoa(cfg.RedirectPort))
+				serveErr <- redirect.ListenAndServe()
+			}()
+		}
+	} else {
+		srv := newServer(cfg.HTTPPort, rateLimitedServe)
+		servers = append(servers, srv)
+		go func() {
+			logger.Info("Starting server: http://localhost:" + strconv.Itoa(cfg.HTTPPort))
+			serveErr <- srv.ListenAndServe()
+		}()
+	}
 
-	logger.Info("Starting server: http://localhost:" + strconv.Itoa(httpPort))
-	portString := ":" + strconv.Itoa(httpPort)
-	err = http.ListenAndServe(portString, rateLimitedServe)
-	logger.Error("Server Stopped.", "error", err)
+	select {
+	case err := <-serveErr:
+		logger.Error("Server Stopped.", "error", err)
+	case <-ctx.Done():
+		logger.Info("Shutting down, waiting for in-flight requests", "timeout", cfg.ShutdownTimeout)
+	}
+	// stop listening for signals so a second one kills the process
+	stop()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	for _, srv := range servers {
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			logger.Error("Failed to shut down gracefully", "error", err, "addr", srv.Addr)
+		}
+	}
+	logger.Info("Server stopped")
 }
 
 type ContextHandler struct {
@@ -122,82 +325,100 @@ func (h *ContextHandler) Handle(ctx context.Context, r slog.Record) error {
 	return h.Handler.Handle(ctx, r)
 }
 
-func buildServeUI(logger *slog.Logger) func(http.ResponseWriter, *http.Request) {
-	// TODO: serving static files of certain types should trigger caching
+// buildServeUI serves the landing page and the few static files browsers
+// and old pages expect at fixed paths, everything else links to the hashed
+// /static/ urls
+func buildServeUI(staticAssets *assets.Assets, rn *render.Renderer) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// TODO: this should not be a relative path
 		switch r.URL.Path {
 		case "/":
-			http.ServeFile(w, r, "./static/index.html")
+			rn.Render(w, r, "index.gotmpl", nil)
 			return
-		case "/favicon.ico":
-			http.ServeFile(w, r, "./static/favicon.ico")
-			return
-		case "/style.css":
-			http.ServeFile(w, r, "./static/style.css")
+		case "/favicon.ico", "/style.css":
+			staticAssets.Serve(w, r, strings.TrimPrefix(r.URL.Path, "/"))
 			return
 		default:
-			http.Error(w, "Not found", http.StatusNotFound)
-			logger.Error("Not found", "path", r.URL.Path)
+			rn.Error(r.Context(), w, r, apperr.NotFound())
 			return
 		}
 	}
 }
 
-func buildServeCSRF(csrfProvider *csrf.Provider, logger *slog.Logger) http.HandlerFunc {
+func buildServeCSRF(csrfProvider *csrf.Provider, rn *render.Renderer) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		token, err := csrfProvider.MakeRequestToken(r)
 		if err != nil {
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			rn.Error(r.Context(), w, r, apperr.Internal(fmt.Errorf("failed to make csrf token: %w", err)))
 			return
 		}
-		type CSRFform struct {
-			CSRFToken string
-		}
-		form := CSRFform{CSRFToken: token}
-		templatePath := ""
+		f := form.New()
+		f.CSRFToken = token
+		name := ""
 		switch r.URL.Path {
 		case "/login":
-			templatePath = "./gotmpl/login.gotmpl"
+			name = "login.gotmpl"
 		case "/register":
-			templatePath = "./gotmpl/register.gotmpl"
+			name = "register.gotmpl"
 		case "/forgot":
-			templatePath = "./gotmpl/forgot-pass.gotmpl"
+			name = "forgot-pass.gotmpl"
 		default:
-			http.Error(w, "Not found", http.StatusNotFound)
-			slog.Error("Not found", "path", r.URL.Path)
-			return
-		}
-		// can't user ParseFiles because I'm using relative paths
-		templateContent, err := os.ReadFile(templatePath)
-		if err != nil {
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			logger.Error("Failed to read csrf template", "error", err, "path", templatePath)
+			rn.Error(r.Context(), w, r, apperr.NotFound())
 			return
 		}
-		t, err := template.New("csrf").Parse(string(templateContent))
-		if err != nil {
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			logger.Error("Failed to parse template", "error", err)
-			return
-		}
-		if err = t.Execute(w, form); err != nil {
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			logger.Error("Failed to execute template", "error", err)
-			return
+		rn.Render(w, r, name, f)
+	}
+}
+
+// localizer picks the locale for every request, a logged in user's saved
+// preference, kept in their session, wins over what their browser asks for
+func localizer(sc *session.Cache) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			var preference string
+			if session, ok := sc.Cached(r); ok {
+				preference = session.Locale
+			}
+			locale := i18n.Negotiate(preference, r.Header.Get("Accept-Language"))
+			w.Header().Add("Vary", "Accept-Language")
+			next(w, r.WithContext(i18n.WithLocale(r.Context(), locale)))
 		}
 	}
 }
 
-func loginChecker(sc *session.Cache, logger *slog.Logger) func(next http.HandlerFunc) http.HandlerFunc {
+func loginChecker(sc *session.Cache, rn *render.Renderer) func(next http.HandlerFunc) http.HandlerFunc {
 	// outer building function holds the session cache
 	return func(next http.HandlerFunc) http.HandlerFunc {
 		return func(w http.ResponseWriter, r *http.Request) {
 			// TODO: should we require that the session stay on 1 ip?
 			_, err := sc.GetSession(r)
 			if err != nil {
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
-				logger.Error("Login required and failed", "error", err)
+				rn.Error(r.Context(), w, r, apperr.Unauthorized().With(err))
+				return
+			}
+			next(w, r)
+		}
+	}
+}
+
+// adminChecker is like loginChecker but also requires the user to have the
+// admin role. The role is read from the database on every request so that
+// demoting an admin takes effect immediately.
+func adminChecker(db *sql.DB, sc *session.Cache, rn *render.Renderer, logger *slog.Logger) func(next http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			session, err := sc.GetSession(r)
+			if err != nil {
+				rn.Error(r.Context(), w, r, apperr.Unauthorized().With(err))
+				return
+			}
+			isAdmin, err := account.IsAdmin(db, session.UserID)
+			if err != nil {
+				rn.Error(r.Context(), w, r, apperr.Internal(fmt.Errorf("failed to get user role: %w", err)))
+				return
+			}
+			if !isAdmin {
+				rn.Error(r.Context(), w, r, apperr.Forbidden())
+				logger.Warn("Admin required", "user_id", session.UserID, "path", r.URL.Path)
 				return
 			}
 			next(w, r)
@@ -207,13 +428,56 @@ func loginChecker(sc *session.Cache, logger *slog.Logger) func(next http.Handler
 
 type middleware func(http.HandlerFunc) http.HandlerFunc
 
-func newIPRateLimiterByIP(logger *slog.Logger, every time.Duration, burst int) middleware {
+// hsts tells browsers to only use HTTPS for this host from now on, it is only
+// sent over TLS since browsers ignore it on plain HTTP anyway
+func hsts(maxAge time.Duration, next http.HandlerFunc) http.HandlerFunc {
+	value := fmt.Sprintf("max-age=%d; includeSubDomains", int(maxAge.Seconds()))
+	return func(w http.ResponseWriter, r *http.Request) {
+		if maxAge > 0 && r.TLS != nil {
+			w.Header().Set("Strict-Transport-Security", value)
+		}
+		next(w, r)
+	}
+}
+
+// redirectHTTPS sends plain HTTP requests to the same url on the HTTPS port,
+// on the public url's host if there is one
+func redirectHTTPS(httpsPort int, publicURL string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if u, err := url.Parse(publicURL); err == nil && u.Host != "" {
+			host = u.Host
+		}
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if httpsPort != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(httpsPort))
+		}
+		target := url.URL{Scheme: "https", Host: host, Path: r.URL.Path, RawQuery: r.URL.RawQuery}
+		http.Redirect(w, r, target.String(), http.StatusPermanentRedirect)
+	}
+}
+
+// limitBody caps the request body before anything reads the form, csrf
+// validation included
+func limitBody(n int64, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, n)
+		next(w, r)
+	}
+}
+
+// rateLimitAuditEvery stops a client hammering the server from also flooding
+// the audit log, only one rate limit event is recorded per ip in this window
+const rateLimitAuditEvery = time.Minute
+
+func newIPRateLimiterByIP(auditLog *audit.Log, rn *render.Renderer, logger *slog.Logger, every time.Duration, burst int) middleware {
 	ipLimits := sync.Map{}
+	lastAudited := sync.Map{}
 	return func(next http.HandlerFunc) http.HandlerFunc {
 		return func(w http.ResponseWriter, r *http.Request) {
-			ip := r.RemoteAddr
-			parts := strings.Split(ip, ":")
-			ip = parts[0]
+			ip := proxy.ClientIP(r)
 
 			value, ok := ipLimits.Load(ip)
 			if !ok {
@@ -224,13 +488,20 @@ func newIPRateLimiterByIP(logger *slog.Logger, every time.Duration, burst int) m
 			}
 			limiter, ok := value.(*rate.Limiter)
 			if !ok {
-				logger.Error("Failed to cast rate.Limiter")
-				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				rn.Error(r.Context(), w, r, apperr.Internal(errors.New("failed to cast rate.Limiter")))
 				return
 			}
 			if !limiter.Allow() {
 				logger.Warn("Rate limit exceeded", "ip", ip)
-				http.Error(w, "Too many requests", http.StatusTooManyRequests)
+				rn.Error(r.Context(), w, r, apperr.New(http.StatusTooManyRequests, "Too many requests"))
+				last, ok := lastAudited.Load(ip)
+				if !ok || time.Since(last.(time.Time)) > rateLimitAuditEvery {
+					lastAudited.Store(ip, time.Now())
+					e := audit.Event{Event: audit.RateLimited, Detail: r.URL.Path}
+					if err := auditLog.Record(r.Context(), r, e); err != nil {
+						logger.Error("Failed to record rate limit event", "error", err)
+					}
+				}
 				return
 			}
 			next(w, r)
@@ -0,0 +1,31 @@
+package markdown
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+)
+
+var md = goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+// policy is the allowlist of elements and attributes that survive rendering.
+// goldmark already drops raw html but the sanitiser is what we actually trust.
+var policy = func() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	p.RequireNoReferrerOnLinks(true)
+	p.AddTargetBlankToFullyQualifiedLinks(true)
+	return p
+}()
+
+// Render turns user supplied markdown into html that is safe to put on a page
+func Render(src string) (template.HTML, error) {
+	var buf bytes.Buffer
+	if err := md.Convert([]byte(src), &buf); err != nil {
+		return "", fmt.Errorf("failed to render markdown: %w", err)
+	}
+	return template.HTML(policy.SanitizeBytes(buf.Bytes())), nil
+}
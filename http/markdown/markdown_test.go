@@ -0,0 +1,95 @@
+package markdown
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		want    []string
+		notWant []string
+	}{
+		{
+			name: "formatting",
+			src:  "# Title\n\n*em* **strong** `code`\n\n- item",
+			want: []string{"<h1", "<em>em</em>", "<strong>strong</strong>", "<code>code</code>", "<li>item</li>"},
+		},
+		{
+			name: "gfm table and strikethrough",
+			src:  "| a | b |\n|---|---|\n| 1 | 2 |\n\n~~gone~~",
+			want: []string{"<table>", "<td>1</td>", "<del>gone</del>"},
+		},
+		{
+			name:    "script tag",
+			src:     "hi <script>alert(1)</script>",
+			notWant: []string{"<script", "alert(1)</script>"},
+		},
+		{
+			name:    "event handler attribute",
+			src:     `<img src="x" onerror="alert(1)">`,
+			notWant: []string{"onerror"},
+		},
+		{
+			name:    "iframe",
+			src:     `<iframe src="https://evil.example"></iframe>`,
+			notWant: []string{"<iframe"},
+		},
+		{
+			name:    "javascript link",
+			src:     "[click](javascript:alert(1))",
+			notWant: []string{"javascript:"},
+		},
+		{
+			name:    "javascript link with entities",
+			src:     "[click](jav&#x09;ascript:alert(1))",
+			notWant: []string{"ascript:alert"},
+		},
+		{
+			name:    "data uri image",
+			src:     "![x](data:text/html;base64,PHNjcmlwdD5hbGVydCgxKTwvc2NyaXB0Pg==)",
+			notWant: []string{"data:text/html"},
+		},
+		{
+			name:    "style attribute",
+			src:     `<p style="position:fixed">x</p>`,
+			notWant: []string{"style="},
+		},
+		{
+			name:    "raw html in an autolink",
+			src:     `<https://example.com/"><script>alert(1)</script>>`,
+			notWant: []string{"<script"},
+		},
+		{
+			name: "external link",
+			src:  "[site](https://example.com)",
+			want: []string{`href="https://example.com"`, `rel="nofollow noreferrer noopener"`, `target="_blank"`},
+		},
+		{
+			name:    "relative link stays in the tab",
+			src:     "[post](/posts/1)",
+			want:    []string{`href="/posts/1"`},
+			notWant: []string{"_blank"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			html, err := Render(tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(string(html), want) {
+					t.Errorf("Render() = %s, want it to contain %s", html, want)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(string(html), notWant) {
+					t.Errorf("Render() = %s, want it not to contain %s", html, notWant)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package page
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/somethingsoftware/violet-web/http/apperr"
+	"github.com/somethingsoftware/violet-web/http/config"
+	"github.com/somethingsoftware/violet-web/http/csrf"
+	"github.com/somethingsoftware/violet-web/http/render"
+	"github.com/somethingsoftware/violet-web/http/session"
+)
+
+// DeleteAccount asks the logged in user to confirm their password before
+// their account is deleted
+func DeleteAccount(sc *session.Cache, csrfProvider *csrf.Provider, rn *render.Renderer, logger *slog.Logger, cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		request_id := uuid.New().String()
+		ctx = context.WithValue(ctx, "request_id", request_id)
+		session, err := sc.GetSession(r)
+		if err != nil {
+			rn.Error(ctx, w, r, apperr.Unauthorized().With(err))
+			return
+		}
+		logger.DebugContext(ctx, "Delete account page loaded session", "username", session.Username)
+
+		token, err := csrfProvider.MakeRequestToken(r)
+		if err != nil {
+			rn.Error(ctx, w, r, apperr.Internal(fmt.Errorf("failed to make csrf token: %w", err)))
+			return
+		}
+
+		type DeleteAccountPage struct {
+			Username  string
+			CSRFToken string
+			GraceDays int
+		}
+		rn.Render(w, r, "delete-account.gotmpl", DeleteAccountPage{
+			Username:  session.Username,
+			CSRFToken: token,
+			GraceDays: int(cfg.DeletionGrace.Hours() / 24),
+		})
+	}
+}
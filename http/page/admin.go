@@ -0,0 +1,143 @@
+package page
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/somethingsoftware/violet-web/http/apperr"
+	"github.com/somethingsoftware/violet-web/http/csrf"
+	"github.com/somethingsoftware/violet-web/http/render"
+	"github.com/somethingsoftware/violet-web/http/session"
+)
+
+const adminUsersPerPage = 25
+
+type adminUserRow struct {
+	ID            uint64
+	Username      string
+	Email         string
+	EmailVerified bool
+	Role          string
+	Disabled      bool
+	CreatedAt     time.Time
+	LastLoginAt   sql.NullTime
+}
+
+func AdminUsers(db *sql.DB, rn *render.Renderer, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		request_id := uuid.New().String()
+		ctx = context.WithValue(ctx, "request_id", request_id)
+		logger.DebugContext(ctx, "Admin users page loaded")
+
+		search := r.FormValue("q")
+		pageNum := pageParam(r)
+
+		// fetch one extra row to know if there is a next page
+		query := `SELECT id, username, email, email_verified, role, disabled, created_at, last_login_at
+			FROM user WHERE username LIKE ? OR email LIKE ?
+			ORDER BY id LIMIT ? OFFSET ?;`
+		like := "%" + search + "%"
+		rows, err := db.Query(query, like, like, adminUsersPerPage+1, (pageNum-1)*adminUsersPerPage)
+		if err != nil {
+			rn.Error(ctx, w, r, apperr.Internal(fmt.Errorf("failed to query users: %w", err)))
+			return
+		}
+		defer rows.Close()
+
+		var users []adminUserRow
+		for rows.Next() {
+			var u adminUserRow
+			if err := rows.Scan(&u.ID, &u.Username, &u.Email, &u.EmailVerified,
+				&u.Role, &u.Disabled, &u.CreatedAt, &u.LastLoginAt); err != nil {
+				rn.Error(ctx, w, r, apperr.Internal(fmt.Errorf("failed to scan user: %w", err)))
+				return
+			}
+			users = append(users, u)
+		}
+		if err := rows.Err(); err != nil {
+			rn.Error(ctx, w, r, apperr.Internal(fmt.Errorf("failed to iterate users: %w", err)))
+			return
+		}
+
+		hasNext := len(users) > adminUsersPerPage
+		if hasNext {
+			users = users[:adminUsersPerPage]
+		}
+
+		type AdminUsersPage struct {
+			Search   string
+			Users    []adminUserRow
+			Page     int
+			PrevPage int
+			NextPage int
+		}
+		p := AdminUsersPage{Search: search, Users: users, Page: pageNum}
+		if pageNum > 1 {
+			p.PrevPage = pageNum - 1
+		}
+		if hasNext {
+			p.NextPage = pageNum + 1
+		}
+		rn.Render(w, r, "admin-users.gotmpl", p)
+	}
+}
+
+func AdminUser(db *sql.DB, sc *session.Cache, csrfProvider *csrf.Provider, rn *render.Renderer, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		request_id := uuid.New().String()
+		ctx = context.WithValue(ctx, "request_id", request_id)
+		logger.DebugContext(ctx, "Admin user page loaded")
+
+		userID, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+		if err != nil {
+			rn.Error(ctx, w, r, apperr.NotFound())
+			return
+		}
+
+		query := `SELECT id, username, email, email_verified, role, disabled, created_at, last_login_at
+			FROM user WHERE id = ?;`
+		var u adminUserRow
+		err = db.QueryRow(query, userID).Scan(&u.ID, &u.Username, &u.Email, &u.EmailVerified,
+			&u.Role, &u.Disabled, &u.CreatedAt, &u.LastLoginAt)
+		if errors.Is(err, sql.ErrNoRows) {
+			rn.Error(ctx, w, r, apperr.NotFound())
+			return
+		} else if err != nil {
+			rn.Error(ctx, w, r, apperr.Internal(fmt.Errorf("failed to get user: %w", err)))
+			return
+		}
+
+		// every form needs its own token because they are single use
+		forms := []string{"toggle", "reset", "revoke", "delete"}
+		tokens := make(map[string]string, len(forms))
+		for _, form := range forms {
+			token, err := csrfProvider.MakeRequestToken(r)
+			if err != nil {
+				rn.Error(ctx, w, r, apperr.Internal(fmt.Errorf("failed to make csrf token: %w", err)))
+				return
+			}
+			tokens[form] = token
+		}
+
+		type AdminUserPage struct {
+			User       adminUserRow
+			Sessions   int
+			CSRFTokens map[string]string
+		}
+		p := AdminUserPage{
+			User:       u,
+			Sessions:   len(sc.UserSessions(u.ID)),
+			CSRFTokens: tokens,
+		}
+		rn.Render(w, r, "admin-user.gotmpl", p)
+	}
+}
@@ -0,0 +1,49 @@
+package page
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/somethingsoftware/violet-web/http/apperr"
+	"github.com/somethingsoftware/violet-web/http/avatar"
+	"github.com/somethingsoftware/violet-web/http/render"
+	"github.com/somethingsoftware/violet-web/http/storage"
+)
+
+// Avatar serves stored avatar thumbnails. The file names contain a hash of
+// the upload so they never change and can be cached for a long time.
+func Avatar(store storage.Store, rn *render.Renderer, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		key, ok := avatar.Key(name)
+		if !ok {
+			rn.Error(r.Context(), w, r, apperr.NotFound())
+			return
+		}
+
+		etag := `"` + name + `"`
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		data, _, err := store.Get(r.Context(), key)
+		if errors.Is(err, storage.ErrNotFound) {
+			rn.Error(r.Context(), w, r, apperr.NotFound())
+			return
+		} else if err != nil {
+			rn.Error(r.Context(), w, r, apperr.Internal(fmt.Errorf("failed to get avatar %s: %w", key, err)))
+			return
+		}
+
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		w.Header().Set("ETag", etag)
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		if _, err := w.Write(data); err != nil {
+			logger.Error("Failed to write avatar", "error", err)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package page
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/somethingsoftware/violet-web/http/apperr"
+	"github.com/somethingsoftware/violet-web/http/comment"
+	"github.com/somethingsoftware/violet-web/http/csrf"
+	"github.com/somethingsoftware/violet-web/http/render"
+)
+
+// DeleteComment asks for confirmation before a comment is deleted, the action
+// checks who is allowed to
+func DeleteComment(db *sql.DB, csrfProvider *csrf.Provider, rn *render.Renderer, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		request_id := uuid.New().String()
+		ctx = context.WithValue(ctx, "request_id", request_id)
+		logger.DebugContext(ctx, "Delete comment page loaded")
+
+		id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+		if err != nil {
+			rn.Error(ctx, w, r, apperr.NotFound())
+			return
+		}
+		c, err := comment.Get(db, id)
+		if errors.Is(err, comment.ErrNotFound) {
+			rn.Error(ctx, w, r, apperr.NotFound())
+			return
+		} else if err != nil {
+			rn.Error(ctx, w, r, apperr.Internal(fmt.Errorf("failed to get comment: %w", err)))
+			return
+		}
+
+		token, err := csrfProvider.MakeRequestToken(r)
+		if err != nil {
+			rn.Error(ctx, w, r, apperr.Internal(fmt.Errorf("failed to make csrf token: %w", err)))
+			return
+		}
+
+		type DeleteCommentPage struct {
+			Comment   *comment.Comment
+			CSRFToken string
+		}
+		rn.Render(w, r, "delete-comment.gotmpl", DeleteCommentPage{
+			Comment:   c,
+			CSRFToken: token,
+		})
+	}
+}
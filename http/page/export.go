@@ -0,0 +1,87 @@
+package page
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/somethingsoftware/violet-web/http/apperr"
+	"github.com/somethingsoftware/violet-web/http/audit"
+	"github.com/somethingsoftware/violet-web/http/csrf"
+	"github.com/somethingsoftware/violet-web/http/export"
+	"github.com/somethingsoftware/violet-web/http/render"
+	"github.com/somethingsoftware/violet-web/http/session"
+)
+
+// Export lists the logged in user's data exports and lets them ask for a new one
+func Export(sc *session.Cache, csrfProvider *csrf.Provider, worker *export.Worker, rn *render.Renderer, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		request_id := uuid.New().String()
+		ctx = context.WithValue(ctx, "request_id", request_id)
+		session, err := sc.GetSession(r)
+		if err != nil {
+			rn.Error(ctx, w, r, apperr.Unauthorized().With(err))
+			return
+		}
+		logger.DebugContext(ctx, "Export page loaded session", "username", session.Username)
+
+		exports, err := worker.List(session.UserID)
+		if err != nil {
+			rn.Error(ctx, w, r, apperr.Internal(fmt.Errorf("failed to list data exports: %w", err)))
+			return
+		}
+		token, err := csrfProvider.MakeRequestToken(r)
+		if err != nil {
+			rn.Error(ctx, w, r, apperr.Internal(fmt.Errorf("failed to make csrf token: %w", err)))
+			return
+		}
+
+		type ExportPage struct {
+			Exports   []export.Export
+			CSRFToken string
+		}
+		rn.Render(w, r, "export.gotmpl", ExportPage{
+			Exports:   exports,
+			CSRFToken: token,
+		})
+	}
+}
+
+// DownloadExport sends a finished data export to the user who asked for it
+func DownloadExport(sc *session.Cache, worker *export.Worker, auditLog *audit.Log, rn *render.Renderer, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		request_id := uuid.New().String()
+		ctx = context.WithValue(ctx, "request_id", request_id)
+		session, err := sc.GetSession(r)
+		if err != nil {
+			rn.Error(ctx, w, r, apperr.Unauthorized().With(err))
+			return
+		}
+
+		archive, err := worker.Archive(session.UserID, r.PathValue("token"))
+		if errors.Is(err, export.ErrNotFound) {
+			rn.Error(ctx, w, r, apperr.NotFound())
+			return
+		} else if err != nil {
+			rn.Error(ctx, w, r, apperr.Internal(fmt.Errorf("failed to get data export: %w", err)))
+			return
+		}
+
+		e := audit.Event{UserID: session.UserID, ActorID: session.UserID, Event: audit.DataExportDownloaded}
+		if err := auditLog.Record(ctx, r, e); err != nil {
+			logger.ErrorContext(ctx, "Failed to record audit event", "error", err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", `attachment; filename="violet-web-`+session.Username+`.json"`)
+		w.Header().Set("Cache-Control", "no-store")
+		if _, err := w.Write(archive); err != nil {
+			logger.ErrorContext(ctx, "Failed to write data export", "error", err)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package page
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/somethingsoftware/violet-web/http/apperr"
+	"github.com/somethingsoftware/violet-web/http/feed"
+	"github.com/somethingsoftware/violet-web/http/post"
+	"github.com/somethingsoftware/violet-web/http/render"
+	"github.com/somethingsoftware/violet-web/http/siteurl"
+)
+
+const (
+	FeedRSS  = "rss"
+	FeedAtom = "atom"
+)
+
+const feedSize = 20
+
+// Feed serves the newest posts on the site as RSS or Atom. Feed readers are
+// anonymous so like UserFeed it only has the posts of users who made theirs
+// public, and none from disabled accounts or accounts pending deletion.
+func Feed(db *sql.DB, site *siteurl.Site, rn *render.Renderer, logger *slog.Logger, format string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		request_id := uuid.New().String()
+		ctx = context.WithValue(ctx, "request_id", request_id)
+		logger.DebugContext(ctx, "Feed loaded", "format", format)
+
+		posts, err := post.List(db, false, feedSize, 0)
+		if err != nil {
+			rn.Error(ctx, w, r, apperr.Internal(fmt.Errorf("failed to list posts: %w", err)))
+			return
+		}
+		base := site.Base(r)
+		serveFeed(ctx, w, r, rn, logger, format, base, feed.Feed{
+			Title:       "Violet posts",
+			Description: "The newest posts on Violet",
+			Link:        base + "/posts",
+			Self:        base + r.URL.Path,
+		}, posts)
+	}
+}
+
+// UserFeed is Feed for a single user's posts. Feed readers are anonymous so
+// it is only there if the user made their posts public.
+func UserFeed(db *sql.DB, site *siteurl.Site, rn *render.Renderer, logger *slog.Logger, format string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		request_id := uuid.New().String()
+		ctx = context.WithValue(ctx, "request_id", request_id)
+		logger.DebugContext(ctx, "User feed loaded", "format", format)
+
+		query := selectProfile + "WHERE username = ? AND disabled = FALSE AND deletion_requested_at IS NULL;"
+		p, err := getProfile(db, query, r.PathValue("username"))
+		if errors.Is(err, sql.ErrNoRows) || (err == nil && !p.PublicPosts) {
+			rn.Error(ctx, w, r, apperr.NotFound())
+			return
+		} else if err != nil {
+			rn.Error(ctx, w, r, apperr.Internal(fmt.Errorf("failed to get profile: %w", err)))
+			return
+		}
+
+		posts, err := post.ListByUser(db, p.ID, false, feedSize, 0)
+		if err != nil {
+			rn.Error(ctx, w, r, apperr.Internal(fmt.Errorf("failed to list posts: %w", err)))
+			return
+		}
+		base := site.Base(r)
+		serveFeed(ctx, w, r, rn, logger, format, base, feed.Feed{
+			Title:       p.Username + " on Violet",
+			Description: "The newest posts by " + p.Username,
+			Link:        base + "/u/" + p.Username,
+			Self:        base + r.URL.Path,
+		}, posts)
+	}
+}
+
+// serveFeed fills in the feed items and answers conditional requests before
+// rendering anything, so polling an unchanged feed is cheap
+func serveFeed(ctx context.Context, w http.ResponseWriter, r *http.Request, rn *render.Renderer, logger *slog.Logger,
+	format, base string, f feed.Feed, posts []post.Post) {
+	if len(posts) > feedSize {
+		posts = posts[:feedSize]
+	}
+
+	// the etag covers which posts are in the feed as well as when they
+	// changed, deleting the newest post doesn't move the last modified time
+	// forward
+	hash := sha256.New()
+	fmt.Fprint(hash, format, f.Self)
+	for _, p := range posts {
+		updated := p.CreatedAt
+		if p.UpdatedAt.Valid {
+			updated = p.UpdatedAt.Time
+		}
+		if updated.After(f.Updated) {
+			f.Updated = updated
+		}
+		fmt.Fprint(hash, p.ID, updated.Unix())
+		f.Items = append(f.Items, feed.Item{
+			Title:     p.Title,
+			Link:      base + "/posts/" + strconv.FormatUint(p.ID, 10),
+			Author:    p.Username,
+			Content:   string(p.BodyHTML),
+			Published: p.CreatedAt,
+			Updated:   updated,
+		})
+	}
+	etag := `"` + hex.EncodeToString(hash.Sum(nil))[:32] + `"`
+	lastModified := f.Updated.UTC().Truncate(time.Second)
+
+	w.Header().Set("ETag", etag)
+	if !lastModified.IsZero() {
+		w.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
+	}
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	if notModified(r, etag, lastModified) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	var body []byte
+	var err error
+	if format == FeedAtom {
+		w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+		body, err = feed.Atom(f)
+	} else {
+		w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+		body, err = feed.RSS(f)
+	}
+	if err != nil {
+		rn.Error(ctx, w, r, apperr.Internal(fmt.Errorf("failed to render feed: %w", err)))
+		return
+	}
+	if _, err := w.Write(body); err != nil {
+		logger.ErrorContext(ctx, "Failed to write feed", "error", err)
+	}
+}
+
+// notModified follows RFC 9110, If-None-Match wins over If-Modified-Since
+// when a client sends both
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, tag := range strings.Split(inm, ",") {
+			if tag = strings.TrimSpace(tag); tag == etag || tag == "W/"+etag || tag == "*" {
+				return true
+			}
+		}
+		return false
+	}
+	ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil || lastModified.IsZero() {
+		return false
+	}
+	return !lastModified.After(ims)
+}
@@ -0,0 +1,126 @@
+package page
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/somethingsoftware/violet-web/http/siteurl"
+)
+
+func newTestSite(t *testing.T) *siteurl.Site {
+	t.Helper()
+	site, err := siteurl.New("https://violet.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return site
+}
+
+func TestFeedConditionalGet(t *testing.T) {
+	db := openTestDB(t)
+	handler := Feed(db, newTestSite(t), newTestRenderer(t), slog.New(slog.NewTextHandler(io.Discard, nil)), FeedRSS)
+	get := func(header http.Header) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/feed.xml", nil)
+		for key, values := range header {
+			r.Header[key] = values
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w
+	}
+
+	first := get(nil)
+	if first.Code != http.StatusOK || !strings.Contains(first.Body.String(), "bob&#39;s post") {
+		t.Fatalf("first request = %d %s", first.Code, first.Body)
+	}
+	if !strings.Contains(first.Body.String(), "<link>https://violet.example/posts</link>") {
+		t.Errorf("the feed doesn't link to the public url: %s", first.Body)
+	}
+	if strings.Contains(first.Body.String(), "alice&#39;s post") {
+		t.Error("the site feed has the posts of alice, who keeps hers private")
+	}
+	etag := first.Header().Get("ETag")
+	lastModified := first.Header().Get("Last-Modified")
+	if etag == "" || lastModified == "" {
+		t.Fatalf("no validators in %v", first.Header())
+	}
+
+	tests := []struct {
+		name   string
+		header http.Header
+		status int
+	}{
+		{name: "matching etag", header: http.Header{"If-None-Match": {etag}}, status: http.StatusNotModified},
+		{name: "weak etag in a list", header: http.Header{"If-None-Match": {`"other", W/` + etag}}, status: http.StatusNotModified},
+		{name: "star", header: http.Header{"If-None-Match": {"*"}}, status: http.StatusNotModified},
+		{name: "other etag", header: http.Header{"If-None-Match": {`"other"`}}, status: http.StatusOK},
+		{name: "not modified since", header: http.Header{"If-Modified-Since": {lastModified}}, status: http.StatusNotModified},
+		{
+			name:   "modified since",
+			header: http.Header{"If-Modified-Since": {time.Unix(0, 0).UTC().Format(http.TimeFormat)}},
+			status: http.StatusOK,
+		},
+		{
+			name:   "etag wins over the date",
+			header: http.Header{"If-None-Match": {`"other"`}, "If-Modified-Since": {lastModified}},
+			status: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := get(tt.header)
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+			if tt.status == http.StatusNotModified && w.Body.Len() > 0 {
+				t.Error("a 304 has a body")
+			}
+		})
+	}
+
+	// deleting the newest post leaves the last modified time where it was, the
+	// etag still has to change
+	if _, err := db.Exec("DELETE FROM post WHERE id = (SELECT MAX(id) FROM post);"); err != nil {
+		t.Fatal(err)
+	}
+	if w := get(http.Header{"If-None-Match": {etag}}); w.Code != http.StatusOK || w.Header().Get("ETag") == etag {
+		t.Errorf("after deleting a post = %d with etag %s, want a new etag", w.Code, w.Header().Get("ETag"))
+	}
+}
+
+func TestUserFeed(t *testing.T) {
+	db := openTestDB(t)
+	site := newTestSite(t)
+	rn := newTestRenderer(t)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	tests := []struct {
+		username string
+		format   string
+		status   int
+		content  string
+	}{
+		{username: "bob", format: FeedRSS, status: http.StatusOK, content: "application/rss+xml"},
+		{username: "bob", format: FeedAtom, status: http.StatusOK, content: "application/atom+xml"},
+		// alice's posts are only for logged in users and feed readers aren't
+		{username: "alice", format: FeedRSS, status: http.StatusNotFound},
+		{username: "carol", format: FeedRSS, status: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/u/"+tt.username+"/feed", nil)
+		r.SetPathValue("username", tt.username)
+		w := httptest.NewRecorder()
+		UserFeed(db, site, rn, logger, tt.format)(w, r)
+		if w.Code != tt.status || !strings.HasPrefix(w.Header().Get("Content-Type"), tt.content) {
+			t.Errorf("%s %s feed = %d %s, want %d %s", tt.username, tt.format, w.Code,
+				w.Header().Get("Content-Type"), tt.status, tt.content)
+		}
+		if tt.status == http.StatusOK && strings.Contains(w.Body.String(), "alice") {
+			t.Errorf("%s's feed has alice's posts", tt.username)
+		}
+	}
+}
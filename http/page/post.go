@@ -0,0 +1,209 @@
+package page
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/somethingsoftware/violet-web/http/account"
+	"github.com/somethingsoftware/violet-web/http/apperr"
+	"github.com/somethingsoftware/violet-web/http/comment"
+	"github.com/somethingsoftware/violet-web/http/csrf"
+	"github.com/somethingsoftware/violet-web/http/post"
+	"github.com/somethingsoftware/violet-web/http/render"
+	"github.com/somethingsoftware/violet-web/http/session"
+)
+
+const postsPerPage = 20
+
+// pageParam reads the 1 based page number from the query string
+func pageParam(r *http.Request) int {
+	pageNum, err := strconv.Atoi(r.FormValue("page"))
+	if err != nil || pageNum < 1 {
+		return 1
+	}
+	return pageNum
+}
+
+func Posts(db *sql.DB, sc *session.Cache, rn *render.Renderer, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		request_id := uuid.New().String()
+		ctx = context.WithValue(ctx, "request_id", request_id)
+		logger.DebugContext(ctx, "Posts page loaded")
+
+		_, err := sc.GetSession(r)
+		loggedIn := err == nil
+		pageNum := pageParam(r)
+		posts, err := post.List(db, loggedIn, postsPerPage, (pageNum-1)*postsPerPage)
+		if err != nil {
+			rn.Error(ctx, w, r, apperr.Internal(fmt.Errorf("failed to list posts: %w", err)))
+			return
+		}
+
+		type PostsPage struct {
+			Posts    []post.Post
+			Page     int
+			PrevPage int
+			NextPage int
+		}
+		p := PostsPage{Posts: posts, Page: pageNum}
+		if pageNum > 1 {
+			p.PrevPage = pageNum - 1
+		}
+		if len(posts) > postsPerPage {
+			p.Posts = posts[:postsPerPage]
+			p.NextPage = pageNum + 1
+		}
+		rn.Render(w, r, "posts.gotmpl", p)
+	}
+}
+
+func Post(db *sql.DB, sc *session.Cache, csrfProvider *csrf.Provider, rn *render.Renderer, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		request_id := uuid.New().String()
+		ctx = context.WithValue(ctx, "request_id", request_id)
+		logger.DebugContext(ctx, "Post page loaded")
+
+		p, ok := loadPost(ctx, w, r, db, sc, rn, logger)
+		if !ok {
+			return
+		}
+
+		comments, err := comment.ForPost(db, p.ID)
+		if err != nil {
+			rn.Error(ctx, w, r, apperr.Internal(fmt.Errorf("failed to get comments: %w", err)))
+			return
+		}
+
+		type PostPage struct {
+			Post             post.Post
+			Comments         []*comment.Comment
+			CommentCount     int
+			LoggedIn         bool
+			IsOwner          bool
+			CSRFToken        string
+			CommentCSRFToken string
+			ReplyTo          *comment.Comment
+		}
+		data := PostPage{Post: p, Comments: comments}
+		comment.Walk(comments, func(c *comment.Comment) {
+			if !c.Deleted {
+				data.CommentCount++
+			}
+		})
+		// anyone can read a post, only the owner gets the edit and delete controls
+		// and only logged in users can comment
+		if session, err := sc.GetSession(r); err == nil {
+			data.LoggedIn = true
+			data.CommentCSRFToken, err = csrfProvider.MakeRequestToken(r)
+			if err != nil {
+				rn.Error(ctx, w, r, apperr.Internal(fmt.Errorf("failed to make csrf token: %w", err)))
+				return
+			}
+			if session.UserID == p.UserID {
+				data.IsOwner = true
+				data.CSRFToken, err = csrfProvider.MakeRequestToken(r)
+				if err != nil {
+					rn.Error(ctx, w, r, apperr.Internal(fmt.Errorf("failed to make csrf token: %w", err)))
+					return
+				}
+			}
+
+			isAdmin, err := account.IsAdmin(db, session.UserID)
+			if err != nil {
+				logger.ErrorContext(ctx, "Failed to check admin", "error", err)
+			}
+			replyID, _ := strconv.ParseUint(r.FormValue("reply"), 10, 64)
+			comment.Walk(comments, func(c *comment.Comment) {
+				c.CanDelete = !c.Deleted && (isAdmin || c.UserID == session.UserID)
+				if c.ID == replyID {
+					data.ReplyTo = c
+				}
+			})
+		}
+		rn.Render(w, r, "post.gotmpl", data)
+	}
+}
+
+type postForm struct {
+	Heading   string
+	Action    string
+	Title     string
+	Body      string
+	CSRFToken string
+}
+
+func NewPost(csrfProvider *csrf.Provider, rn *render.Renderer, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, err := csrfProvider.MakeRequestToken(r)
+		if err != nil {
+			rn.Error(r.Context(), w, r, apperr.Internal(fmt.Errorf("failed to make csrf token: %w", err)))
+			return
+		}
+		rn.Render(w, r, "post-form.gotmpl", postForm{
+			Heading:   "New Post",
+			Action:    "/posts",
+			CSRFToken: token,
+		})
+	}
+}
+
+func EditPost(db *sql.DB, sc *session.Cache, csrfProvider *csrf.Provider, rn *render.Renderer, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		request_id := uuid.New().String()
+		ctx = context.WithValue(ctx, "request_id", request_id)
+		logger.DebugContext(ctx, "Edit post page loaded")
+
+		p, ok := loadPost(ctx, w, r, db, sc, rn, logger)
+		if !ok {
+			return
+		}
+		session, err := sc.GetSession(r)
+		if err != nil || session.UserID != p.UserID {
+			rn.Error(ctx, w, r, apperr.Forbidden())
+			return
+		}
+
+		token, err := csrfProvider.MakeRequestToken(r)
+		if err != nil {
+			rn.Error(ctx, w, r, apperr.Internal(fmt.Errorf("failed to make csrf token: %w", err)))
+			return
+		}
+		rn.Render(w, r, "post-form.gotmpl", postForm{
+			Heading:   "Edit Post",
+			Action:    "/posts/" + strconv.FormatUint(p.ID, 10) + "/edit",
+			Title:     p.Title,
+			Body:      p.Body,
+			CSRFToken: token,
+		})
+	}
+}
+
+// loadPost gets the post named by the {id} path value if the visitor may read
+// it, it writes the error response itself and returns false if there isn't one
+func loadPost(ctx context.Context, w http.ResponseWriter, r *http.Request,
+	db *sql.DB, sc *session.Cache, rn *render.Renderer, logger *slog.Logger) (post.Post, bool) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		rn.Error(ctx, w, r, apperr.NotFound())
+		return post.Post{}, false
+	}
+	_, err = sc.GetSession(r)
+	p, err := post.Get(db, id, err == nil)
+	if errors.Is(err, post.ErrNotFound) {
+		rn.Error(ctx, w, r, apperr.NotFound())
+		return post.Post{}, false
+	} else if err != nil {
+		rn.Error(ctx, w, r, apperr.Internal(fmt.Errorf("failed to get post: %w", err)))
+		return post.Post{}, false
+	}
+	return p, true
+}
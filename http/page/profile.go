@@ -0,0 +1,149 @@
+package page
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/somethingsoftware/violet-web/http/apperr"
+	"github.com/somethingsoftware/violet-web/http/avatar"
+	"github.com/somethingsoftware/violet-web/http/csrf"
+	"github.com/somethingsoftware/violet-web/http/i18n"
+	"github.com/somethingsoftware/violet-web/http/post"
+	"github.com/somethingsoftware/violet-web/http/render"
+	"github.com/somethingsoftware/violet-web/http/session"
+)
+
+type profile struct {
+	ID          uint64
+	Username    string
+	Bio         string
+	Avatar      string
+	PublicBio   bool
+	PublicPosts bool
+	Locale      string
+}
+
+func getProfile(db *sql.DB, query string, arg any) (profile, error) {
+	var p profile
+	err := db.QueryRow(query, arg).Scan(&p.ID, &p.Username, &p.Bio, &p.Avatar, &p.PublicBio, &p.PublicPosts, &p.Locale)
+	return p, err
+}
+
+const selectProfile = `SELECT id, username, bio, avatar, public_bio, public_posts, locale FROM user `
+
+// Profile is the public page for a user. Anonymous visitors only see the
+// parts the user has made public, anyone logged in sees everything.
+func Profile(db *sql.DB, sc *session.Cache, rn *render.Renderer, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		request_id := uuid.New().String()
+		ctx = context.WithValue(ctx, "request_id", request_id)
+		logger.DebugContext(ctx, "Profile page loaded")
+
+		query := selectProfile + "WHERE username = ? AND disabled = FALSE AND deletion_requested_at IS NULL;"
+		p, err := getProfile(db, query, r.PathValue("username"))
+		if errors.Is(err, sql.ErrNoRows) {
+			rn.Error(ctx, w, r, apperr.NotFound())
+			return
+		} else if err != nil {
+			rn.Error(ctx, w, r, apperr.Internal(fmt.Errorf("failed to get profile: %w", err)))
+			return
+		}
+
+		session, err := sc.GetSession(r)
+		loggedIn := err == nil
+
+		type ProfilePage struct {
+			Profile   profile
+			IsOwner   bool
+			ShowBio   bool
+			ShowPosts bool
+			Posts     []post.Post
+			Page      int
+			PrevPage  int
+			NextPage  int
+		}
+		data := ProfilePage{
+			Profile:   p,
+			IsOwner:   loggedIn && session.UserID == p.ID,
+			ShowBio:   loggedIn || p.PublicBio,
+			ShowPosts: loggedIn || p.PublicPosts,
+			Page:      pageParam(r),
+		}
+		if data.ShowPosts {
+			posts, err := post.ListByUser(db, p.ID, loggedIn, postsPerPage, (data.Page-1)*postsPerPage)
+			if err != nil {
+				rn.Error(ctx, w, r, apperr.Internal(fmt.Errorf("failed to list posts: %w", err)))
+				return
+			}
+			data.Posts = posts
+			if data.Page > 1 {
+				data.PrevPage = data.Page - 1
+			}
+			if len(posts) > postsPerPage {
+				data.Posts = posts[:postsPerPage]
+				data.NextPage = data.Page + 1
+			}
+		}
+		rn.Render(w, r, "profile.gotmpl", data)
+	}
+}
+
+// EditProfile is the form for the logged in user's bio, avatar and privacy
+func EditProfile(db *sql.DB, sc *session.Cache, csrfProvider *csrf.Provider, rn *render.Renderer, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		request_id := uuid.New().String()
+		ctx = context.WithValue(ctx, "request_id", request_id)
+		session, err := sc.GetSession(r)
+		if err != nil {
+			rn.Error(ctx, w, r, apperr.Unauthorized().With(err))
+			return
+		}
+		logger.DebugContext(ctx, "Edit profile page loaded session", "username", session.Username)
+
+		p, err := getProfile(db, selectProfile+"WHERE id = ?;", session.UserID)
+		if err != nil {
+			rn.Error(ctx, w, r, apperr.Internal(fmt.Errorf("failed to get profile: %w", err)))
+			return
+		}
+		// every form needs its own token because they are single use
+		forms := []string{"profile", "avatar", "remove_avatar"}
+		tokens := make(map[string]string, len(forms))
+		for _, form := range forms {
+			token, err := csrfProvider.MakeRequestToken(r)
+			if err != nil {
+				rn.Error(ctx, w, r, apperr.Internal(fmt.Errorf("failed to make csrf token: %w", err)))
+				return
+			}
+			tokens[form] = token
+		}
+
+		type localeOption struct {
+			Code string
+			Name string
+		}
+		var locales []localeOption
+		for _, code := range i18n.Supported() {
+			locales = append(locales, localeOption{Code: code, Name: i18n.Names[code]})
+		}
+
+		type EditProfilePage struct {
+			Profile        profile
+			CSRFTokens     map[string]string
+			MaxAvatarBytes int
+			Locales        []localeOption
+		}
+		rn.Render(w, r, "profile-form.gotmpl", EditProfilePage{
+			Profile:        p,
+			CSRFTokens:     tokens,
+			MaxAvatarBytes: avatar.MaxUploadBytes,
+			Locales:        locales,
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package page
+
+import (
+	"database/sql"
+	"html/template"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	_ "github.com/glebarez/go-sqlite"
+	"github.com/somethingsoftware/violet-web/http/render"
+	"github.com/somethingsoftware/violet-web/http/session"
+	"github.com/somethingsoftware/violet-web/migrate"
+)
+
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "test.db")+"?_pragma=foreign_keys(1)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := migrate.AutoUP(db, slog.New(slog.NewTextHandler(io.Discard, nil))); err != nil {
+		t.Fatal(err)
+	}
+	queries := []string{
+		`INSERT INTO user (id, username, email, salt, password_hash, bio, public_bio, public_posts)
+			VALUES (1, 'alice', 'alice@example.com', '', '', 'alice''s bio', FALSE, FALSE);`,
+		`INSERT INTO user (id, username, email, salt, password_hash, bio, public_bio, public_posts)
+			VALUES (2, 'bob', 'bob@example.com', '', '', 'bob''s bio', TRUE, TRUE);`,
+		"INSERT INTO post (user_id, title, body) VALUES (1, 'alice''s post', 'body');",
+		"INSERT INTO post (user_id, title, body) VALUES (2, 'bob''s post', 'body');",
+	}
+	for _, query := range queries {
+		if _, err := db.Exec(query); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+func newTestRenderer(t *testing.T) *render.Renderer {
+	t.Helper()
+	rn, err := render.New(os.DirFS("../gotmpl"), false, template.FuncMap{"asset": func(name string) string { return "/static/" + name }}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rn
+}
+
+// loginCookies starts a session for the user and returns its cookies
+func loginCookies(t *testing.T, sc *session.Cache, userID uint64, username string) []*http.Cookie {
+	t.Helper()
+	w := httptest.NewRecorder()
+	if err := sc.StartSession(w, httptest.NewRequest(http.MethodPost, "/login", nil), userID, username); err != nil {
+		t.Fatal(err)
+	}
+	return w.Result().Cookies()
+}
+
+func TestProfile(t *testing.T) {
+	db := openTestDB(t)
+	sc := session.NewCache(db)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	handler := Profile(db, sc, newTestRenderer(t), logger)
+	cookies := loginCookies(t, sc, 2, "bob")
+
+	tests := []struct {
+		name     string
+		username string
+		loggedIn bool
+		status   int
+		want     []string
+		notWant  []string
+	}{
+		{
+			name:     "private profile to a visitor",
+			username: "alice",
+			status:   http.StatusOK,
+			want:     []string{"alice", "Log in to see posts by alice</a>"},
+			notWant:  []string{"alice&#39;s bio", "alice&#39;s post"},
+		},
+		{
+			name:     "private profile to a logged in user",
+			username: "alice",
+			loggedIn: true,
+			status:   http.StatusOK,
+			want:     []string{"alice&#39;s bio", "alice&#39;s post"},
+			notWant:  []string{"Edit Profile"},
+		},
+		{
+			name:     "public profile to a visitor",
+			username: "bob",
+			status:   http.StatusOK,
+			want:     []string{"bob&#39;s bio", "bob&#39;s post"},
+			notWant:  []string{"Edit Profile"},
+		},
+		{
+			name:     "own profile",
+			username: "bob",
+			loggedIn: true,
+			status:   http.StatusOK,
+			want:     []string{"Edit Profile"},
+		},
+		{
+			name:     "unknown user",
+			username: "carol",
+			status:   http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/u/"+tt.username, nil)
+			r.SetPathValue("username", tt.username)
+			if tt.loggedIn {
+				for _, cookie := range cookies {
+					r.AddCookie(cookie)
+				}
+			}
+			w := httptest.NewRecorder()
+			handler(w, r)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+			body := w.Body.String()
+			for _, want := range tt.want {
+				if !strings.Contains(body, want) {
+					t.Errorf("page doesn't contain %q", want)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(body, notWant) {
+					t.Errorf("page contains %q", notWant)
+				}
+			}
+		})
+	}
+}
+
+func TestProfileDisabled(t *testing.T) {
+	db := openTestDB(t)
+	handler := Profile(db, session.NewCache(db), newTestRenderer(t), slog.New(slog.NewTextHandler(io.Discard, nil)))
+	for _, query := range []string{
+		"UPDATE user SET disabled = TRUE WHERE id = 1;",
+		"UPDATE user SET deletion_requested_at = CURRENT_TIMESTAMP WHERE id = 2;",
+	} {
+		if _, err := db.Exec(query); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, username := range []string{"alice", "bob"} {
+		r := httptest.NewRequest(http.MethodGet, "/u/"+username, nil)
+		r.SetPathValue("username", username)
+		w := httptest.NewRecorder()
+		handler(w, r)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("profile of %s = %d, want %d", username, w.Code, http.StatusNotFound)
+		}
+	}
+}
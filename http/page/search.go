@@ -0,0 +1,53 @@
+package page
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/somethingsoftware/violet-web/http/apperr"
+	"github.com/somethingsoftware/violet-web/http/post"
+	"github.com/somethingsoftware/violet-web/http/render"
+	"github.com/somethingsoftware/violet-web/http/session"
+)
+
+const searchResultsPerPage = 20
+
+func Search(db *sql.DB, sc *session.Cache, rn *render.Renderer, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		request_id := uuid.New().String()
+		ctx = context.WithValue(ctx, "request_id", request_id)
+		logger.DebugContext(ctx, "Search page loaded")
+
+		_, err := sc.GetSession(r)
+		loggedIn := err == nil
+		q := r.FormValue("q")
+		pageNum := pageParam(r)
+		results, err := post.Search(db, q, loggedIn, searchResultsPerPage, (pageNum-1)*searchResultsPerPage)
+		if err != nil {
+			rn.Error(ctx, w, r, apperr.Internal(fmt.Errorf("failed to search posts for %q: %w", q, err)))
+			return
+		}
+
+		type SearchPage struct {
+			Query    string
+			Results  []post.SearchResult
+			Page     int
+			PrevPage int
+			NextPage int
+		}
+		p := SearchPage{Query: q, Results: results, Page: pageNum}
+		if pageNum > 1 {
+			p.PrevPage = pageNum - 1
+		}
+		if len(results) > searchResultsPerPage {
+			p.Results = results[:searchResultsPerPage]
+			p.NextPage = pageNum + 1
+		}
+		rn.Render(w, r, "search.gotmpl", p)
+	}
+}
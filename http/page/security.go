@@ -0,0 +1,47 @@
+package page
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/somethingsoftware/violet-web/http/apperr"
+	"github.com/somethingsoftware/violet-web/http/audit"
+	"github.com/somethingsoftware/violet-web/http/render"
+	"github.com/somethingsoftware/violet-web/http/session"
+)
+
+const securityEventsShown = 50
+
+// Security shows the logged in user the recent audit events about their account
+func Security(db *sql.DB, sc *session.Cache, auditLog *audit.Log, rn *render.Renderer, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		request_id := uuid.New().String()
+		ctx = context.WithValue(ctx, "request_id", request_id)
+		session, err := sc.GetSession(r)
+		if err != nil {
+			rn.Error(ctx, w, r, apperr.Unauthorized().With(err))
+			return
+		}
+		logger.DebugContext(ctx, "Security page loaded session", "username", session.Username)
+
+		events, err := auditLog.UserEvents(session.UserID, securityEventsShown)
+		if err != nil {
+			rn.Error(ctx, w, r, apperr.Internal(fmt.Errorf("failed to get audit events: %w", err)))
+			return
+		}
+
+		type SecurityPage struct {
+			Username string
+			Events   []audit.Entry
+		}
+		rn.Render(w, r, "security.gotmpl", SecurityPage{
+			Username: session.Username,
+			Events:   events,
+		})
+	}
+}
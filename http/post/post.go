@@ -0,0 +1,178 @@
+package post
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"html/template"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/somethingsoftware/violet-web/http/markdown"
+)
+
+// CREATE TABLE post (
+// id INTEGER PRIMARY KEY AUTOINCREMENT,
+// user_id INTEGER NOT NULL,
+// title TEXT NOT NULL,
+// body TEXT NOT NULL,
+// created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+// updated_at TIMESTAMP,
+// body_html TEXT NOT NULL DEFAULT '',
+// FOREIGN KEY (user_id) REFERENCES user(id) ON DELETE CASCADE);
+
+const TitleLenMax = 200
+const BodyLenMax = 50000
+
+var ErrNotFound = errors.New("post not found")
+var ErrTitleLength = fmt.Errorf("title must be between 1 and %d characters", TitleLenMax)
+var ErrBodyLength = fmt.Errorf("body must be between 1 and %d characters", BodyLenMax)
+var ErrControlChars = errors.New("title and body can't contain control characters")
+
+// Post.Body is the markdown the user wrote and BodyHTML is the sanitised
+// rendering of it, cached so it isn't rendered on every view
+type Post struct {
+	ID        uint64
+	UserID    uint64
+	Username  string
+	Title     string
+	Body      string
+	BodyHTML  template.HTML
+	CreatedAt time.Time
+	UpdatedAt sql.NullTime
+}
+
+// Validate checks the title and body a user submitted
+func Validate(title, body string) error {
+	if n := utf8.RuneCountInString(title); n < 1 || n > TitleLenMax {
+		return ErrTitleLength
+	}
+	if n := utf8.RuneCountInString(body); n < 1 || n > BodyLenMax {
+		return ErrBodyLength
+	}
+	// they don't show up on a page and search uses two of them to mark
+	// matches, the body can have line breaks and tabs
+	if hasControl(title, "") || hasControl(body, "\t\n\r") {
+		return ErrControlChars
+	}
+	return nil
+}
+
+// hasControl reports whether s has a C0 control character other than those
+// in allowed
+func hasControl(s, allowed string) bool {
+	return strings.ContainsFunc(s, func(r rune) bool {
+		return r < 0x20 && !strings.ContainsRune(allowed, r)
+	})
+}
+
+const selectPost = `SELECT post.id, post.user_id, user.username, post.title, post.body,
+	post.body_html, post.created_at, post.updated_at FROM post JOIN user ON user.id = post.user_id `
+
+func scanPost(row interface{ Scan(...any) error }) (Post, error) {
+	var p Post
+	var bodyHTML string
+	err := row.Scan(&p.ID, &p.UserID, &p.Username, &p.Title, &p.Body, &bodyHTML, &p.CreatedAt, &p.UpdatedAt)
+	if err != nil {
+		return p, err
+	}
+	p.BodyHTML = template.HTML(bodyHTML)
+	// posts written before markdown support have nothing cached yet
+	if p.BodyHTML == "" && p.Body != "" {
+		p.BodyHTML, err = markdown.Render(p.Body)
+	}
+	return p, err
+}
+
+// visible limits a query to the posts a visitor may read. Nobody sees the
+// posts of disabled accounts or accounts pending deletion and anonymous
+// visitors only see those of users who made their posts public.
+func visible(loggedIn bool) string {
+	where := "user.disabled = FALSE AND user.deletion_requested_at IS NULL "
+	if !loggedIn {
+		where += "AND user.public_posts = TRUE "
+	}
+	return where
+}
+
+func Get(db *sql.DB, id uint64, loggedIn bool) (Post, error) {
+	p, err := scanPost(db.QueryRow(selectPost+"WHERE post.id = ? AND "+visible(loggedIn)+";", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Post{}, ErrNotFound
+	} else if err != nil {
+		return Post{}, fmt.Errorf("failed to get post %d: %w", id, err)
+	}
+	return p, nil
+}
+
+// List returns a page of the posts the visitor may read newest first. It
+// fetches one more than limit so callers can tell if there is another page.
+func List(db *sql.DB, loggedIn bool, limit, offset int) ([]Post, error) {
+	return query(db, selectPost+"WHERE "+visible(loggedIn)+"ORDER BY post.id DESC LIMIT ? OFFSET ?;",
+		limit+1, offset)
+}
+
+// ListByUser is List for a single user's posts
+func ListByUser(db *sql.DB, userID uint64, loggedIn bool, limit, offset int) ([]Post, error) {
+	return query(db, selectPost+"WHERE post.user_id = ? AND "+visible(loggedIn)+
+		"ORDER BY post.id DESC LIMIT ? OFFSET ?;", userID, limit+1, offset)
+}
+
+func query(db *sql.DB, query string, args ...any) ([]Post, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query posts: %w", err)
+	}
+	defer rows.Close()
+
+	var posts []Post
+	for rows.Next() {
+		p, err := scanPost(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan post: %w", err)
+		}
+		posts = append(posts, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate posts: %w", err)
+	}
+	return posts, nil
+}
+
+func Create(db *sql.DB, userID uint64, title, body string) (uint64, error) {
+	bodyHTML, err := markdown.Render(body)
+	if err != nil {
+		return 0, err
+	}
+	query := "INSERT INTO post (user_id, title, body, body_html) VALUES (?, ?, ?, ?);"
+	res, err := db.Exec(query, userID, title, body, string(bodyHTML))
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert post: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get post id: %w", err)
+	}
+	return uint64(id), nil
+}
+
+func Update(db *sql.DB, id uint64, title, body string) error {
+	bodyHTML, err := markdown.Render(body)
+	if err != nil {
+		return err
+	}
+	query := "UPDATE post SET title = ?, body = ?, body_html = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?;"
+	if _, err := db.Exec(query, title, body, string(bodyHTML), id); err != nil {
+		return fmt.Errorf("failed to update post %d: %w", id, err)
+	}
+	return nil
+}
+
+// Delete removes the post, the foreign key takes its comments along
+func Delete(db *sql.DB, id uint64) error {
+	if _, err := db.Exec("DELETE FROM post WHERE id = ?;", id); err != nil {
+		return fmt.Errorf("failed to delete post %d: %w", id, err)
+	}
+	return nil
+}
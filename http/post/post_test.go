@@ -0,0 +1,229 @@
+package post
+
+import (
+	"database/sql"
+	"errors"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	_ "github.com/glebarez/go-sqlite"
+	"github.com/somethingsoftware/violet-web/migrate"
+)
+
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "test.db")+"?_pragma=foreign_keys(1)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := migrate.AutoUP(db, slog.New(slog.NewTextHandler(io.Discard, nil))); err != nil {
+		t.Fatal(err)
+	}
+	queries := []string{
+		"INSERT INTO user (id, username, email, salt, password_hash) VALUES (1, 'alice', 'alice@example.com', '', '');",
+		"INSERT INTO user (id, username, email, salt, password_hash) VALUES (2, 'bob', 'bob@example.com', '', '');",
+	}
+	for _, query := range queries {
+		if _, err := db.Exec(query); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+func createPost(t *testing.T, db *sql.DB, userID uint64, title, body string) uint64 {
+	t.Helper()
+	id, err := Create(db, userID, title, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		title, body string
+		want        error
+	}{
+		{title: "title", body: "body"},
+		{title: strings.Repeat("é", TitleLenMax), body: "body"},
+		{title: "", body: "body", want: ErrTitleLength},
+		{title: strings.Repeat("a", TitleLenMax+1), body: "body", want: ErrTitleLength},
+		{title: "title", body: "", want: ErrBodyLength},
+		{title: "title", body: strings.Repeat("a", BodyLenMax+1), want: ErrBodyLength},
+		{title: "title", body: "line\r\n\tindented"},
+		{title: "two\nlines", body: "body", want: ErrControlChars},
+		{title: "title", body: "a \x02fake\x03 match", want: ErrControlChars},
+		{title: "title", body: "nul\x00", want: ErrControlChars},
+	}
+	for _, tt := range tests {
+		if err := Validate(tt.title, tt.body); !errors.Is(err, tt.want) {
+			t.Errorf("Validate(%d runes, %d runes) = %v, want %v", len([]rune(tt.title)), len([]rune(tt.body)), err, tt.want)
+		}
+	}
+}
+
+func TestCreateUpdateDelete(t *testing.T) {
+	db := openTestDB(t)
+	id := createPost(t, db, 1, "hello", "first post")
+
+	p, err := Get(db, id, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Username != "alice" || p.Title != "hello" || p.Body != "first post" || p.UpdatedAt.Valid {
+		t.Errorf("Get() = %+v", p)
+	}
+
+	if err := Update(db, id, "hello again", "edited"); err != nil {
+		t.Fatal(err)
+	}
+	p, err = Get(db, id, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Title != "hello again" || p.Body != "edited" || !p.UpdatedAt.Valid {
+		t.Errorf("Get() after Update() = %+v", p)
+	}
+
+	if _, err := db.Exec("INSERT INTO comment (post_id, user_id, body) VALUES (?, 2, 'comment');", id); err != nil {
+		t.Fatal(err)
+	}
+	if err := Delete(db, id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Get(db, id, true); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() after Delete() error = %v, want ErrNotFound", err)
+	}
+	var comments int
+	if err := db.QueryRow("SELECT COUNT(*) FROM comment;").Scan(&comments); err != nil || comments != 0 {
+		t.Errorf("%d comments left on the deleted post, %v", comments, err)
+	}
+}
+
+func TestList(t *testing.T) {
+	db := openTestDB(t)
+	for _, title := range []string{"one", "two", "three"} {
+		createPost(t, db, 1, title, "body")
+	}
+	createPost(t, db, 2, "bob's", "body")
+
+	// one extra post tells the caller there is another page
+	posts, err := List(db, true, 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(posts) != 3 || posts[0].Title != "bob's" || posts[1].Title != "three" {
+		t.Errorf("List(2, 0) = %v, want newest first with one extra", titles(posts))
+	}
+	posts, err = List(db, true, 2, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(posts) != 2 || posts[0].Title != "two" || posts[1].Title != "one" {
+		t.Errorf("List(2, 2) = %v, want the last page", titles(posts))
+	}
+
+	posts, err = ListByUser(db, 2, true, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(posts) != 1 || posts[0].Username != "bob" {
+		t.Errorf("ListByUser(bob) = %v", titles(posts))
+	}
+}
+
+func TestVisible(t *testing.T) {
+	db := openTestDB(t)
+	queries := []string{
+		"INSERT INTO user (id, username, email, salt, password_hash, public_posts) VALUES (3, 'carol', 'carol@example.com', '', '', FALSE);",
+		"INSERT INTO user (id, username, email, salt, password_hash, disabled) VALUES (4, 'dave', 'dave@example.com', '', '', TRUE);",
+		"INSERT INTO user (id, username, email, salt, password_hash, deletion_requested_at) VALUES (5, 'erin', 'erin@example.com', '', '', CURRENT_TIMESTAMP);",
+	}
+	for _, query := range queries {
+		if _, err := db.Exec(query); err != nil {
+			t.Fatal(err)
+		}
+	}
+	public := createPost(t, db, 1, "public", "visible gardening")
+	private := createPost(t, db, 3, "private", "members gardening")
+	disabled := createPost(t, db, 4, "disabled", "hidden gardening")
+	leaving := createPost(t, db, 5, "leaving", "hidden gardening")
+
+	tests := []struct {
+		loggedIn bool
+		want     []string
+	}{
+		{loggedIn: false, want: []string{"public"}},
+		{loggedIn: true, want: []string{"private", "public"}},
+	}
+	for _, tt := range tests {
+		posts, err := List(db, tt.loggedIn, 10, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := titles(posts); strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("List(logged in %t) = %v, want %v", tt.loggedIn, got, tt.want)
+		}
+		results, err := Search(db, "gardening", tt.loggedIn, 10, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != len(tt.want) {
+			t.Errorf("Search(logged in %t) = %d results, want %d", tt.loggedIn, len(results), len(tt.want))
+		}
+		if posts, err := ListByUser(db, 3, tt.loggedIn, 10, 0); err != nil || len(posts) != len(tt.want)-1 {
+			t.Errorf("ListByUser(carol, logged in %t) = %v, %v", tt.loggedIn, titles(posts), err)
+		}
+		if _, err := Get(db, public, tt.loggedIn); err != nil {
+			t.Errorf("Get(public, logged in %t) error = %v", tt.loggedIn, err)
+		}
+		if _, err := Get(db, private, tt.loggedIn); (err == nil) != tt.loggedIn {
+			t.Errorf("Get(private, logged in %t) error = %v", tt.loggedIn, err)
+		}
+		for _, id := range []uint64{disabled, leaving} {
+			if _, err := Get(db, id, tt.loggedIn); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Get(%d, logged in %t) error = %v, want ErrNotFound", id, tt.loggedIn, err)
+			}
+		}
+	}
+}
+
+func titles(posts []Post) []string {
+	var titles []string
+	for _, p := range posts {
+		titles = append(titles, p.Title)
+	}
+	return titles
+}
+
+func TestBodyHTML(t *testing.T) {
+	db := openTestDB(t)
+	id := createPost(t, db, 1, "hello", "**bold** <script>alert(1)</script>")
+	p, err := Get(db, id, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(p.BodyHTML), "<strong>bold</strong>") || strings.Contains(string(p.BodyHTML), "<script") {
+		t.Errorf("BodyHTML = %s, want rendered and sanitised markdown", p.BodyHTML)
+	}
+
+	if err := Update(db, id, "hello", "*edited*"); err != nil {
+		t.Fatal(err)
+	}
+	if p, err = Get(db, id, true); err != nil || !strings.Contains(string(p.BodyHTML), "<em>edited</em>") {
+		t.Errorf("BodyHTML after Update() = %s, %v", p.BodyHTML, err)
+	}
+
+	// posts from before body_html was added are rendered when read
+	if _, err := db.Exec("UPDATE post SET body_html = '' WHERE id = ?;", id); err != nil {
+		t.Fatal(err)
+	}
+	if p, err = Get(db, id, true); err != nil || !strings.Contains(string(p.BodyHTML), "<em>edited</em>") {
+		t.Errorf("BodyHTML of a post without a cached rendering = %s, %v", p.BodyHTML, err)
+	}
+}
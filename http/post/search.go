@@ -0,0 +1,103 @@
+package post
+
+import (
+	"database/sql"
+	"fmt"
+	"html/template"
+	"strings"
+)
+
+// CREATE VIRTUAL TABLE post_fts USING fts5(
+// title, body, content='post', content_rowid='id');
+// kept in sync with post by the post_fts_* triggers
+
+// SearchResult is a post matching a search with the matching parts of the
+// title and body highlighted
+type SearchResult struct {
+	Post
+	TitleHTML   template.HTML
+	SnippetHTML template.HTML
+}
+
+// fts5 returns the text between these around each match. Validate keeps them
+// out of posts so they are swapped for <mark> once the text has been escaped,
+// see highlight for posts from before that.
+const markStart = "\x02"
+const markEnd = "\x03"
+
+// matchQuery turns what a user typed into an fts5 query that can't be a
+// syntax error, every word is quoted and all of them have to match
+func matchQuery(q string) string {
+	var terms []string
+	for _, word := range strings.Fields(q) {
+		word = strings.NewReplacer(markStart, "", markEnd, "").Replace(word)
+		terms = append(terms, `"`+strings.ReplaceAll(word, `"`, `""`)+`"`)
+	}
+	return strings.Join(terms, " ")
+}
+
+// highlight escapes s and marks the matches. A marker that doesn't pair up
+// can only come from the post itself and is dropped, every <mark> is closed.
+func highlight(s string) template.HTML {
+	var b strings.Builder
+	open := false
+	for _, r := range template.HTMLEscapeString(s) {
+		switch {
+		case string(r) == markStart && !open:
+			b.WriteString("<mark>")
+			open = true
+		case string(r) == markEnd && open:
+			b.WriteString("</mark>")
+			open = false
+		case string(r) == markStart || string(r) == markEnd:
+		default:
+			b.WriteRune(r)
+		}
+	}
+	if open {
+		b.WriteString("</mark>")
+	}
+	return template.HTML(b.String())
+}
+
+// Search returns a page of the posts the visitor may read matching q, best
+// match first. Like List it fetches one more than limit.
+func Search(db *sql.DB, q string, loggedIn bool, limit, offset int) ([]SearchResult, error) {
+	match := matchQuery(q)
+	if match == "" {
+		return nil, nil
+	}
+
+	query := `SELECT post.id, post.user_id, user.username, post.title, post.body,
+		post.body_html, post.created_at, post.updated_at,
+		highlight(post_fts, 0, ?, ?), snippet(post_fts, 1, ?, ?, '…', 24)
+		FROM post_fts
+		JOIN post ON post.id = post_fts.rowid
+		JOIN user ON user.id = post.user_id
+		WHERE post_fts MATCH ? AND ` + visible(loggedIn) + `
+		ORDER BY bm25(post_fts, 5.0, 1.0)
+		LIMIT ? OFFSET ?;`
+	rows, err := db.Query(query, markStart, markEnd, markStart, markEnd, match, limit+1, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search posts: %w", err)
+	}
+	defer rows.Close()
+
+	var results []SearchResult
+	for rows.Next() {
+		var res SearchResult
+		var bodyHTML, title, snippet string
+		if err := rows.Scan(&res.ID, &res.UserID, &res.Username, &res.Title, &res.Body, &bodyHTML,
+			&res.CreatedAt, &res.UpdatedAt, &title, &snippet); err != nil {
+			return nil, fmt.Errorf("failed to scan search result: %w", err)
+		}
+		res.BodyHTML = template.HTML(bodyHTML)
+		res.TitleHTML = highlight(title)
+		res.SnippetHTML = highlight(snippet)
+		results = append(results, res)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate search results: %w", err)
+	}
+	return results, nil
+}
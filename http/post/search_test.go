@@ -0,0 +1,126 @@
+package post
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMatchQuery(t *testing.T) {
+	tests := []struct {
+		q, want string
+	}{
+		{q: "", want: ""},
+		{q: "  ", want: ""},
+		{q: "go sqlite", want: `"go" "sqlite"`},
+		{q: `say "hi"`, want: `"say" """hi"""`},
+		{q: "title:x OR NOT y*", want: `"title:x" "OR" "NOT" "y*"`},
+	}
+	for _, tt := range tests {
+		if got := matchQuery(tt.q); got != tt.want {
+			t.Errorf("matchQuery(%q) = %q, want %q", tt.q, got, tt.want)
+		}
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	tests := []struct {
+		s, want string
+	}{
+		{s: "a \x02match\x03 & more", want: "a <mark>match</mark> &amp; more"},
+		{s: "\x02<b>\x03", want: "<mark>&lt;b&gt;</mark>"},
+		// stray markers from posts saved before Validate kept them out
+		{s: "\x03a \x02b \x02c\x03 d\x03", want: "a <mark>b c</mark> d"},
+		{s: "\x02open", want: "<mark>open</mark>"},
+	}
+	for _, tt := range tests {
+		if got := highlight(tt.s); string(got) != tt.want {
+			t.Errorf("highlight(%q) = %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestSearch(t *testing.T) {
+	db := openTestDB(t)
+	inBody := createPost(t, db, 1, "notes", "a long post that mentions gardening once")
+	inTitle := createPost(t, db, 2, "gardening", "all about plants")
+	createPost(t, db, 1, "unrelated", "nothing to see")
+	escaped := createPost(t, db, 1, "html", "<b>tomatoes</b> & peppers")
+
+	results, err := Search(db, "gardening", true, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].ID != inTitle || results[1].ID != inBody {
+		t.Fatalf("Search(gardening) = %v, want the title match first", resultIDs(results))
+	}
+	if results[0].TitleHTML != "<mark>gardening</mark>" {
+		t.Errorf("TitleHTML = %s", results[0].TitleHTML)
+	}
+	if !strings.Contains(string(results[1].SnippetHTML), "<mark>gardening</mark>") {
+		t.Errorf("SnippetHTML = %s", results[1].SnippetHTML)
+	}
+
+	results, err = Search(db, "tomatoes", true, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].ID != escaped {
+		t.Fatalf("Search(tomatoes) = %v", resultIDs(results))
+	}
+	if got := string(results[0].SnippetHTML); got != "&lt;b&gt;<mark>tomatoes</mark>&lt;/b&gt; &amp; peppers" {
+		t.Errorf("SnippetHTML = %s, want the body escaped around the mark", got)
+	}
+
+	// fts5 syntax is taken literally instead of failing the query
+	for _, q := range []string{`"unbalanced`, "NOT", "a:b", "*", "(", "\x02gardening\x03"} {
+		if _, err := Search(db, q, true, 10, 0); err != nil {
+			t.Errorf("Search(%q) error = %v", q, err)
+		}
+	}
+	if results, err := Search(db, "", true, 10, 0); err != nil || results != nil {
+		t.Errorf("Search(\"\") = %v, %v, want nothing", results, err)
+	}
+}
+
+func TestSearchIndexFollowsPosts(t *testing.T) {
+	db := openTestDB(t)
+	id := createPost(t, db, 1, "first", "apples")
+	if err := Update(db, id, "first", "oranges"); err != nil {
+		t.Fatal(err)
+	}
+	if results, err := Search(db, "apples", true, 10, 0); err != nil || len(results) != 0 {
+		t.Errorf("Search() found the old body: %v, %v", resultIDs(results), err)
+	}
+	if results, err := Search(db, "oranges", true, 10, 0); err != nil || len(results) != 1 {
+		t.Errorf("Search() didn't find the new body: %v, %v", resultIDs(results), err)
+	}
+	if err := Delete(db, id); err != nil {
+		t.Fatal(err)
+	}
+	if results, err := Search(db, "oranges", true, 10, 0); err != nil || len(results) != 0 {
+		t.Errorf("Search() found a deleted post: %v, %v", resultIDs(results), err)
+	}
+}
+
+func TestSearchPages(t *testing.T) {
+	db := openTestDB(t)
+	for range 3 {
+		createPost(t, db, 1, "page", "body")
+	}
+	results, err := Search(db, "page", true, 2, 0)
+	if err != nil || len(results) != 3 {
+		t.Errorf("Search() first page = %d results, %v, want 3", len(results), err)
+	}
+	results, err = Search(db, "page", true, 2, 2)
+	if err != nil || len(results) != 1 {
+		t.Errorf("Search() second page = %d results, %v, want 1", len(results), err)
+	}
+}
+
+func resultIDs(results []SearchResult) []uint64 {
+	var ids []uint64
+	for _, res := range results {
+		ids = append(ids, res.ID)
+	}
+	return ids
+}
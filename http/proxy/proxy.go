@@ -0,0 +1,190 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Resolver works out the client address and scheme of a request. Behind a
+// reverse proxy RemoteAddr is the proxy, so the Forwarded and X-Forwarded-*
+// headers are read instead, but only when the connection comes from one of
+// the trusted proxies since anyone else could set them to anything.
+type Resolver struct {
+	trusted []netip.Prefix
+}
+
+// NewResolver trusts the given CIDRs, a bare address trusts just that host
+func NewResolver(cidrs []string) (*Resolver, error) {
+	rs := &Resolver{}
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		if !strings.Contains(cidr, "/") {
+			addr, err := netip.ParseAddr(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse trusted proxy %q: %w", cidr, err)
+			}
+			rs.trusted = append(rs.trusted, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse trusted proxy %q: %w", cidr, err)
+		}
+		rs.trusted = append(rs.trusted, prefix.Masked())
+	}
+	return rs, nil
+}
+
+// Client is where a request really came from
+type Client struct {
+	IP     string
+	Scheme string
+}
+
+// UnknownIP is the client IP when a trusted proxy forwarded a hop that isn't
+// an address, like the "unknown" or obfuscated identifiers of RFC 7239
+const UnknownIP = "unknown"
+
+type contextKey struct{}
+
+// Middleware resolves the client once per request for ClientIP and Scheme
+func (rs *Resolver) Middleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c := rs.Resolve(r)
+		next(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, c)))
+	}
+}
+
+func (rs *Resolver) Resolve(r *http.Request) Client {
+	c := direct(r)
+	remote, err := netip.ParseAddr(c.IP)
+	if err != nil || !rs.isTrusted(remote) {
+		return c
+	}
+
+	hops, protos := forwarded(r.Header)
+	// the right most hops were added by our own proxies, the first one that
+	// isn't trusted is the client. Anything left of it could be made up. A
+	// hop that can't be parsed hides the client, the proxies before it aren't
+	// the client either.
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := parseHop(hops[i])
+		if err != nil {
+			c.IP = UnknownIP
+			break
+		}
+		c.IP = addr.String()
+		if !rs.isTrusted(addr) {
+			break
+		}
+	}
+	// the proto added by the nearest proxy is the one to believe
+	if len(protos) > 0 {
+		switch proto := strings.ToLower(protos[len(protos)-1]); proto {
+		case "http", "https":
+			c.Scheme = proto
+		}
+	}
+	return c
+}
+
+func (rs *Resolver) isTrusted(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range rs.trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwarded returns the client addresses and protocols from the Forwarded
+// header, falling back to X-Forwarded-For and X-Forwarded-Proto
+func forwarded(h http.Header) (hops, protos []string) {
+	if values := h.Values("Forwarded"); len(values) > 0 {
+		for _, value := range values {
+			for _, element := range strings.Split(value, ",") {
+				for _, pair := range strings.Split(element, ";") {
+					key, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+					if !ok {
+						continue
+					}
+					val = strings.Trim(val, `"`)
+					switch strings.ToLower(key) {
+					case "for":
+						hops = append(hops, val)
+					case "proto":
+						protos = append(protos, val)
+					}
+				}
+			}
+		}
+		return hops, protos
+	}
+	return splitList(h.Values("X-Forwarded-For")), splitList(h.Values("X-Forwarded-Proto"))
+}
+
+func splitList(values []string) []string {
+	var list []string
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+	return list
+}
+
+// parseHop parses an address with or without a port, IPv6 ones may be in
+// brackets
+func parseHop(hop string) (netip.Addr, error) {
+	if addrPort, err := netip.ParseAddrPort(hop); err == nil {
+		return addrPort.Addr().Unmap(), nil
+	}
+	addr, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(hop, "["), "]"))
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	return addr.Unmap(), nil
+}
+
+// direct is the client as seen on the connection
+func direct(r *http.Request) Client {
+	c := Client{IP: r.RemoteAddr, Scheme: "http"}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		c.IP = host
+	}
+	if addr, err := netip.ParseAddr(c.IP); err == nil {
+		c.IP = addr.Unmap().String()
+	}
+	if r.TLS != nil {
+		c.Scheme = "https"
+	}
+	return c
+}
+
+func fromRequest(r *http.Request) Client {
+	if c, ok := r.Context().Value(contextKey{}).(Client); ok {
+		return c
+	}
+	return direct(r)
+}
+
+// ClientIP is the address of the client that made the request, without the
+// port, or UnknownIP if a proxy hid it
+func ClientIP(r *http.Request) string {
+	return fromRequest(r).IP
+}
+
+// Scheme is the scheme the client used to reach the site
+func Scheme(r *http.Request) string {
+	return fromRequest(r).Scheme
+}
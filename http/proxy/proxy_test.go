@@ -0,0 +1,178 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	rs, err := NewResolver([]string{"10.0.0.0/8", "fd00::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		remote string
+		tls    bool
+		header http.Header
+		want   Client
+	}{
+		{
+			name:   "direct",
+			remote: "203.0.113.7:5000",
+			want:   Client{IP: "203.0.113.7", Scheme: "http"},
+		},
+		{
+			name:   "direct tls",
+			remote: "203.0.113.7:5000",
+			tls:    true,
+			want:   Client{IP: "203.0.113.7", Scheme: "https"},
+		},
+		{
+			name:   "spoofed x-forwarded-for from an untrusted peer",
+			remote: "203.0.113.7:5000",
+			header: http.Header{"X-Forwarded-For": {"198.51.100.1"}, "X-Forwarded-Proto": {"https"}},
+			want:   Client{IP: "203.0.113.7", Scheme: "http"},
+		},
+		{
+			name:   "spoofed forwarded from an untrusted peer",
+			remote: "203.0.113.7:5000",
+			header: http.Header{"Forwarded": {"for=198.51.100.1;proto=https"}},
+			want:   Client{IP: "203.0.113.7", Scheme: "http"},
+		},
+		{
+			name:   "one trusted proxy",
+			remote: "10.0.0.2:5000",
+			header: http.Header{"X-Forwarded-For": {"198.51.100.1"}, "X-Forwarded-Proto": {"https"}},
+			want:   Client{IP: "198.51.100.1", Scheme: "https"},
+		},
+		{
+			name:   "client prepends a spoofed hop",
+			remote: "10.0.0.2:5000",
+			header: http.Header{"X-Forwarded-For": {"192.0.2.99, 198.51.100.1"}},
+			want:   Client{IP: "198.51.100.1", Scheme: "http"},
+		},
+		{
+			name:   "chain of trusted proxies",
+			remote: "10.0.0.2:5000",
+			header: http.Header{"X-Forwarded-For": {"192.0.2.99, 198.51.100.1, 10.1.1.1", "10.2.2.2"}},
+			want:   Client{IP: "198.51.100.1", Scheme: "http"},
+		},
+		{
+			name:   "every hop trusted",
+			remote: "10.0.0.2:5000",
+			header: http.Header{"X-Forwarded-For": {"10.3.3.3, 10.1.1.1"}},
+			want:   Client{IP: "10.3.3.3", Scheme: "http"},
+		},
+		{
+			name:   "nearest proxy's proto wins",
+			remote: "10.0.0.2:5000",
+			header: http.Header{"X-Forwarded-For": {"198.51.100.1"}, "X-Forwarded-Proto": {"http, https"}},
+			want:   Client{IP: "198.51.100.1", Scheme: "https"},
+		},
+		{
+			name:   "unsupported proto is ignored",
+			remote: "10.0.0.2:5000",
+			header: http.Header{"X-Forwarded-Proto": {"gopher"}},
+			want:   Client{IP: "10.0.0.2", Scheme: "http"},
+		},
+		{
+			name:   "forwarded with a port",
+			remote: "10.0.0.2:5000",
+			header: http.Header{"Forwarded": {`for="198.51.100.1:4711";proto=https`}},
+			want:   Client{IP: "198.51.100.1", Scheme: "https"},
+		},
+		{
+			name:   "forwarded bracketed ipv6 with a port",
+			remote: "10.0.0.2:5000",
+			header: http.Header{"Forwarded": {`for="[2001:db8::1]:4711"`}},
+			want:   Client{IP: "2001:db8::1", Scheme: "http"},
+		},
+		{
+			name:   "forwarded bracketed ipv6 without a port",
+			remote: "10.0.0.2:5000",
+			header: http.Header{"Forwarded": {`for="[2001:db8::1]"`}},
+			want:   Client{IP: "2001:db8::1", Scheme: "http"},
+		},
+		{
+			name:   "forwarded chain over several elements and headers",
+			remote: "10.0.0.2:5000",
+			header: http.Header{"Forwarded": {"for=192.0.2.99, for=198.51.100.1;proto=http", "for=10.1.1.1;proto=https"}},
+			want:   Client{IP: "198.51.100.1", Scheme: "https"},
+		},
+		{
+			name:   "forwarded wins over x-forwarded-for",
+			remote: "10.0.0.2:5000",
+			header: http.Header{"Forwarded": {"for=198.51.100.1"}, "X-Forwarded-For": {"192.0.2.99"}},
+			want:   Client{IP: "198.51.100.1", Scheme: "http"},
+		},
+		{
+			name:   "unknown hop hides the client",
+			remote: "10.0.0.2:5000",
+			header: http.Header{"X-Forwarded-For": {"198.51.100.1, unknown"}},
+			want:   Client{IP: UnknownIP, Scheme: "http"},
+		},
+		{
+			name:   "obfuscated forwarded hop isn't the trusted proxy before it",
+			remote: "10.0.0.2:5000",
+			header: http.Header{"Forwarded": {"for=198.51.100.1, for=_hidden, for=10.1.1.1"}},
+			want:   Client{IP: UnknownIP, Scheme: "http"},
+		},
+		{
+			name:   "garbage hop keeps the proto",
+			remote: "10.0.0.2:5000",
+			header: http.Header{"X-Forwarded-For": {"198.51.100.1, not an address"}, "X-Forwarded-Proto": {"https"}},
+			want:   Client{IP: UnknownIP, Scheme: "https"},
+		},
+		{
+			name:   "trusted ipv6 proxy",
+			remote: "[fd00::1]:5000",
+			header: http.Header{"X-Forwarded-For": {"198.51.100.1"}},
+			want:   Client{IP: "198.51.100.1", Scheme: "http"},
+		},
+		{
+			name:   "ipv4 mapped ipv6 peer",
+			remote: "[::ffff:10.0.0.2]:5000",
+			header: http.Header{"X-Forwarded-For": {"::ffff:198.51.100.1"}},
+			want:   Client{IP: "198.51.100.1", Scheme: "http"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remote
+			if tt.tls {
+				r.TLS = &tls.ConnectionState{}
+			}
+			for key, values := range tt.header {
+				for _, value := range values {
+					r.Header.Add(key, value)
+				}
+			}
+			if got := rs.Resolve(r); got != tt.want {
+				t.Errorf("Resolve() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewResolver(t *testing.T) {
+	tests := []struct {
+		cidrs   []string
+		wantErr bool
+	}{
+		{cidrs: nil},
+		{cidrs: []string{"10.0.0.0/8", " 192.168.1.1 ", ""}},
+		{cidrs: []string{"::1", "fd00::/8"}},
+		{cidrs: []string{"10.0.0.0/33"}, wantErr: true},
+		{cidrs: []string{"proxy.example"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		if _, err := NewResolver(tt.cidrs); (err != nil) != tt.wantErr {
+			t.Errorf("NewResolver(%q) error = %v, want error %t", tt.cidrs, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package render
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/somethingsoftware/violet-web/http/apperr"
+	"github.com/somethingsoftware/violet-web/http/i18n"
+)
+
+// Error is the one place failed requests are answered. err is turned into an
+// *apperr.Error, browsers get the error page and API clients get JSON, both
+// with the translated public message. Server errors are logged at error level
+// with their cause, client errors only at debug since they are expected.
+func (rn *Renderer) Error(ctx context.Context, w http.ResponseWriter, r *http.Request, err error) {
+	e := apperr.From(err)
+	level := slog.LevelDebug
+	if e.Status >= http.StatusInternalServerError {
+		level = slog.LevelError
+	}
+	attrs := []any{"status", e.Status, "method", r.Method, "path", r.URL.Path}
+	if e.Cause != nil {
+		attrs = append(attrs, "error", e.Cause)
+	}
+	rn.logger.Log(ctx, level, e.Text(), attrs...)
+
+	message := i18n.T(r.Context(), e.Message, e.Args...)
+	if wantsJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.WriteHeader(e.Status)
+		body := struct {
+			Status int    `json:"status"`
+			Error  string `json:"error"`
+		}{e.Status, message}
+		if err := json.NewEncoder(w).Encode(body); err != nil {
+			rn.logger.ErrorContext(ctx, "Failed to write error", "error", err)
+		}
+		return
+	}
+
+	type ErrorPage struct {
+		Status  int
+		Title   string
+		Message string
+	}
+	rn.RenderStatus(w, r, e.Status, "error.gotmpl", ErrorPage{
+		Status:  e.Status,
+		Title:   i18n.T(r.Context(), http.StatusText(e.Status)),
+		Message: message,
+	})
+}
+
+// wantsJSON reports whether the client asked for JSON over html, anything
+// that doesn't say either way gets the page
+func wantsJSON(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		switch mediaType {
+		case "application/json":
+			return true
+		case "text/html":
+			return false
+		}
+	}
+	return false
+}
@@ -0,0 +1,94 @@
+package render
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/somethingsoftware/violet-web/http/apperr"
+	"github.com/somethingsoftware/violet-web/http/i18n"
+)
+
+func TestError(t *testing.T) {
+	fsys := testFS()
+	fsys["error.gotmpl"] = &fstest.MapFile{
+		Data: []byte(`{{define "title"}}{{.Title}}{{end}}{{define "content"}}{{.Status}} {{.Message}}{{end}}`),
+	}
+	rn := newTestRenderer(t, fsys, false)
+
+	tests := []struct {
+		name   string
+		accept string
+		locale string
+		err    error
+		status int
+		want   string
+	}{
+		{
+			name:   "page",
+			accept: "text/html,application/xhtml+xml,*/*;q=0.8",
+			err:    apperr.NotFound(),
+			status: http.StatusNotFound,
+			want:   "<title>Not Found</title>404 Not found",
+		},
+		{
+			name:   "translated page",
+			locale: "de",
+			err:    apperr.Forbidden(),
+			status: http.StatusForbidden,
+			want:   "<title>Zugriff verweigert</title>403 Zugriff verweigert",
+		},
+		{
+			name:   "internal error hides the cause",
+			err:    errors.New("password hash is abc123"),
+			status: http.StatusInternalServerError,
+			want:   "500 Internal server error",
+		},
+		{
+			name:   "json",
+			accept: "application/json",
+			err:    apperr.BadRequest("Title must be at most %d characters", 100),
+			status: http.StatusBadRequest,
+			want:   `{"status":400,"error":"Title must be at most 100 characters"}`,
+		},
+		{
+			name:   "html preferred over json",
+			accept: "text/html, application/json",
+			err:    apperr.NotFound(),
+			status: http.StatusNotFound,
+			want:   "404 Not found",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Accept", tt.accept)
+			if tt.locale != "" {
+				r = r.WithContext(i18n.WithLocale(r.Context(), tt.locale))
+			}
+			w := httptest.NewRecorder()
+			rn.Error(context.Background(), w, r, tt.err)
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+			body := strings.TrimSpace(w.Body.String())
+			if !strings.Contains(body, tt.want) {
+				t.Errorf("body = %s, want %s", body, tt.want)
+			}
+			if strings.Contains(body, "abc123") {
+				t.Error("body contains the cause of an internal error")
+			}
+			if tt.accept == "application/json" {
+				var v map[string]any
+				if err := json.Unmarshal(w.Body.Bytes(), &v); err != nil || w.Header().Get("Content-Type") != "application/json" {
+					t.Errorf("json response = %s %s, %v", w.Header().Get("Content-Type"), body, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package render
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"path"
+	"sync"
+
+	"github.com/somethingsoftware/violet-web/http/flash"
+	"github.com/somethingsoftware/violet-web/http/i18n"
+)
+
+// Renderer executes the html templates. Every page is parsed together with
+// the layouts and partials once per locale at startup, in dev mode they are
+// parsed again on every render so edits show up without a restart.
+//
+// A page template defines "title", "content" and optionally "head", the
+// "base" layout puts them together. funcs are available to every template
+// along with "locale", "t" and "tn" for the locale the page was parsed for,
+// see the i18n package for the last two.
+type Renderer struct {
+	fsys   fs.FS
+	reload bool
+	funcs  template.FuncMap
+	logger *slog.Logger
+
+	mu    sync.RWMutex
+	pages map[string]map[string]*template.Template
+}
+
+// view is what the "base" layout is executed with, the page itself gets
+// Data. Flash is shown once at the top of the page.
+type view struct {
+	Flash *flash.Message
+	Data  any
+}
+
+func New(fsys fs.FS, reload bool, funcs template.FuncMap, logger *slog.Logger) (*Renderer, error) {
+	rn := &Renderer{
+		fsys:   fsys,
+		reload: reload,
+		funcs:  funcs,
+		logger: logger,
+	}
+	pages, err := rn.parse()
+	if err != nil {
+		return nil, err
+	}
+	rn.pages = pages
+	return rn, nil
+}
+
+// parse parses the pages for every locale, keyed by locale and then name
+func (rn *Renderer) parse() (map[string]map[string]*template.Template, error) {
+	pages := map[string]map[string]*template.Template{}
+	for _, locale := range i18n.Supported() {
+		funcs := localeFuncs(locale)
+		for name, fn := range rn.funcs {
+			funcs[name] = fn
+		}
+		parsed, err := rn.parseLocale(funcs)
+		if err != nil {
+			return nil, err
+		}
+		pages[locale] = parsed
+	}
+	return pages, nil
+}
+
+func (rn *Renderer) parseLocale(funcs template.FuncMap) (map[string]*template.Template, error) {
+	base := template.New("").Funcs(funcs)
+	for _, pattern := range []string{"layout/*.gotmpl", "partials/*.gotmpl"} {
+		matches, err := fs.Glob(rn.fsys, pattern)
+		if err != nil {
+			return nil, fmt.Errorf("failed to glob %s: %w", pattern, err)
+		}
+		if len(matches) == 0 {
+			continue
+		}
+		if base, err = base.ParseFS(rn.fsys, matches...); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", pattern, err)
+		}
+	}
+
+	names, err := fs.Glob(rn.fsys, "*.gotmpl")
+	if err != nil {
+		return nil, fmt.Errorf("failed to glob pages: %w", err)
+	}
+	pages := make(map[string]*template.Template, len(names))
+	for _, name := range names {
+		t, err := base.Clone()
+		if err != nil {
+			return nil, fmt.Errorf("failed to clone base template: %w", err)
+		}
+		if t, err = t.ParseFS(rn.fsys, name); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", name, err)
+		}
+		pages[path.Base(name)] = t
+	}
+	return pages, nil
+}
+
+func (rn *Renderer) lookup(locale, name string) (*template.Template, error) {
+	if rn.reload {
+		pages, err := rn.parse()
+		if err != nil {
+			return nil, err
+		}
+		rn.mu.Lock()
+		rn.pages = pages
+		rn.mu.Unlock()
+	}
+	rn.mu.RLock()
+	defer rn.mu.RUnlock()
+	t, ok := rn.pages[locale][name]
+	if !ok {
+		return nil, fmt.Errorf("template %s does not exist", name)
+	}
+	return t, nil
+}
+
+// localeFuncs are the template funcs that depend on the locale, each locale
+// has its own copy of the templates so they are only parsed and escaped once
+func localeFuncs(locale string) template.FuncMap {
+	ctx := i18n.WithLocale(context.Background(), locale)
+	return template.FuncMap{
+		"locale": func() string {
+			return locale
+		},
+		"t": func(key string, args ...any) string {
+			return i18n.T(ctx, key, args...)
+		},
+		"tn": func(key string, n int, args ...any) string {
+			return i18n.N(ctx, key, n, args...)
+		},
+	}
+}
+
+// Render writes the page with a 200 status. The page is executed into a
+// buffer first so a failing template gives a clean error instead of half a
+// page.
+func (rn *Renderer) Render(w http.ResponseWriter, r *http.Request, name string, data any) {
+	rn.RenderStatus(w, r, http.StatusOK, name, data)
+}
+
+func (rn *Renderer) RenderStatus(w http.ResponseWriter, r *http.Request, status int, name string, data any) {
+	t, err := rn.lookup(i18n.Locale(r.Context()), name)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		rn.logger.Error("Failed to load template", "error", err, "name", name)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := t.ExecuteTemplate(&buf, "base", view{Flash: flash.Get(r), Data: data}); err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		rn.logger.Error("Failed to execute template", "error", err, "name", name)
+		return
+	}
+	// only once the message made it onto a page
+	flash.Clear(w, r)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if _, err := buf.WriteTo(w); err != nil {
+		rn.logger.Error("Failed to write page", "error", err, "name", name)
+	}
+}
@@ -0,0 +1,126 @@
+package render
+
+import (
+	"html/template"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/somethingsoftware/violet-web/http/flash"
+	"github.com/somethingsoftware/violet-web/http/i18n"
+)
+
+func testFS() fstest.MapFS {
+	return fstest.MapFS{
+		"layout/base.gotmpl":   {Data: []byte(`{{define "base"}}<title>{{template "title" .Data}}</title>{{with .Flash}}[{{.Text}}]{{end}}{{template "content" .Data}}{{end}}`)},
+		"partials/name.gotmpl": {Data: []byte(`{{define "name"}}<b>{{.}}</b>{{end}}`)},
+		"hello.gotmpl":         {Data: []byte(`{{define "title"}}Hello{{end}}{{define "content"}}{{template "name" .Name}} {{upper "x"}}{{end}}`)},
+		"locale.gotmpl":        {Data: []byte(`{{define "title"}}{{locale}}{{end}}{{define "content"}}{{t "Back"}} {{tn "%d comments" 1}}{{end}}`)},
+		"broken.gotmpl":        {Data: []byte(`{{define "title"}}Broken{{end}}{{define "content"}}{{.Missing.Field}}{{end}}`)},
+	}
+}
+
+func newTestRenderer(t *testing.T, fsys fstest.MapFS, reload bool) *Renderer {
+	t.Helper()
+	rn, err := New(fsys, reload, template.FuncMap{"upper": strings.ToUpper}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rn
+}
+
+func TestRender(t *testing.T) {
+	rn := newTestRenderer(t, testFS(), false)
+	w := httptest.NewRecorder()
+	rn.RenderStatus(w, httptest.NewRequest(http.MethodGet, "/", nil), http.StatusTeapot, "hello.gotmpl", struct{ Name string }{"<alice>"})
+	if w.Code != http.StatusTeapot {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusTeapot)
+	}
+	if got, want := w.Body.String(), "<title>Hello</title><b>&lt;alice&gt;</b> X"; got != want {
+		t.Errorf("body = %s, want %s", got, want)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "text/html; charset=utf-8" {
+		t.Errorf("Content-Type = %s", ct)
+	}
+}
+
+func TestRenderErrors(t *testing.T) {
+	rn := newTestRenderer(t, testFS(), false)
+	for _, name := range []string{"missing.gotmpl", "broken.gotmpl"} {
+		w := httptest.NewRecorder()
+		rn.Render(w, httptest.NewRequest(http.MethodGet, "/", nil), name, struct{ Name string }{"alice"})
+		// a failed page is a clean 500, never half of the page
+		if w.Code != http.StatusInternalServerError || strings.Contains(w.Body.String(), "<title>") {
+			t.Errorf("Render(%s) = %d %s, want a plain 500", name, w.Code, w.Body)
+		}
+	}
+}
+
+func TestNewParseError(t *testing.T) {
+	fsys := testFS()
+	fsys["bad.gotmpl"] = &fstest.MapFile{Data: []byte(`{{define "content"}}{{end`)}
+	if _, err := New(fsys, false, nil, slog.New(slog.NewTextHandler(io.Discard, nil))); err == nil {
+		t.Error("New() with a broken template succeeded")
+	}
+}
+
+func TestReload(t *testing.T) {
+	for _, reload := range []bool{false, true} {
+		fsys := testFS()
+		rn := newTestRenderer(t, fsys, reload)
+		fsys["hello.gotmpl"].Data = []byte(`{{define "title"}}Edited{{end}}{{define "content"}}{{end}}`)
+		w := httptest.NewRecorder()
+		rn.Render(w, httptest.NewRequest(http.MethodGet, "/", nil), "hello.gotmpl", nil)
+		if got := strings.Contains(w.Body.String(), "Edited"); got != reload {
+			t.Errorf("reload %v: edited template used = %v", reload, got)
+		}
+	}
+}
+
+func TestFlash(t *testing.T) {
+	rn := newTestRenderer(t, testFS(), false)
+	set := httptest.NewRecorder()
+	flash.Set(set, flash.Success, "<saved>")
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, cookie := range set.Result().Cookies() {
+		r.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	rn.Render(w, r, "hello.gotmpl", struct{ Name string }{"alice"})
+	if !strings.Contains(w.Body.String(), "[&lt;saved&gt;]") {
+		t.Errorf("body = %s, want the escaped flash", w.Body)
+	}
+	if cookies := w.Result().Cookies(); len(cookies) != 1 || cookies[0].MaxAge >= 0 {
+		t.Errorf("cookies = %v, want the shown flash deleted", cookies)
+	}
+
+	// a page that fails to render didn't show it, it waits for the next one
+	w = httptest.NewRecorder()
+	rn.Render(w, r, "broken.gotmpl", struct{ Name string }{"alice"})
+	if cookies := w.Result().Cookies(); len(cookies) != 0 {
+		t.Errorf("cookies after a failed render = %v, want the flash kept", cookies)
+	}
+
+	// another request rendering at the same time doesn't see it
+	w = httptest.NewRecorder()
+	rn.Render(w, httptest.NewRequest(http.MethodGet, "/", nil), "hello.gotmpl", struct{ Name string }{"alice"})
+	if strings.Contains(w.Body.String(), "[") {
+		t.Errorf("body = %s, want no flash", w.Body)
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	rn := newTestRenderer(t, testFS(), false)
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r = r.WithContext(i18n.WithLocale(r.Context(), "de"))
+	w := httptest.NewRecorder()
+	rn.Render(w, r, "locale.gotmpl", nil)
+	if got, want := w.Body.String(), "<title>de</title>Zurück 1 Kommentar"; got != want {
+		t.Errorf("body = %s, want %s", got, want)
+	}
+}
@@ -1,9 +1,12 @@
 package session
 
 import (
+	"database/sql"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/somethingsoftware/violet-web/http/auth"
@@ -11,6 +14,8 @@ import (
 
 // TODO: use a real cache, lol
 type Cache struct {
+	db       *sql.DB
+	mu       sync.RWMutex
 	sessions map[string]Session
 }
 
@@ -18,10 +23,14 @@ type Session struct {
 	UserID    uint64
 	Username  string
 	LoginTime int64 // Unix time in milliseconds
+	// Locale is the user's language preference, empty means follow the
+	// browser. It is read once at login so pages don't have to look it up.
+	Locale string
 }
 
-func NewCache() *Cache {
+func NewCache(db *sql.DB) *Cache {
 	return &Cache{
+		db:       db,
 		sessions: make(map[string]Session),
 	}
 }
@@ -33,12 +42,19 @@ func (sc *Cache) StartSession(w http.ResponseWriter, r *http.Request, userID uin
 	}
 	sessionKeyB64 := base64.StdEncoding.EncodeToString(sessionKey)
 
+	var locale string
+	if err := sc.db.QueryRow("SELECT locale FROM user WHERE id = ?;", userID).Scan(&locale); err != nil {
+		return fmt.Errorf("failed to get locale preference: %w", err)
+	}
 	newSession := Session{
 		UserID:    userID,
 		Username:  username,
 		LoginTime: time.Now().UnixMilli(),
+		Locale:    locale,
 	}
+	sc.mu.Lock()
 	sc.sessions[sessionKeyB64] = newSession
+	sc.mu.Unlock()
 
 	cookie := &http.Cookie{
 		Name:     "session",
@@ -57,29 +73,109 @@ func (sc *Cache) GetSession(r *http.Request) (Session, error) {
 		return Session{}, fmt.Errorf("failed to get session cookie: %w", err)
 	}
 
+	sc.mu.RLock()
 	session, ok := sc.sessions[cookie.Value]
+	sc.mu.RUnlock()
 	if !ok {
 		return Session{}, fmt.Errorf("session not found")
 	}
 
+	// a session only lives as long as the account is still allowed to log in
+	// and its sessions weren't revoked after it started, a failed lookup says
+	// nothing about that and keeps the session
+	var disabled bool
+	var revokedAt int64
+	query := "SELECT disabled, sessions_revoked_at FROM user WHERE id = ? AND deletion_requested_at IS NULL;"
+	err = sc.db.QueryRowContext(r.Context(), query, session.UserID).Scan(&disabled, &revokedAt)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return Session{}, fmt.Errorf("failed to get session user: %w", err)
+	}
+	if err != nil || disabled || session.LoginTime <= revokedAt {
+		sc.mu.Lock()
+		delete(sc.sessions, cookie.Value)
+		sc.mu.Unlock()
+		if err != nil {
+			return Session{}, fmt.Errorf("failed to find session user: %w", err)
+		} else if disabled {
+			return Session{}, fmt.Errorf("session user is disabled")
+		}
+		return Session{}, fmt.Errorf("session was revoked")
+	}
+
 	return session, nil
 }
 
+// Cached returns the request's session as the cache has it, without checking
+// the account may still log in. It is for what doesn't need that check, like
+// picking the language of a page.
+func (sc *Cache) Cached(r *http.Request) (Session, bool) {
+	cookie, err := r.Cookie("session")
+	if err != nil {
+		return Session{}, false
+	}
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+	session, ok := sc.sessions[cookie.Value]
+	return session, ok
+}
+
 func (sc *Cache) EndSession(w http.ResponseWriter, r *http.Request) error {
 	cookie, err := r.Cookie("session")
 	if err != nil {
 		return fmt.Errorf("failed to get session cookie: %w", err)
 	}
 
-	sessionKey, err := base64.StdEncoding.DecodeString(cookie.Value)
-	if err != nil {
+	if _, err := base64.StdEncoding.DecodeString(cookie.Value); err != nil {
 		return fmt.Errorf("failed to decode session key: %w", err)
 	}
 
-	delete(sc.sessions, string(sessionKey))
+	sc.mu.Lock()
+	delete(sc.sessions, cookie.Value)
+	sc.mu.Unlock()
 
 	cookie.MaxAge = -1
 	http.SetCookie(w, cookie)
 
 	return nil
 }
+
+// UserSessions returns every active session belonging to the user
+func (sc *Cache) UserSessions(userID uint64) []Session {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+	var sessions []Session
+	for _, session := range sc.sessions {
+		if session.UserID == userID {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions
+}
+
+// EndUserSessions removes every session belonging to the user, logging them
+// out everywhere. It returns the number of sessions removed.
+func (sc *Cache) EndUserSessions(userID uint64) int {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	ended := 0
+	for key, session := range sc.sessions {
+		if session.UserID == userID {
+			delete(sc.sessions, key)
+			ended++
+		}
+	}
+	return ended
+}
+
+// SetLocale updates the language preference of every session belonging to
+// the user, after they saved a new one
+func (sc *Cache) SetLocale(userID uint64, locale string) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	for key, session := range sc.sessions {
+		if session.UserID == userID {
+			session.Locale = locale
+			sc.sessions[key] = session
+		}
+	}
+}
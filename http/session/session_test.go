@@ -0,0 +1,137 @@
+package session
+
+import (
+	"database/sql"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/glebarez/go-sqlite"
+	"github.com/somethingsoftware/violet-web/migrate"
+)
+
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "test.db")+"?_pragma=foreign_keys(1)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := migrate.AutoUP(db, slog.New(slog.NewTextHandler(io.Discard, nil))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO user (id, username, email, salt, password_hash) VALUES (1, 'alice', 'alice@example.com', '', '');"); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// login starts a session for user 1 and returns a request carrying its cookie
+func login(t *testing.T, sc *Cache) *http.Request {
+	t.Helper()
+	w := httptest.NewRecorder()
+	if err := sc.StartSession(w, httptest.NewRequest(http.MethodPost, "/login", nil), 1, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodGet, "/user", nil)
+	for _, cookie := range w.Result().Cookies() {
+		r.AddCookie(cookie)
+	}
+	return r
+}
+
+func TestGetSession(t *testing.T) {
+	tests := []struct {
+		name    string
+		update  string
+		wantErr bool
+		// kept sessions are still there after the error
+		kept bool
+	}{
+		{name: "active", update: ""},
+		{name: "disabled", update: "UPDATE user SET disabled = TRUE;", wantErr: true},
+		{name: "deletion requested", update: "UPDATE user SET deletion_requested_at = CURRENT_TIMESTAMP;", wantErr: true},
+		{name: "deleted", update: "DELETE FROM user;", wantErr: true},
+		{name: "revoked", update: "UPDATE user SET sessions_revoked_at = 1000 * (unixepoch() + 60);", wantErr: true},
+		{name: "revoked before login", update: "UPDATE user SET sessions_revoked_at = 1000 * (unixepoch() - 60);"},
+		{name: "lookup failed", update: "ALTER TABLE user RENAME COLUMN disabled TO was_disabled;", wantErr: true, kept: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := openTestDB(t)
+			sc := NewCache(db)
+			r := login(t, sc)
+			if tt.update != "" {
+				if _, err := db.Exec(tt.update); err != nil {
+					t.Fatal(err)
+				}
+			}
+			session, err := sc.GetSession(r)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetSession() error = %v, want error %t", err, tt.wantErr)
+			}
+			if !tt.wantErr && session.Username != "alice" {
+				t.Errorf("GetSession() = %+v", session)
+			}
+			if tt.wantErr && !tt.kept && len(sc.UserSessions(1)) != 0 {
+				t.Error("the session outlived its user")
+			}
+			if tt.kept && len(sc.UserSessions(1)) != 1 {
+				t.Error("a failed lookup ended the session")
+			}
+		})
+	}
+}
+
+func TestEndSession(t *testing.T) {
+	sc := NewCache(openTestDB(t))
+	r := login(t, sc)
+	other := login(t, sc)
+
+	w := httptest.NewRecorder()
+	if err := sc.EndSession(w, r); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sc.GetSession(r); err == nil {
+		t.Error("the session still works after logging out")
+	}
+	if cookies := w.Result().Cookies(); len(cookies) != 1 || cookies[0].MaxAge >= 0 {
+		t.Errorf("logging out set cookies %+v, want the session cookie expired", cookies)
+	}
+	if _, err := sc.GetSession(other); err != nil {
+		t.Errorf("logging out ended another session: %v", err)
+	}
+
+	if ended := sc.EndUserSessions(1); ended != 1 {
+		t.Errorf("EndUserSessions() = %d, want 1", ended)
+	}
+	if _, err := sc.GetSession(other); err == nil {
+		t.Error("the session still works after ending every session of the user")
+	}
+}
+
+func TestLocale(t *testing.T) {
+	db := openTestDB(t)
+	if _, err := db.Exec("UPDATE user SET locale = 'de';"); err != nil {
+		t.Fatal(err)
+	}
+	sc := NewCache(db)
+	r := login(t, sc)
+	other := login(t, sc)
+
+	if session, ok := sc.Cached(r); !ok || session.Locale != "de" {
+		t.Errorf("Cached() = %+v, %t, want the saved locale", session, ok)
+	}
+	sc.SetLocale(1, "en")
+	for _, r := range []*http.Request{r, other} {
+		if session, ok := sc.Cached(r); !ok || session.Locale != "en" {
+			t.Errorf("Cached() after SetLocale() = %+v, %t", session, ok)
+		}
+	}
+	if _, ok := sc.Cached(httptest.NewRequest(http.MethodGet, "/", nil)); ok {
+		t.Error("Cached() found a session without a cookie")
+	}
+}
@@ -0,0 +1,52 @@
+package siteurl
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/somethingsoftware/violet-web/http/proxy"
+)
+
+// Site builds absolute urls for feeds and emails. They start with the
+// configured public url, the Host header is up to the client and a forged
+// one would put another site into password reset links and cached feeds.
+// Without a public url the request's scheme, as TLS or a trusted proxy says,
+// and host are used, serve warns about that outside dev mode.
+type Site struct {
+	base *url.URL
+}
+
+func New(publicURL string) (*Site, error) {
+	if publicURL == "" {
+		return &Site{}, nil
+	}
+	base, err := url.Parse(publicURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public url: %w", err)
+	}
+	return &Site{base: base}, nil
+}
+
+// Base is the scheme and host of the site
+func (s *Site) Base(r *http.Request) string {
+	if s.base != nil {
+		return s.base.Scheme + "://" + s.base.Host
+	}
+	return proxy.Scheme(r) + "://" + r.Host
+}
+
+// URL is the absolute url of path on this site with the query added
+func (s *Site) URL(r *http.Request, path string, query url.Values) string {
+	u := url.URL{
+		Scheme:   proxy.Scheme(r),
+		Host:     r.Host,
+		Path:     path,
+		RawQuery: query.Encode(),
+	}
+	if s.base != nil {
+		u.Scheme = s.base.Scheme
+		u.Host = s.base.Host
+	}
+	return u.String()
+}
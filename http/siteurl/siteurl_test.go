@@ -0,0 +1,73 @@
+package siteurl
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/somethingsoftware/violet-web/http/proxy"
+)
+
+func TestURL(t *testing.T) {
+	plain := httptest.NewRequest(http.MethodGet, "http://violet.example:8080/posts", nil)
+	secure := httptest.NewRequest(http.MethodGet, "https://violet.example/posts", nil)
+	secure.TLS = &tls.ConnectionState{}
+
+	s, err := New("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Base(plain); got != "http://violet.example:8080" {
+		t.Errorf("Base(plain) = %s", got)
+	}
+	if got := s.Base(secure); got != "https://violet.example" {
+		t.Errorf("Base(secure) = %s", got)
+	}
+	got := s.URL(secure, "/reset", url.Values{"token": {"a+b/c="}})
+	if want := "https://violet.example/reset?token=a%2Bb%2Fc%3D"; got != want {
+		t.Errorf("URL() = %s, want %s", got, want)
+	}
+	if got := s.URL(plain, "/feed.xml", nil); got != "http://violet.example:8080/feed.xml" {
+		t.Errorf("URL() without a query = %s", got)
+	}
+}
+
+func TestPublicURL(t *testing.T) {
+	s, err := New("https://violet.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the Host header is up to the client, it can't change the links
+	r := httptest.NewRequest(http.MethodGet, "http://evil.example/forgot", nil)
+	if got := s.Base(r); got != "https://violet.example" {
+		t.Errorf("Base() = %s", got)
+	}
+	got := s.URL(r, "/resetpass", url.Values{"token": {"abc"}})
+	if want := "https://violet.example/resetpass?token=abc"; got != want {
+		t.Errorf("URL() = %s, want %s", got, want)
+	}
+}
+
+func TestURLBehindProxy(t *testing.T) {
+	rs, err := proxy.NewResolver([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodGet, "http://violet.example/posts", nil)
+	r.RemoteAddr = "10.0.0.2:4000"
+	r.Header.Set("X-Forwarded-For", "203.0.113.7")
+	r.Header.Set("X-Forwarded-Proto", "https")
+	s, err := New("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got string
+	rs.Middleware(func(w http.ResponseWriter, r *http.Request) {
+		got = s.Base(r)
+	})(httptest.NewRecorder(), r)
+	if got != "https://violet.example" {
+		t.Errorf("Base() behind a tls terminating proxy = %s", got)
+	}
+}
@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// CREATE TABLE blob (
+// key TEXT PRIMARY KEY NOT NULL,
+// data BLOB NOT NULL,
+// created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP);
+
+var ErrNotFound = errors.New("blob not found")
+
+// Store holds uploaded files. Keys are slash separated relative paths.
+type Store interface {
+	Put(ctx context.Context, key string, data []byte) error
+	// Get returns the data and when it was stored
+	Get(ctx context.Context, key string) ([]byte, time.Time, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// DB keeps files in the blob table so the whole site stays in one file
+type DB struct {
+	db *sql.DB
+}
+
+func NewDB(db *sql.DB) *DB {
+	return &DB{db: db}
+}
+
+func (s *DB) Put(ctx context.Context, key string, data []byte) error {
+	query := "INSERT OR REPLACE INTO blob (key, data) VALUES (?, ?);"
+	if _, err := s.db.ExecContext(ctx, query, key, data); err != nil {
+		return fmt.Errorf("failed to store blob %s: %w", key, err)
+	}
+	return nil
+}
+
+func (s *DB) Get(ctx context.Context, key string) ([]byte, time.Time, error) {
+	var data []byte
+	var createdAt time.Time
+	query := "SELECT data, created_at FROM blob WHERE key = ?;"
+	err := s.db.QueryRowContext(ctx, query, key).Scan(&data, &createdAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, time.Time{}, ErrNotFound
+	} else if err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to get blob %s: %w", key, err)
+	}
+	return data, createdAt, nil
+}
+
+func (s *DB) Delete(ctx context.Context, key string) error {
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM blob WHERE key = ?;", key); err != nil {
+		return fmt.Errorf("failed to delete blob %s: %w", key, err)
+	}
+	return nil
+}
+
+// Disk keeps files under a directory on the local file system
+type Disk struct {
+	dir string
+}
+
+func NewDisk(dir string) (*Disk, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create storage dir: %w", err)
+	}
+	return &Disk{dir: dir}, nil
+}
+
+// path maps a key to a file, refusing keys that would escape the directory
+func (s *Disk) path(key string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(key))
+	if !filepath.IsLocal(clean) || strings.HasPrefix(clean, ".") {
+		return "", fmt.Errorf("invalid storage key %q", key)
+	}
+	return filepath.Join(s.dir, clean), nil
+}
+
+func (s *Disk) Put(ctx context.Context, key string, data []byte) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return fmt.Errorf("failed to create dir for %s: %w", key, err)
+	}
+	// write to a temp file first so a reader never sees half a file
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o640); err != nil {
+		return fmt.Errorf("failed to write %s: %w", key, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to move %s into place: %w", key, err)
+	}
+	return nil
+}
+
+func (s *Disk) Get(ctx context.Context, key string) ([]byte, time.Time, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, time.Time{}, ErrNotFound
+	}
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, time.Time{}, ErrNotFound
+	} else if err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to stat %s: %w", key, err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to read %s: %w", key, err)
+	}
+	return data, info.ModTime(), nil
+}
+
+func (s *Disk) Delete(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete %s: %w", key, err)
+	}
+	return nil
+}
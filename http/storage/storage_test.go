@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/glebarez/go-sqlite"
+	"github.com/somethingsoftware/violet-web/migrate"
+)
+
+func stores(t *testing.T) map[string]Store {
+	t.Helper()
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "test.db")+"?_pragma=foreign_keys(1)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := migrate.AutoUP(db, slog.New(slog.NewTextHandler(io.Discard, nil))); err != nil {
+		t.Fatal(err)
+	}
+	disk, err := NewDisk(filepath.Join(t.TempDir(), "files"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]Store{"db": NewDB(db), "disk": disk}
+}
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			if _, _, err := store.Get(ctx, "avatars/a.png"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Get() of a missing key error = %v, want ErrNotFound", err)
+			}
+			if err := store.Put(ctx, "avatars/a.png", []byte("one")); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.Put(ctx, "avatars/a.png", []byte("two")); err != nil {
+				t.Fatal(err)
+			}
+			data, stored, err := store.Get(ctx, "avatars/a.png")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != "two" || stored.IsZero() {
+				t.Errorf("Get() = %q, %v, want the second put", data, stored)
+			}
+			if err := store.Delete(ctx, "avatars/a.png"); err != nil {
+				t.Fatal(err)
+			}
+			if _, _, err := store.Get(ctx, "avatars/a.png"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Get() after Delete() error = %v, want ErrNotFound", err)
+			}
+			if err := store.Delete(ctx, "avatars/a.png"); err != nil {
+				t.Errorf("Delete() of a missing key error = %v", err)
+			}
+		})
+	}
+}
+
+func TestDiskKeys(t *testing.T) {
+	dir := t.TempDir()
+	disk, err := NewDisk(filepath.Join(dir, "files"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"../escape", "avatars/../../escape", "/etc/passwd", ".hidden", ""} {
+		if err := disk.Put(context.Background(), key, []byte("x")); err == nil {
+			t.Errorf("Put(%q) succeeded, want an error", key)
+		}
+	}
+	matches, err := filepath.Glob(filepath.Join(dir, "escape*"))
+	if err != nil || len(matches) > 0 {
+		t.Errorf("files written outside the storage dir: %v, %v", matches, err)
+	}
+}
@@ -0,0 +1,108 @@
+package tlscert
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// Reloader serves a certificate and key pair from disk and loads them again
+// when either file changes, so a renewed certificate is picked up without a
+// restart. The files are polled rather than watched since renewal tools
+// usually replace them through a rename or symlink swap.
+type Reloader struct {
+	certFile string
+	keyFile  string
+	logger   *slog.Logger
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func NewReloader(certFile, keyFile string, logger *slog.Logger) (*Reloader, error) {
+	rl := &Reloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		logger:   logger,
+	}
+	if _, err := rl.reload(); err != nil {
+		return nil, err
+	}
+	return rl, nil
+}
+
+// GetCertificate is for tls.Config.GetCertificate
+func (rl *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	rl.mu.RLock()
+	defer rl.mu.RUnlock()
+	return rl.cert, nil
+}
+
+// reload loads the pair if either file is newer than what is loaded. A pair
+// that fails to load leaves the old one in place, the cert may have been
+// written before the key.
+func (rl *Reloader) reload() (bool, error) {
+	modTime, err := rl.latestModTime()
+	if err != nil {
+		return false, err
+	}
+	rl.mu.RLock()
+	unchanged := rl.cert != nil && !modTime.After(rl.modTime)
+	rl.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(rl.certFile, rl.keyFile)
+	if err != nil {
+		return false, fmt.Errorf("failed to load key pair: %w", err)
+	}
+	rl.mu.Lock()
+	rl.cert = &cert
+	rl.modTime = modTime
+	rl.mu.Unlock()
+	return true, nil
+}
+
+func (rl *Reloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, name := range []string{rl.certFile, rl.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("failed to stat %s: %w", name, err)
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// Start checks the files for changes every interval until the context is
+// done, wg is done once it has stopped
+func (rl *Reloader) Start(ctx context.Context, wg *sync.WaitGroup, every time.Duration) {
+	ticker := time.NewTicker(every)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			reloaded, err := rl.reload()
+			if err != nil {
+				rl.logger.Error("Failed to reload TLS certificate", "error", err)
+			} else if reloaded {
+				rl.logger.Info("Reloaded TLS certificate", "cert", rl.certFile)
+			}
+		}
+	}()
+}
@@ -0,0 +1,143 @@
+package tlscert
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log/slog"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// writePair writes a self-signed certificate for name and its key, with the
+// files' modification time set to modTime
+func writePair(t *testing.T, certFile, keyFile, name string, modTime time.Time) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	for file, data := range map[string][]byte{certFile: certPEM, keyFile: keyPEM} {
+		if err := os.WriteFile(file, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(file, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func commonName(t *testing.T, rl *Reloader) string {
+	t.Helper()
+	cert, err := rl.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.Subject.CommonName
+}
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	start := time.Now().Add(-time.Hour)
+	writePair(t, certFile, keyFile, "first", start)
+
+	rl, err := NewReloader(certFile, keyFile, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := commonName(t, rl); name != "first" {
+		t.Fatalf("certificate = %s, want first", name)
+	}
+	if reloaded, err := rl.reload(); err != nil || reloaded {
+		t.Errorf("reload() of unchanged files = %v, %v", reloaded, err)
+	}
+
+	// a renewal caught half written keeps serving the old pair
+	if err := os.WriteFile(keyFile, []byte("not a key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if reloaded, err := rl.reload(); err == nil || reloaded {
+		t.Errorf("reload() of a broken pair = %v, %v, want an error", reloaded, err)
+	}
+	if name := commonName(t, rl); name != "first" {
+		t.Errorf("certificate after a failed reload = %s, want first", name)
+	}
+
+	writePair(t, certFile, keyFile, "second", start.Add(time.Minute))
+	if reloaded, err := rl.reload(); err != nil || !reloaded {
+		t.Errorf("reload() of a renewed pair = %v, %v", reloaded, err)
+	}
+	if name := commonName(t, rl); name != "second" {
+		t.Errorf("certificate after renewal = %s, want second", name)
+	}
+}
+
+func TestNewReloaderErrors(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	if _, err := NewReloader(certFile, keyFile, logger); err == nil {
+		t.Error("NewReloader() of missing files succeeded")
+	}
+	writePair(t, certFile, keyFile, "first", time.Now())
+	otherCert, otherKey := filepath.Join(dir, "other-cert.pem"), filepath.Join(dir, "other-key.pem")
+	writePair(t, otherCert, otherKey, "other", time.Now())
+	if _, err := NewReloader(certFile, otherKey, logger); err == nil {
+		t.Error("NewReloader() of a mismatched pair succeeded")
+	}
+}
+
+func TestStart(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	start := time.Now().Add(-time.Hour)
+	writePair(t, certFile, keyFile, "first", start)
+	rl, err := NewReloader(certFile, keyFile, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	rl.Start(ctx, &wg, 10*time.Millisecond)
+	writePair(t, certFile, keyFile, "second", start.Add(time.Minute))
+	deadline := time.Now().Add(5 * time.Second)
+	for commonName(t, rl) != "second" {
+		if time.Now().After(deadline) {
+			t.Fatal("renewed certificate wasn't picked up")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	wg.Wait()
+}
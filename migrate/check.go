@@ -0,0 +1,75 @@
+package migrate
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// Check runs SQLite's integrity_check and foreign_key_check and returns what
+// they found, nothing means the database is sound. Before migration 19 post
+// and forgot_password point at a users table that doesn't exist, instead of
+// every one of their rows it lists those whose user is gone.
+func Check(db *sql.DB) ([]string, error) {
+	var problems []string
+	rows, err := db.Query("PRAGMA integrity_check;")
+	if err != nil {
+		return nil, fmt.Errorf("failed to check integrity: %w", err)
+	}
+	for rows.Next() {
+		var result string
+		if err := rows.Scan(&result); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan integrity check: %w", err)
+		}
+		if result != "ok" {
+			problems = append(problems, result)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to check integrity: %w", err)
+	}
+
+	fkProblems, missingUsers, err := foreignKeyProblems(db)
+	if err != nil {
+		return nil, err
+	}
+	problems = append(problems, fkProblems...)
+
+	if missingUsers {
+		orphaned, err := orphans(db, userReferences)
+		if err != nil {
+			return nil, err
+		}
+		problems = append(problems, orphaned...)
+	}
+	return problems, nil
+}
+
+// foreignKeyProblems runs foreign_key_check. References to the users table
+// that never existed aren't problems, they are reported with missingUsers so
+// the caller can look for orphans itself.
+func foreignKeyProblems(q querier) (problems []string, missingUsers bool, err error) {
+	rows, err := q.Query("PRAGMA foreign_key_check;")
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to check foreign keys: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var table, parent string
+		var rowID sql.NullInt64
+		var key int
+		if err := rows.Scan(&table, &rowID, &parent, &key); err != nil {
+			return nil, false, fmt.Errorf("failed to scan foreign key check: %w", err)
+		}
+		if parent == "users" {
+			missingUsers = true
+			continue
+		}
+		problems = append(problems, fmt.Sprintf("row %d of %s points at a missing %s", rowID.Int64, table, parent))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, fmt.Errorf("failed to check foreign keys: %w", err)
+	}
+	return problems, missingUsers, nil
+}
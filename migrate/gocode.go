@@ -0,0 +1,131 @@
+package migrate
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/somethingsoftware/violet-web/http/markdown"
+)
+
+// goMigrations change data in ways SQL can't. They take their version from
+// the same sequence as the files in sql and run in order with them.
+var goMigrations = []migration{
+	{
+		version:  17,
+		name:     "render post bodies",
+		upFunc:   renderPostBodies,
+		downFunc: func(tx *sql.Tx) error { return nil },
+	},
+	{
+		version:  18,
+		name:     "check references",
+		upFunc:   checkReferences,
+		downFunc: func(tx *sql.Tx) error { return nil },
+	},
+}
+
+// checkReferences stops migrating when there are rows migration 19 can't
+// move under its foreign keys. Deleting them would lose what users wrote
+// without anyone knowing, so the operator has to decide what to do.
+func checkReferences(tx *sql.Tx) error {
+	problems, err := orphans(tx, references)
+	if err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%d rows belong to rows that don't exist, fix or delete them and migrate again: %s",
+			len(problems), strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// reference is a column pointing at the id of another table
+type reference struct {
+	table    string
+	column   string
+	parent   string
+	relation string
+}
+
+// references are every column migration 19 puts under a foreign key
+var references = []reference{
+	{table: "post", column: "user_id", parent: "user", relation: "belongs to"},
+	{table: "forgot_password", column: "user_id", parent: "user", relation: "belongs to"},
+	{table: "data_export", column: "user_id", parent: "user", relation: "belongs to"},
+	{table: "comment", column: "post_id", parent: "post", relation: "is on"},
+	{table: "comment", column: "user_id", parent: "user", relation: "belongs to"},
+	{table: "comment", column: "parent_id", parent: "comment", relation: "replies to"},
+}
+
+// userReferences pointed at a users table that never existed before
+// migration 19, foreign_key_check can't tell which of their rows are orphaned
+var userReferences = references[:2]
+
+type querier interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+// orphans lists the rows whose reference points at a row that doesn't exist,
+// references that are NULL are left alone
+func orphans(q querier, refs []reference) ([]string, error) {
+	var problems []string
+	for _, ref := range refs {
+		query := fmt.Sprintf("SELECT id, %s FROM %s WHERE %s NOT IN (SELECT id FROM %s) ORDER BY id;",
+			ref.column, ref.table, ref.column, ref.parent)
+		rows, err := q.Query(query)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find orphaned %s rows: %w", ref.table, err)
+		}
+		for rows.Next() {
+			var id, parentID int64
+			if err := rows.Scan(&id, &parentID); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("failed to scan orphaned %s row: %w", ref.table, err)
+			}
+			problems = append(problems, fmt.Sprintf("%s %d %s %s %d which doesn't exist",
+				ref.table, id, ref.relation, ref.parent, parentID))
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("failed to find orphaned %s rows: %w", ref.table, err)
+		}
+	}
+	return problems, nil
+}
+
+// renderPostBodies fills in body_html for the posts written before it was
+// added, until now they were rendered on every read
+func renderPostBodies(tx *sql.Tx) error {
+	rows, err := tx.Query("SELECT id, body FROM post WHERE body_html = '' AND body != '';")
+	if err != nil {
+		return fmt.Errorf("failed to get posts: %w", err)
+	}
+	bodies := map[int64]string{}
+	for rows.Next() {
+		var id int64
+		var body string
+		if err := rows.Scan(&id, &body); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan post: %w", err)
+		}
+		bodies[id] = body
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to get posts: %w", err)
+	}
+
+	for id, body := range bodies {
+		html, err := markdown.Render(body)
+		if err != nil {
+			return fmt.Errorf("failed to render post %d: %w", id, err)
+		}
+		// updating body_html alone still fires post_fts_update, which
+		// reindexes the same title and body
+		if _, err := tx.Exec("UPDATE post SET body_html = ? WHERE id = ?;", string(html), id); err != nil {
+			return fmt.Errorf("failed to update post %d: %w", id, err)
+		}
+	}
+	return nil
+}
@@ -1,106 +1,412 @@
 package migrate
 
 import (
+	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"log/slog"
+	"strings"
+	"time"
 )
 
+// migration is one schema change, written in SQL or for changes SQL can't
+// make as Go functions. down undoes up, a migration that can't be undone has
+// none and stops Down from going past it.
 type migration struct {
-	version int
-	name    string
-	sql     string
+	version  int
+	name     string
+	up       string
+	down     string
+	upFunc   func(tx *sql.Tx) error
+	downFunc func(tx *sql.Tx) error
 }
 
+// checksum is recorded when the migration is applied, a migration edited
+// afterwards no longer matches and is refused. Whitespace is left out so
+// reformatting a file isn't an edit. Go migrations are only known by name.
+func (m migration) checksum() string {
+	text := "go: " + m.name
+	if m.upFunc == nil {
+		text = strings.Join(strings.Fields(m.up), " ")
+	}
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:])
+}
+
+// literalChecksum is the checksum of up as it was written when migrations
+// were Go string literals, indented three tabs past the first line
+func (m migration) literalChecksum() string {
+	text := strings.ReplaceAll(strings.TrimSuffix(m.up, "\n"), "\n", "\n\t\t\t")
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:])
+}
+
+func (m migration) apply(tx *sql.Tx) error {
+	if m.upFunc != nil {
+		return m.upFunc(tx)
+	}
+	_, err := tx.Exec(m.up)
+	return err
+}
+
+func (m migration) revert(tx *sql.Tx) error {
+	if m.downFunc != nil {
+		return m.downFunc(tx)
+	}
+	_, err := tx.Exec(m.down)
+	return err
+}
+
+func (m migration) reversible() bool {
+	return m.down != "" || m.downFunc != nil
+}
+
+// Step is a migration and whether the database has had it applied. Changed
+// means the migration was edited after it was applied.
+type Step struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+	Changed   bool
+}
+
+type applied struct {
+	checksum  string
+	appliedAt time.Time
+}
+
+const createMigrationTable = `CREATE TABLE IF NOT EXISTS schema_migration (
+	version INTEGER PRIMARY KEY NOT NULL,
+	name TEXT NOT NULL,
+	checksum TEXT NOT NULL,
+	applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);`
+
+// AutoUP applies every pending migration, the server runs it on startup
 func AutoUP(db *sql.DB, logger *slog.Logger) error {
-	const createVersionTable = "CREATE TABLE IF NOT EXISTS migration_version (version INTEGER);"
-	if _, err := db.Exec(createVersionTable); err != nil {
-		return fmt.Errorf("Failed to create migration_version table: %v", err)
+	_, err := Up(db, logger, false)
+	return err
+}
+
+// Up applies the pending migrations in order and returns them. Each one is
+// applied in a transaction together with its schema_migration row so a
+// failing migration leaves the database as it was before it. A dry run
+// applies them all in one transaction and rolls it back.
+func Up(db *sql.DB, logger *slog.Logger, dryRun bool) ([]Step, error) {
+	list, done, err := prepare(db, logger)
+	if err != nil {
+		return nil, err
 	}
 
-	// get the current version
-	var currentVersion int
-	err := db.QueryRow("SELECT version FROM migration_version").Scan(&currentVersion)
-	if errors.Is(err, sql.ErrNoRows) {
-		currentVersion = 0
-		if _, err := db.Exec("INSERT INTO migration_version (version) VALUES (0)"); err != nil {
-			return fmt.Errorf("failed to insert initial version: %v", err)
+	var pending []migration
+	for _, m := range list {
+		if _, ok := done[m.version]; !ok {
+			pending = append(pending, m)
 		}
-	} else if err != nil {
-		return fmt.Errorf("failed to get current version: %v", err)
+	}
+	err = run(db, pending, dryRun, func(tx *sql.Tx, m migration) error {
+		if err := m.apply(tx); err != nil {
+			return fmt.Errorf("failed to execute migration %d (%s): %w", m.version, m.name, err)
+		}
+		query := "INSERT INTO schema_migration (version, name, checksum) VALUES (?, ?, ?);"
+		if _, err := tx.Exec(query, m.version, m.name, m.checksum()); err != nil {
+			return fmt.Errorf("failed to record migration %d: %w", m.version, err)
+		}
+		logger.Debug("Migrated up", "version", m.version, "name", m.name, "dry_run", dryRun)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return steps(pending), nil
+}
+
+// Down reverts the applied migrations above target, newest first, each in
+// its own transaction like Up
+func Down(db *sql.DB, logger *slog.Logger, target int, dryRun bool) ([]Step, error) {
+	list, done, err := prepare(db, logger)
+	if err != nil {
+		return nil, err
 	}
 
-	for _, m := range migrationList() {
-		if m.version <= currentVersion {
+	var reverting []migration
+	for i := len(list) - 1; i >= 0; i-- {
+		m := list[i]
+		if _, ok := done[m.version]; !ok || m.version <= target {
 			continue
 		}
-		if _, err := db.Exec(m.sql); err != nil {
-			return fmt.Errorf("failed to execute migration %d (%s): %v", m.version, m.name, err)
+		if !m.reversible() {
+			return nil, fmt.Errorf("migration %d (%s) can't be reverted", m.version, m.name)
 		}
-		if _, err := db.Exec("UPDATE migration_version SET version = ?", m.version); err != nil {
-			return fmt.Errorf("failed to update migration version: %v", err)
+		reverting = append(reverting, m)
+	}
+	err = run(db, reverting, dryRun, func(tx *sql.Tx, m migration) error {
+		if err := m.revert(tx); err != nil {
+			return fmt.Errorf("failed to revert migration %d (%s): %w", m.version, m.name, err)
+		}
+		if _, err := tx.Exec("DELETE FROM schema_migration WHERE version = ?;", m.version); err != nil {
+			return fmt.Errorf("failed to record reverting migration %d: %w", m.version, err)
+		}
+		logger.Debug("Migrated down", "version", m.version, "name", m.name, "dry_run", dryRun)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return steps(reverting), nil
+}
+
+// prepare loads the migrations and the applied ones and checks they agree
+func prepare(db *sql.DB, logger *slog.Logger) ([]migration, map[int]applied, error) {
+	list, err := migrationList()
+	if err != nil {
+		return nil, nil, err
+	}
+	done, err := load(db, logger, list)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := verify(list, done); err != nil {
+		return nil, nil, err
+	}
+	return list, done, nil
+}
+
+// run applies fn to each migration in a transaction of its own, or in one
+// that is rolled back for a dry run so later migrations still see the
+// earlier ones. Foreign keys are off while they run, rebuilding a table means
+// dropping it while others still point at it and SQLite can't switch them
+// inside a transaction. Instead the keys are checked before each migration is
+// committed, one that leaves rows dangling is rolled back.
+func run(db *sql.DB, migrations []migration, dryRun bool, fn func(tx *sql.Tx, m migration) error) error {
+	ctx := context.Background()
+	fn = checked(fn)
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer conn.Close()
+	var foreignKeys bool
+	if err := conn.QueryRowContext(ctx, "PRAGMA foreign_keys;").Scan(&foreignKeys); err != nil {
+		return fmt.Errorf("failed to get foreign keys setting: %w", err)
+	}
+	if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF;"); err != nil {
+		return fmt.Errorf("failed to turn off foreign keys: %w", err)
+	}
+	defer conn.ExecContext(ctx, fmt.Sprintf("PRAGMA foreign_keys = %t;", foreignKeys))
+
+	if dryRun {
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("failed to begin transaction: %w", err)
 		}
-		currentVersion = m.version
+		defer tx.Rollback()
+		for _, m := range migrations {
+			if err := fn(tx, m); err != nil {
+				return err
+			}
+		}
+		return nil
 	}
-	logger.Debug("Migrated up", "version", currentVersion)
 
+	for _, m := range migrations {
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("failed to begin transaction: %w", err)
+		}
+		if err := fn(tx, m); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit migration %d: %w", m.version, err)
+		}
+	}
 	return nil
 }
 
-func migrationList() []migration {
-	return []migration{
-		{
-			1, "Create Users Table",
-			`CREATE TABLE user (
-				id INTEGER PRIMARY KEY AUTOINCREMENT,
-				username TEXT UNIQUE NOT NULL,
-				email TEXT UNIQUE NOT NULL,
-				email_verified BOOLEAN NOT NULL DEFAULT FALSE,
-				salt TEXT NOT NULL,
-				password_hash TEXT NOT NULL,
-				created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
-			);`,
-		},
-		{
-			2, "Create Posts Table",
-			`CREATE TABLE post (
-				id INTEGER PRIMARY KEY AUTOINCREMENT,
-				user_id INTEGER NOT NULL,
-				title TEXT NOT NULL,
-				body TEXT NOT NULL,
-				created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
-				FOREIGN KEY (user_id) REFERENCES users(id)
-			);`,
-		},
-		{
-			3, "Create Salt Table",
-			`CREATE TABLE salt (
-				intermediate_hash TEXT PRIMARY KEY UNIQUE NOT NULL,
-				salt TEXT NOT NULL
-			);`,
-		},
-		{
-			4, "Create CSRF Table",
-			`CREATE TABLE csrf (
-				id INTEGER PRIMARY KEY UNIQUE NOT NULL,
-				csrf_token TEXT NOT NULL,
-				used BOOLEAN NOT NULL DEFAULT FALSE,
-				user_agent TEXT NOT NULL,
-				ip TEXT NOT NULL,
-				created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
-			);`,
-		},
-		{
-			5, "Create forgot password tokens table",
-			`CREATE TABLE forgot_password (
-				id INTEGER PRIMARY KEY AUTOINCREMENT,
-				user_id INTEGER NOT NULL,
-				token TEXT NOT NULL,
-				used BOOLEAN NOT NULL DEFAULT FALSE,
-				created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
-				FOREIGN KEY (user_id) REFERENCES users(id)
-			);`,
-		},
+// checked runs foreign_key_check after fn in the same transaction
+func checked(fn func(tx *sql.Tx, m migration) error) func(tx *sql.Tx, m migration) error {
+	return func(tx *sql.Tx, m migration) error {
+		if err := fn(tx, m); err != nil {
+			return err
+		}
+		problems, _, err := foreignKeyProblems(tx)
+		if err != nil {
+			return err
+		}
+		if len(problems) > 0 {
+			return fmt.Errorf("after migration %d (%s) %d rows point at rows that don't exist, fix or delete them and migrate again: %s",
+				m.version, m.name, len(problems), strings.Join(problems, "; "))
+		}
+		return nil
+	}
+}
+
+func steps(migrations []migration) []Step {
+	var steps []Step
+	for _, m := range migrations {
+		steps = append(steps, Step{Version: m.version, Name: m.name})
+	}
+	return steps
+}
+
+// load reads the applied migrations, creating the table on first use. A
+// database from before checksums only has a migration_version row, its
+// migrations are recorded with the checksums of the code as it is now.
+func load(db *sql.DB, logger *slog.Logger, list []migration) (map[int]applied, error) {
+	if _, err := db.Exec(createMigrationTable); err != nil {
+		return nil, fmt.Errorf("failed to create schema_migration table: %w", err)
+	}
+	if err := adoptLegacyVersion(db, logger, list); err != nil {
+		return nil, err
+	}
+	if err := adoptLiteralChecksums(db, list); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT version, checksum, applied_at FROM schema_migration;")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get applied migrations: %w", err)
+	}
+	defer rows.Close()
+	done := map[int]applied{}
+	for rows.Next() {
+		var version int
+		var a applied
+		if err := rows.Scan(&version, &a.checksum, &a.appliedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan applied migration: %w", err)
+		}
+		done[version] = a
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get applied migrations: %w", err)
+	}
+	return done, nil
+}
+
+func adoptLegacyVersion(db *sql.DB, logger *slog.Logger, list []migration) error {
+	var exists bool
+	query := "SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'migration_version');"
+	if err := db.QueryRow(query).Scan(&exists); err != nil {
+		return fmt.Errorf("failed to check for migration_version table: %w", err)
+	}
+	if !exists {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	var version int
+	err = tx.QueryRow("SELECT version FROM migration_version;").Scan(&version)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("failed to get current version: %w", err)
+	}
+	for _, m := range list {
+		if m.version > version {
+			break
+		}
+		query := "INSERT INTO schema_migration (version, name, checksum) VALUES (?, ?, ?);"
+		if _, err := tx.Exec(query, m.version, m.name, m.checksum()); err != nil {
+			return fmt.Errorf("failed to record migration %d: %w", m.version, err)
+		}
+	}
+	if _, err := tx.Exec("DROP TABLE migration_version;"); err != nil {
+		return fmt.Errorf("failed to drop migration_version table: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit migration history: %w", err)
+	}
+	logger.Info("Recorded migration history from the old version table", "version", version)
+	return nil
+}
+
+// adoptLiteralChecksums rewrites checksums recorded while the migrations
+// were Go string literals to the ones of the files they moved to
+func adoptLiteralChecksums(db *sql.DB, list []migration) error {
+	for _, m := range list {
+		if m.upFunc != nil {
+			continue
+		}
+		query := "UPDATE schema_migration SET checksum = ? WHERE version = ? AND checksum = ?;"
+		if _, err := db.Exec(query, m.checksum(), m.version, m.literalChecksum()); err != nil {
+			return fmt.Errorf("failed to update checksum of migration %d: %w", m.version, err)
+		}
+	}
+	return nil
+}
+
+// verify refuses a database with history this code doesn't have, applied
+// migrations that were edited since or are unknown to this binary
+func verify(list []migration, done map[int]applied) error {
+	known := map[int]bool{}
+	for _, m := range list {
+		known[m.version] = true
+		if a, ok := done[m.version]; ok && a.checksum != m.checksum() {
+			return fmt.Errorf("migration %d (%s) was changed after it was applied", m.version, m.name)
+		}
+	}
+	for version := range done {
+		if !known[version] {
+			return fmt.Errorf("database has migration %d which this version doesn't know about", version)
+		}
+	}
+	return nil
+}
+
+// Version is the newest migration applied to the database
+func Version(db *sql.DB) (int, error) {
+	list, err := migrationList()
+	if err != nil {
+		return 0, err
+	}
+	done, err := load(db, slog.Default(), list)
+	if err != nil {
+		return 0, err
+	}
+	version := 0
+	for v := range done {
+		version = max(version, v)
+	}
+	return version, nil
+}
+
+// Latest is the version Up migrates to
+func Latest() (int, error) {
+	list, err := migrationList()
+	if err != nil {
+		return 0, err
+	}
+	return list[len(list)-1].version, nil
+}
+
+// Status lists every migration, whether it's applied and whether it was
+// changed since
+func Status(db *sql.DB) ([]Step, error) {
+	list, err := migrationList()
+	if err != nil {
+		return nil, err
+	}
+	done, err := load(db, slog.Default(), list)
+	if err != nil {
+		return nil, err
+	}
+	var steps []Step
+	for _, m := range list {
+		step := Step{Version: m.version, Name: m.name}
+		if a, ok := done[m.version]; ok {
+			step.Applied = true
+			step.AppliedAt = a.appliedAt
+			step.Changed = a.checksum != m.checksum()
+		}
+		steps = append(steps, step)
 	}
+	return steps, nil
 }
@@ -0,0 +1,539 @@
+package migrate
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	_ "github.com/glebarez/go-sqlite"
+)
+
+func sqlFile(s string) *fstest.MapFile {
+	return &fstest.MapFile{Data: []byte(s)}
+}
+
+func TestParse(t *testing.T) {
+	noop := func(tx *sql.Tx) error { return nil }
+	tests := []struct {
+		name     string
+		files    fstest.MapFS
+		coded    []migration
+		versions []int
+		wantErr  string
+	}{
+		{
+			name: "up and down",
+			files: fstest.MapFS{
+				"sql/0001_create_a.up.sql":   sqlFile("CREATE TABLE a (id INTEGER);"),
+				"sql/0001_create_a.down.sql": sqlFile("DROP TABLE a;"),
+				"sql/0002_create_b.up.sql":   sqlFile("CREATE TABLE b (id INTEGER);"),
+			},
+			versions: []int{1, 2},
+		},
+		{
+			name: "go migration between files",
+			files: fstest.MapFS{
+				"sql/0001_create_a.up.sql": sqlFile("CREATE TABLE a (id INTEGER);"),
+				"sql/0003_create_b.up.sql": sqlFile("CREATE TABLE b (id INTEGER);"),
+			},
+			coded:    []migration{{version: 2, name: "fill a", upFunc: noop}},
+			versions: []int{1, 2, 3},
+		},
+		{
+			name: "gap",
+			files: fstest.MapFS{
+				"sql/0001_create_a.up.sql": sqlFile("CREATE TABLE a (id INTEGER);"),
+				"sql/0003_create_b.up.sql": sqlFile("CREATE TABLE b (id INTEGER);"),
+			},
+			wantErr: "migration 2 is missing",
+		},
+		{
+			name: "not starting at one",
+			files: fstest.MapFS{
+				"sql/0002_create_a.up.sql": sqlFile("CREATE TABLE a (id INTEGER);"),
+			},
+			wantErr: "migration 1 is missing",
+		},
+		{
+			name: "duplicate version",
+			files: fstest.MapFS{
+				"sql/0001_create_a.up.sql": sqlFile("CREATE TABLE a (id INTEGER);"),
+				"sql/0001_create_b.up.sql": sqlFile("CREATE TABLE b (id INTEGER);"),
+			},
+			wantErr: "migration 1 is given twice",
+		},
+		{
+			name: "go migration taking a file's version",
+			files: fstest.MapFS{
+				"sql/0001_create_a.up.sql": sqlFile("CREATE TABLE a (id INTEGER);"),
+			},
+			coded:   []migration{{version: 1, name: "fill a", upFunc: noop}},
+			wantErr: "migration 1 is given twice",
+		},
+		{
+			name: "down without up",
+			files: fstest.MapFS{
+				"sql/0001_create_a.down.sql": sqlFile("DROP TABLE a;"),
+			},
+			wantErr: "has a down but no up",
+		},
+		{
+			name: "badly named file",
+			files: fstest.MapFS{
+				"sql/0001_create_a.up.sql": sqlFile("CREATE TABLE a (id INTEGER);"),
+				"sql/2_create_b.sql":       sqlFile("CREATE TABLE b (id INTEGER);"),
+			},
+			wantErr: "isn't named",
+		},
+		{
+			name:    "empty",
+			files:   fstest.MapFS{"sql": &fstest.MapFile{Mode: fs.ModeDir | 0o755}},
+			wantErr: "there are no migrations",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			list, err := parse(tt.files, "sql", tt.coded)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parse() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var versions []int
+			for _, m := range list {
+				versions = append(versions, m.version)
+			}
+			if len(versions) != len(tt.versions) {
+				t.Fatalf("versions = %v, want %v", versions, tt.versions)
+			}
+			for i := range versions {
+				if versions[i] != tt.versions[i] {
+					t.Fatalf("versions = %v, want %v", versions, tt.versions)
+				}
+			}
+		})
+	}
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	list, err := migrationList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range list {
+		if m.upFunc == nil && strings.TrimSpace(m.up) == "" {
+			t.Errorf("migration %d (%s) has an empty up", m.version, m.name)
+		}
+		if !m.reversible() {
+			t.Errorf("migration %d (%s) can't be reverted", m.version, m.name)
+		}
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	a := migration{up: "CREATE TABLE a (\n\tid INTEGER\n);\n"}
+	b := migration{up: "CREATE TABLE a (  id INTEGER );"}
+	c := migration{up: "CREATE TABLE a (\n\tid TEXT\n);\n"}
+	if a.checksum() != b.checksum() {
+		t.Error("reformatting changed the checksum")
+	}
+	if a.checksum() == c.checksum() {
+		t.Error("editing didn't change the checksum")
+	}
+	coded := migration{name: "fill a", upFunc: func(tx *sql.Tx) error { return nil }}
+	if coded.checksum() == (migration{name: "fill b", upFunc: coded.upFunc}).checksum() {
+		t.Error("go migrations with different names have the same checksum")
+	}
+}
+
+// TestLiteralChecksum checks the files still match the checksums recorded
+// while the migrations were Go string literals
+func TestLiteralChecksum(t *testing.T) {
+	list, err := migrationList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	literal := "CREATE TABLE salt (\n\t\t\t\tintermediate_hash TEXT PRIMARY KEY UNIQUE NOT NULL,\n\t\t\t\tsalt TEXT NOT NULL\n\t\t\t);"
+	sum := sha256.Sum256([]byte(literal))
+	if got := list[2].literalChecksum(); got != hex.EncodeToString(sum[:]) {
+		t.Errorf("literalChecksum() of migration 3 = %s, want %s", got, hex.EncodeToString(sum[:]))
+	}
+}
+
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "test.db")+"?_pragma=foreign_keys(1)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestUpDown(t *testing.T) {
+	db := openTestDB(t)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	latest, err := Latest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps, err := Up(db, logger, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(steps) != latest {
+		t.Errorf("dry run applied %d migrations, want %d", len(steps), latest)
+	}
+	if version, err := Version(db); err != nil || version != 0 {
+		t.Fatalf("Version() after dry run = %d, %v, want 0", version, err)
+	}
+
+	if err := AutoUP(db, logger); err != nil {
+		t.Fatal(err)
+	}
+	if version, err := Version(db); err != nil || version != latest {
+		t.Fatalf("Version() = %d, %v, want %d", version, err, latest)
+	}
+	if steps, err := Up(db, logger, false); err != nil || len(steps) != 0 {
+		t.Errorf("Up() of an up to date database = %v, %v", steps, err)
+	}
+	if problems, err := Check(db); err != nil || len(problems) > 0 {
+		t.Fatalf("Check() = %v, %v", problems, err)
+	}
+
+	if _, err := Down(db, logger, 0, false); err != nil {
+		t.Fatal(err)
+	}
+	if version, err := Version(db); err != nil || version != 0 {
+		t.Fatalf("Version() after down = %d, %v, want 0", version, err)
+	}
+	if _, err := Up(db, logger, false); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestFailedMigration checks a failing migration leaves the database as it
+// was before it, schema_migration row included
+func TestFailedMigration(t *testing.T) {
+	db := openTestDB(t)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	if err := AutoUP(db, logger); err != nil {
+		t.Fatal(err)
+	}
+	latest, err := Latest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	broken := []migration{{version: latest + 1, name: "broken", upFunc: func(tx *sql.Tx) error {
+		if _, err := tx.Exec("CREATE TABLE half (id INTEGER);"); err != nil {
+			return err
+		}
+		return errors.New("broken")
+	}}}
+	err = run(db, broken, false, func(tx *sql.Tx, m migration) error {
+		if err := m.apply(tx); err != nil {
+			return err
+		}
+		_, err := tx.Exec("INSERT INTO schema_migration (version, name, checksum) VALUES (?, ?, ?);", m.version, m.name, m.checksum())
+		return err
+	})
+	if err == nil {
+		t.Fatal("run() of a broken migration succeeded")
+	}
+	var exists bool
+	if err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE name = 'half');").Scan(&exists); err != nil || exists {
+		t.Errorf("table from the failed migration exists: %t, %v", exists, err)
+	}
+	if version, err := Version(db); err != nil || version != latest {
+		t.Errorf("Version() = %d, %v, want %d", version, err, latest)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	db := openTestDB(t)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	if err := AutoUP(db, logger); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Exec("UPDATE schema_migration SET checksum = 'edited' WHERE version = 3;"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Up(db, logger, false); err == nil || !strings.Contains(err.Error(), "changed after it was applied") {
+		t.Errorf("Up() with an edited migration error = %v", err)
+	}
+	steps, err := Status(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !steps[2].Changed {
+		t.Error("Status() doesn't show migration 3 as changed")
+	}
+
+	list, err := migrationList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("UPDATE schema_migration SET checksum = ? WHERE version = 3;", list[2].checksum()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO schema_migration (version, name, checksum) VALUES (999, 'future', '');"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Up(db, logger, false); err == nil || !strings.Contains(err.Error(), "doesn't know about") {
+		t.Errorf("Up() with an unknown migration error = %v", err)
+	}
+}
+
+func TestAdoptLiteralChecksums(t *testing.T) {
+	db := openTestDB(t)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	if err := AutoUP(db, logger); err != nil {
+		t.Fatal(err)
+	}
+	list, err := migrationList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// as a database migrated before the files were split out has them
+	for _, m := range list {
+		if m.upFunc != nil {
+			continue
+		}
+		query := "UPDATE schema_migration SET checksum = ? WHERE version = ?;"
+		if _, err := db.Exec(query, m.literalChecksum(), m.version); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := Up(db, logger, false); err != nil {
+		t.Fatalf("Up() with literal checksums: %v", err)
+	}
+}
+
+func TestAdoptLegacyVersion(t *testing.T) {
+	db := openTestDB(t)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	list, err := migrationList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a database from before schema_migration, at version 3
+	for _, m := range list[:3] {
+		if _, err := db.Exec(m.up); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.Exec("CREATE TABLE migration_version (version INTEGER NOT NULL); INSERT INTO migration_version VALUES (3);"); err != nil {
+		t.Fatal(err)
+	}
+
+	steps, err := Up(db, logger, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if steps[0].Version != 4 {
+		t.Errorf("first applied migration = %d, want 4", steps[0].Version)
+	}
+	var exists bool
+	if err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE name = 'migration_version');").Scan(&exists); err != nil || exists {
+		t.Errorf("migration_version still exists: %t, %v", exists, err)
+	}
+}
+
+func TestRenderPostBodies(t *testing.T) {
+	db := openTestDB(t)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	if err := AutoUP(db, logger); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Down(db, logger, 16, false); err != nil {
+		t.Fatal(err)
+	}
+	// post still points at users here, the keys are off like they were then
+	db.SetMaxOpenConns(1)
+	queries := []string{
+		"PRAGMA foreign_keys = OFF;",
+		"INSERT INTO user (id, username, email, salt, password_hash) VALUES (1, 'alice', 'alice@example.com', '', '');",
+		"INSERT INTO post (id, user_id, title, body, body_html) VALUES (1, 1, 'old', '**old** <script>x</script>', '');",
+		"INSERT INTO post (id, user_id, title, body, body_html) VALUES (2, 1, 'new', 'new', '<p>kept</p>');",
+		"PRAGMA foreign_keys = ON;",
+	}
+	for _, query := range queries {
+		if _, err := db.Exec(query); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := Up(db, logger, false); err != nil {
+		t.Fatal(err)
+	}
+	for id, want := range map[int]string{1: "<strong>old</strong>", 2: "<p>kept</p>"} {
+		var html string
+		if err := db.QueryRow("SELECT body_html FROM post WHERE id = ?;", id).Scan(&html); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(html, want) || strings.Contains(html, "<script") {
+			t.Errorf("body_html of post %d = %s, want %s", id, html, want)
+		}
+	}
+}
+
+// orphanedDB is a database at version with a row left behind under each
+// reference migration 19 puts a foreign key on
+func orphanedDB(t *testing.T, version int) (*sql.DB, *slog.Logger) {
+	t.Helper()
+	db := openTestDB(t)
+	db.SetMaxOpenConns(1)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	if err := AutoUP(db, logger); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Down(db, logger, version, false); err != nil {
+		t.Fatal(err)
+	}
+	// before 19 nothing kept these from outliving what they point at
+	queries := []string{
+		"PRAGMA foreign_keys = OFF;",
+		"INSERT INTO user (id, username, email, salt, password_hash) VALUES (1, 'alice', 'alice@example.com', '', '');",
+		"INSERT INTO post (id, user_id, title, body) VALUES (1, 1, 'kept', '');",
+		"INSERT INTO post (id, user_id, title, body) VALUES (2, 999, 'left behind', '');",
+		"INSERT INTO forgot_password (id, user_id, token) VALUES (1, 999, 'token');",
+		"INSERT INTO data_export (id, user_id, token) VALUES (1, 999, 'token');",
+		"INSERT INTO comment (id, post_id, user_id, body) VALUES (1, 1, 1, 'kept');",
+		"INSERT INTO comment (id, post_id, user_id, body) VALUES (2, 999, 1, 'on a deleted post');",
+		"INSERT INTO comment (id, post_id, user_id, body) VALUES (3, 1, 999, 'by a deleted user');",
+		"INSERT INTO comment (id, post_id, user_id, parent_id, body) VALUES (4, 1, 1, 999, 'reply to nothing');",
+		"PRAGMA foreign_keys = ON;",
+	}
+	for _, query := range queries {
+		if _, err := db.Exec(query); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db, logger
+}
+
+var wantOrphans = []string{
+	"post 2 belongs to user 999",
+	"forgot_password 1 belongs to user 999",
+	"data_export 1 belongs to user 999",
+	"comment 2 is on post 999",
+	"comment 3 belongs to user 999",
+	"comment 4 replies to comment 999",
+}
+
+func TestOrphans(t *testing.T) {
+	db, logger := orphanedDB(t, 17)
+
+	_, err := Up(db, logger, false)
+	if err == nil {
+		t.Fatal("Up() with orphaned rows succeeded")
+	}
+	for _, want := range wantOrphans {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Up() error = %v, want it to name %q", err, want)
+		}
+	}
+	if version, err := Version(db); err != nil || version != 17 {
+		t.Errorf("Version() after the failed up = %d, %v, want 17", version, err)
+	}
+
+	// Check only sees what SQLite's own foreign keys can, plus the posts
+	// and tokens of users that are gone
+	problems, err := Check(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 5 {
+		t.Errorf("Check() = %q, want the comments and the orphans of users", problems)
+	}
+}
+
+// TestFixForeignKeysOrphans runs migration 19 by itself on rows migration 18
+// would have refused, it has to leave the tables as they were
+func TestFixForeignKeysOrphans(t *testing.T) {
+	db, _ := orphanedDB(t, 18)
+	list, err := migrationList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fix := list[18]
+	if fix.version != 19 {
+		t.Fatalf("migration 19 is at %d", fix.version)
+	}
+
+	err = run(db, []migration{fix}, false, func(tx *sql.Tx, m migration) error {
+		return m.apply(tx)
+	})
+	if err == nil || !strings.Contains(err.Error(), "point at rows that don't exist") {
+		t.Fatalf("run() of migration 19 error = %v, want the dangling rows", err)
+	}
+	var schema string
+	if err := db.QueryRow("SELECT sql FROM sqlite_master WHERE name = 'comment';").Scan(&schema); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(schema, "CASCADE") {
+		t.Errorf("comment was rebuilt by the failed migration: %s", schema)
+	}
+	var rows int
+	if err := db.QueryRow("SELECT (SELECT COUNT(*) FROM post) + (SELECT COUNT(*) FROM comment) + (SELECT COUNT(*) FROM data_export);").Scan(&rows); err != nil || rows != 7 {
+		t.Errorf("%d rows after the failed migration, %v, want all 7", rows, err)
+	}
+}
+
+func TestForeignKeys(t *testing.T) {
+	db := openTestDB(t)
+	if err := AutoUP(db, slog.New(slog.NewTextHandler(io.Discard, nil))); err != nil {
+		t.Fatal(err)
+	}
+	queries := []string{
+		"INSERT INTO user (id, username, email, salt, password_hash) VALUES (1, 'alice', 'alice@example.com', '', '');",
+		"INSERT INTO user (id, username, email, salt, password_hash) VALUES (2, 'bob', 'bob@example.com', '', '');",
+		"INSERT INTO post (id, user_id, title, body) VALUES (1, 1, 'alice''s', '');",
+		"INSERT INTO post (id, user_id, title, body) VALUES (2, 2, 'bob''s', '');",
+		"INSERT INTO comment (id, post_id, user_id, body) VALUES (1, 1, 2, 'bob on alice''s');",
+		"INSERT INTO comment (id, post_id, user_id, parent_id, body) VALUES (2, 1, 1, 1, 'alice replies');",
+		"INSERT INTO comment (id, post_id, user_id, body) VALUES (3, 2, 1, 'alice on bob''s');",
+		"INSERT INTO forgot_password (user_id, token) VALUES (1, 'token');",
+		"INSERT INTO data_export (user_id, token) VALUES (1, 'token');",
+		"DELETE FROM user WHERE id = 1;",
+	}
+	for _, query := range queries {
+		if _, err := db.Exec(query); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{query: "SELECT COUNT(*) FROM post;", want: 1},
+		{query: "SELECT COUNT(*) FROM forgot_password;", want: 0},
+		{query: "SELECT COUNT(*) FROM data_export;", want: 0},
+		// the comments on alice's post went with it, hers on bob's lost its
+		// author
+		{query: "SELECT COUNT(*) FROM comment;", want: 1},
+		{query: "SELECT COUNT(*) FROM comment WHERE id = 3 AND user_id IS NULL;", want: 1},
+	}
+	for _, tt := range tests {
+		var got int
+		if err := db.QueryRow(tt.query).Scan(&got); err != nil || got != tt.want {
+			t.Errorf("%s = %d, %v, want %d", tt.query, got, err, tt.want)
+		}
+	}
+	if _, err := db.Exec("INSERT INTO comment (post_id, body) VALUES (999, 'nowhere');"); err == nil {
+		t.Error("comment on a post that doesn't exist was inserted")
+	}
+}
@@ -0,0 +1,99 @@
+package migrate
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// sqlFS holds the migrations written in SQL, one NNNN_name.up.sql per version
+// and a NNNN_name.down.sql next to it if it can be undone
+//
+//go:embed sql/*.sql
+var sqlFS embed.FS
+
+var fileName = regexp.MustCompile(`^(\d{4})_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// migrationList is every migration in version order. It's read once, a
+// broken list fails the first migrate call so the server doesn't start.
+var migrationList = sync.OnceValues(func() ([]migration, error) {
+	return parse(sqlFS, "sql", goMigrations)
+})
+
+// parse reads the SQL migrations in dir and merges in the Go ones. Versions
+// have to count up from 1 without gaps and each may only be given once.
+func parse(fsys fs.FS, dir string, coded []migration) ([]migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	byVersion := map[int]*migration{}
+	source := map[int]string{}
+	var errs []error
+	for _, entry := range entries {
+		match := fileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			errs = append(errs, fmt.Errorf("migration file %s isn't named NNNN_name.up.sql or NNNN_name.down.sql", entry.Name()))
+			continue
+		}
+		version, _ := strconv.Atoi(match[1])
+		name := strings.ReplaceAll(match[2], "_", " ")
+		data, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", entry.Name(), err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &migration{version: version, name: name}
+			byVersion[version] = m
+			source[version] = entry.Name()
+		} else if m.name != name {
+			errs = append(errs, fmt.Errorf("migration %d is given twice, by %s and %s", version, source[version], entry.Name()))
+			continue
+		}
+		if match[3] == "up" {
+			m.up = string(data)
+		} else {
+			m.down = string(data)
+		}
+	}
+
+	for _, c := range coded {
+		if _, ok := byVersion[c.version]; ok {
+			errs = append(errs, fmt.Errorf("migration %d is given twice, by %s and the Go migration %q", c.version, source[c.version], c.name))
+			continue
+		}
+		byVersion[c.version] = &c
+	}
+
+	var list []migration
+	for _, m := range byVersion {
+		if m.up == "" && m.upFunc == nil {
+			errs = append(errs, fmt.Errorf("migration %d (%s) has a down but no up", m.version, m.name))
+		}
+		list = append(list, *m)
+	}
+	slices.SortFunc(list, func(a, b migration) int { return a.version - b.version })
+	for i, m := range list {
+		if m.version != i+1 {
+			errs = append(errs, fmt.Errorf("migration %d is missing, the next one is %d", i+1, m.version))
+			break
+		}
+	}
+	if len(list) == 0 {
+		errs = append(errs, errors.New("there are no migrations"))
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, fmt.Errorf("invalid migrations: %w", err)
+	}
+	return list, nil
+}
@@ -0,0 +1,9 @@
+CREATE TABLE user (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	username TEXT UNIQUE NOT NULL,
+	email TEXT UNIQUE NOT NULL,
+	email_verified BOOLEAN NOT NULL DEFAULT FALSE,
+	salt TEXT NOT NULL,
+	password_hash TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
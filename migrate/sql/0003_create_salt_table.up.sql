@@ -0,0 +1,4 @@
+CREATE TABLE salt (
+	intermediate_hash TEXT PRIMARY KEY UNIQUE NOT NULL,
+	salt TEXT NOT NULL
+);
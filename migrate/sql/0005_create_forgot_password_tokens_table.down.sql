@@ -0,0 +1 @@
+DROP TABLE forgot_password;
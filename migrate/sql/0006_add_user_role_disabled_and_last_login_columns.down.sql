@@ -0,0 +1,3 @@
+ALTER TABLE user DROP COLUMN last_login_at;
+ALTER TABLE user DROP COLUMN disabled;
+ALTER TABLE user DROP COLUMN role;
@@ -0,0 +1,3 @@
+ALTER TABLE user ADD COLUMN role TEXT NOT NULL DEFAULT 'user';
+ALTER TABLE user ADD COLUMN disabled BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE user ADD COLUMN last_login_at TIMESTAMP;
@@ -0,0 +1,12 @@
+CREATE TABLE audit_log (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER,
+	actor_id INTEGER,
+	event TEXT NOT NULL,
+	detail TEXT NOT NULL DEFAULT '',
+	ip TEXT NOT NULL,
+	user_agent TEXT NOT NULL,
+	request_id TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX audit_log_user_id ON audit_log (user_id, created_at);
@@ -0,0 +1 @@
+ALTER TABLE user DROP COLUMN deletion_requested_at;
@@ -0,0 +1,9 @@
+CREATE TABLE data_export (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	token TEXT UNIQUE NOT NULL,
+	status TEXT NOT NULL DEFAULT 'pending',
+	archive BLOB,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	expires_at TIMESTAMP
+);
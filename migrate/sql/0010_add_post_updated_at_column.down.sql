@@ -0,0 +1,2 @@
+DROP INDEX post_user_id;
+ALTER TABLE post DROP COLUMN updated_at;
@@ -0,0 +1,2 @@
+ALTER TABLE post ADD COLUMN updated_at TIMESTAMP;
+CREATE INDEX post_user_id ON post (user_id, id);
@@ -0,0 +1 @@
+ALTER TABLE post ADD COLUMN body_html TEXT NOT NULL DEFAULT '';
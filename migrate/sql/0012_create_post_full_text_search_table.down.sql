@@ -0,0 +1,4 @@
+DROP TRIGGER post_fts_update;
+DROP TRIGGER post_fts_delete;
+DROP TRIGGER post_fts_insert;
+DROP TABLE post_fts;
@@ -0,0 +1,4 @@
+ALTER TABLE user DROP COLUMN public_posts;
+ALTER TABLE user DROP COLUMN public_bio;
+ALTER TABLE user DROP COLUMN avatar;
+ALTER TABLE user DROP COLUMN bio;
@@ -0,0 +1,4 @@
+ALTER TABLE user ADD COLUMN bio TEXT NOT NULL DEFAULT '';
+ALTER TABLE user ADD COLUMN avatar TEXT NOT NULL DEFAULT '';
+ALTER TABLE user ADD COLUMN public_bio BOOLEAN NOT NULL DEFAULT TRUE;
+ALTER TABLE user ADD COLUMN public_posts BOOLEAN NOT NULL DEFAULT TRUE;
@@ -0,0 +1,5 @@
+CREATE TABLE blob (
+	key TEXT PRIMARY KEY NOT NULL,
+	data BLOB NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,2 @@
+DROP INDEX comment_post_id;
+DROP TABLE comment;
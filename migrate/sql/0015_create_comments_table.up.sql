@@ -0,0 +1,13 @@
+CREATE TABLE comment (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	post_id INTEGER NOT NULL,
+	user_id INTEGER,
+	parent_id INTEGER,
+	body TEXT NOT NULL,
+	deleted BOOLEAN NOT NULL DEFAULT FALSE,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (post_id) REFERENCES post(id),
+	FOREIGN KEY (user_id) REFERENCES user(id),
+	FOREIGN KEY (parent_id) REFERENCES comment(id)
+);
+CREATE INDEX comment_post_id ON comment (post_id, id);
@@ -0,0 +1,80 @@
+-- puts back the references to users and the tables without cascades that
+-- versions from before 19 were written against
+CREATE TABLE post_new (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	title TEXT NOT NULL,
+	body TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP,
+	body_html TEXT NOT NULL DEFAULT '',
+	FOREIGN KEY (user_id) REFERENCES users(id)
+);
+INSERT INTO post_new (id, user_id, title, body, created_at, updated_at, body_html)
+	SELECT id, user_id, title, body, created_at, updated_at, body_html FROM post;
+DELETE FROM sqlite_sequence WHERE name = 'post_new';
+INSERT INTO sqlite_sequence (name, seq) SELECT 'post_new', seq FROM sqlite_sequence WHERE name = 'post';
+DROP TABLE post;
+ALTER TABLE post_new RENAME TO post;
+CREATE INDEX post_user_id ON post (user_id, id);
+CREATE TRIGGER post_fts_insert AFTER INSERT ON post BEGIN
+	INSERT INTO post_fts (rowid, title, body) VALUES (new.id, new.title, new.body);
+END;
+CREATE TRIGGER post_fts_delete AFTER DELETE ON post BEGIN
+	INSERT INTO post_fts (post_fts, rowid, title, body) VALUES ('delete', old.id, old.title, old.body);
+END;
+CREATE TRIGGER post_fts_update AFTER UPDATE ON post BEGIN
+	INSERT INTO post_fts (post_fts, rowid, title, body) VALUES ('delete', old.id, old.title, old.body);
+	INSERT INTO post_fts (rowid, title, body) VALUES (new.id, new.title, new.body);
+END;
+
+CREATE TABLE forgot_password_new (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	token TEXT NOT NULL,
+	used BOOLEAN NOT NULL DEFAULT FALSE,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES users(id)
+);
+INSERT INTO forgot_password_new (id, user_id, token, used, created_at)
+	SELECT id, user_id, token, used, created_at FROM forgot_password;
+DELETE FROM sqlite_sequence WHERE name = 'forgot_password_new';
+INSERT INTO sqlite_sequence (name, seq) SELECT 'forgot_password_new', seq FROM sqlite_sequence WHERE name = 'forgot_password';
+DROP TABLE forgot_password;
+ALTER TABLE forgot_password_new RENAME TO forgot_password;
+
+CREATE TABLE comment_new (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	post_id INTEGER NOT NULL,
+	user_id INTEGER,
+	parent_id INTEGER,
+	body TEXT NOT NULL,
+	deleted BOOLEAN NOT NULL DEFAULT FALSE,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (post_id) REFERENCES post(id),
+	FOREIGN KEY (user_id) REFERENCES user(id),
+	FOREIGN KEY (parent_id) REFERENCES comment(id)
+);
+INSERT INTO comment_new (id, post_id, user_id, parent_id, body, deleted, created_at)
+	SELECT id, post_id, user_id, parent_id, body, deleted, created_at FROM comment;
+DELETE FROM sqlite_sequence WHERE name = 'comment_new';
+INSERT INTO sqlite_sequence (name, seq) SELECT 'comment_new', seq FROM sqlite_sequence WHERE name = 'comment';
+DROP TABLE comment;
+ALTER TABLE comment_new RENAME TO comment;
+CREATE INDEX comment_post_id ON comment (post_id, id);
+
+CREATE TABLE data_export_new (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	token TEXT UNIQUE NOT NULL,
+	status TEXT NOT NULL DEFAULT 'pending',
+	archive BLOB,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	expires_at TIMESTAMP
+);
+INSERT INTO data_export_new (id, user_id, token, status, archive, created_at, expires_at)
+	SELECT id, user_id, token, status, archive, created_at, expires_at FROM data_export;
+DELETE FROM sqlite_sequence WHERE name = 'data_export_new';
+INSERT INTO sqlite_sequence (name, seq) SELECT 'data_export_new', seq FROM sqlite_sequence WHERE name = 'data_export';
+DROP TABLE data_export;
+ALTER TABLE data_export_new RENAME TO data_export;
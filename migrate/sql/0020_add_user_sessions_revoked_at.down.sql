@@ -0,0 +1 @@
+ALTER TABLE user DROP COLUMN sessions_revoked_at;
@@ -0,0 +1 @@
+ALTER TABLE user ADD COLUMN sessions_revoked_at INTEGER NOT NULL DEFAULT 0;